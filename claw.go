@@ -1,6 +1,7 @@
 package main
 
 import "os"
+import "fmt"
 import "os/signal"
 import "os/user"
 import "path/filepath"
@@ -12,6 +13,16 @@ import "github.com/cnf/go-claw/clog"
 
 var cfgfile string
 var verbose bool
+var learn bool
+var watch bool
+var check bool
+
+// importers are the commands printing config sections converted from
+// other remote configurations
+var importers = map[string]func(args []string) int{
+    "import-lirc": importLirc,
+    "import-harmony": importHarmony,
+}
 
 func main() {
     defer clog.Stop()
@@ -25,6 +36,11 @@ func main() {
     }()
 
     setup()
+    if importer, ok := importers[flag.Arg(0)]; ok {
+        ret := importer(flag.Args()[1:])
+        clog.Stop()
+        os.Exit(ret)
+    }
     clog.SetFlags(clog.Lshortlevel | clog.Ltimebetween | clog.Ltime)
     if verbose {
         clog.SetLogLevel(clog.DEBUG)
@@ -36,8 +52,16 @@ func main() {
     registerAllListeners()
     registerAllTargets()
 
+    if check {
+        ret := checkConfig(cfgfile)
+        clog.Stop()
+        os.Exit(ret)
+    }
+
     dispatch := dispatcher.Dispatcher{}
     dispatch.Configfile = cfgfile
+    dispatch.Learn = learn
+    dispatch.Watch = watch
 
     dispatch.Start()
 }
@@ -59,8 +83,29 @@ func setup() {
         // cfg.Home, _ = filepath.Abs(usr.HomeDir)
         cfgfile = filepath.Join(home, ".config/claw/config.json")
     }
-    flag.StringVar(&cfgfile, "conf", cfgfile, "path to our config file.")
+    flag.StringVar(&cfgfile, "conf", cfgfile, "path to our config file, in JSON, YAML or TOML by extension.")
     flag.BoolVar(&verbose, "v", verbose, "turn on verbose logging")
+    flag.BoolVar(&watch, "watch", watch, "reload the config file when it changes")
+    flag.BoolVar(&check, "check", check, "check the config file and exit")
+    flag.BoolVar(&learn, "learn", learn, "start in learning mode, asking for the names of new keys")
     flag.Parse()
     cfgfile, _ = filepath.Abs(cfgfile)
 }
+
+// checkConfig prints the problems found in the config file, and returns the
+// exit code
+func checkConfig(path string) int {
+    problems, err := dispatcher.CheckConfig(path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+        return 1
+    }
+    for _, p := range problems {
+        fmt.Printf("%s\n", p)
+    }
+    if len(problems) > 0 {
+        return 1
+    }
+    fmt.Printf("%s: config OK\n", path)
+    return 0
+}
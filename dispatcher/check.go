@@ -0,0 +1,221 @@
+package dispatcher
+
+import "fmt"
+import "sort"
+import "strings"
+import "path/filepath"
+
+import "github.com/cnf/go-claw/clog"
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/targets"
+
+// Placeholders filled in by the dispatcher, replaced by a sample value when
+// checking actions
+var checkPlaceholders = strings.NewReplacer("{digits}", "1", "{step}", "1")
+
+// CheckConfig checks a config file without connecting to anything: every
+// listener and target module, and every action string of every mode is
+// checked against the commands of the targets. It returns the problems
+// found, or an error if the file could not be read at all.
+func CheckConfig(path string) ([]string, error) {
+    c, err := checkConfigFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return c.problems, nil
+}
+
+func checkConfigFile(path string) (*checker, error) {
+    cfg, err := loadConfig(path)
+    if err != nil {
+        return nil, err
+    }
+    return checkLoadedConfig(&cfg, path), nil
+}
+
+// checkLoadedConfig checks a config read from path
+func checkLoadedConfig(cfg *Config, path string) *checker {
+    c := &checker{file: filepath.Base(path), pos: cfg.pos, parsed: make(map[string]condition)}
+    c.check(cfg)
+    return c
+}
+
+// checkConfig checks a loaded config and logs the problems found. It returns
+// the conditions it parsed, for the dispatcher to keep once it runs the
+// config.
+func (d *Dispatcher) checkConfig(cfg *Config) map[string]condition {
+    c := checkLoadedConfig(cfg, d.Configfile)
+    for _, p := range c.problems {
+        clog.Warn("Config: %s", p)
+    }
+    return c.parsed
+}
+
+type checker struct {
+    file string
+    pos positions
+    problems []string
+    // parsed holds the conditions without placeholders
+    parsed map[string]condition
+}
+
+// report adds a problem found at the first of the paths known
+func (c *checker) report(paths []string, format string, a ...interface{}) {
+    msg := fmt.Sprintf(format, a...)
+    c.problems = append(c.problems, where(c.pos, c.file, paths...) + ": " + msg)
+}
+
+func sortedKeys(m interface{}) []string {
+    var ret []string
+    switch v := m.(type) {
+    case map[string]ConfigListener:
+        for k := range v {
+            ret = append(ret, k)
+        }
+    case map[string]ConfigTarget:
+        for k := range v {
+            ret = append(ret, k)
+        }
+    case map[string]*modes.Mode:
+        for k := range v {
+            ret = append(ret, k)
+        }
+    case map[string]*modes.Binding:
+        for k := range v {
+            ret = append(ret, k)
+        }
+    case map[string]modes.Actions:
+        for k := range v {
+            ret = append(ret, k)
+        }
+    case map[string]string:
+        for k := range v {
+            ret = append(ret, k)
+        }
+    }
+    sort.Strings(ret)
+    return ret
+}
+
+func (c *checker) check(cfg *Config) {
+    for _, k := range sortedKeys(cfg.Listeners) {
+        v := cfg.Listeners[k]
+        if !listeners.CheckListener(v.Module, v.Params) {
+            c.report([]string{"listeners/" + k}, "listener `%s`: could not create a `%s` listener", k, v.Module)
+        }
+    }
+
+    m := &modes.Modes{}
+    if err := m.Setup(cfg.Modes); err != nil {
+        c.report([]string{"modes"}, "%s", err.Error())
+    }
+    tm := targets.NewTargetManager(m)
+    tm.SetMacros(cfg.Macros)
+    for _, k := range sortedKeys(cfg.Targets) {
+        v := cfg.Targets[k]
+        if err := tm.AddMetadata(v.Module, k, v.Params); err != nil {
+            c.report([]string{"targets/" + k}, "target `%s`: %s", k, err.Error())
+        }
+    }
+
+    for _, name := range sortedKeys(cfg.Macros) {
+        path := "macros/" + name
+        cfg.Macros[name].Map(func(index, action string) string {
+            if err := tm.CheckMacro(name, checkPlaceholders.Replace(action)); err != nil {
+                c.report([]string{path + "/" + index, path}, "macro `%s`: `%s`: %s", name, action, err.Error())
+            }
+            return action
+        })
+        c.conditions(tm, path, cfg.Macros[name], "macro `" + name + "`")
+    }
+
+    for _, name := range sortedKeys(cfg.Modes) {
+        mode := cfg.Modes[name]
+        if mode == nil {
+            continue
+        }
+        base := "modes/" + name
+        c.actions(tm, base + "/entry", mode.Entry, "mode `%s`, entry", name)
+        c.actions(tm, base + "/exit", mode.Exit, "mode `%s`, exit", name)
+        if mode.Digits != nil {
+            c.actions(tm, base + "/digits/actions", mode.Digits.Actions, "mode `%s`, digits", name)
+        }
+        if mode.OnError != nil {
+            c.actions(tm, base + "/onerror/fallback", mode.OnError.Fallback, "mode `%s`, fallback", name)
+        }
+        for _, key := range sortedKeys(mode.Keys) {
+            b := mode.Keys[key]
+            if b == nil {
+                continue
+            }
+            kbase := base + "/keys/" + key
+            if b.OnError != nil {
+                c.actions(tm, kbase + "/onerror/fallback", b.OnError.Fallback, "mode `%s`, key `%s`, fallback", name, key)
+            }
+            for _, event := range []string{"press", "hold", "release", "long", "double"} {
+                actions := b.Actions(event)
+                if (event == "hold") && sameActions(b.Press, b.Hold) {
+                    // A plain list of actions, checked as press
+                    continue
+                }
+                c.actions(tm, kbase + "/" + event, actions, "mode `%s`, key `%s`", name, key)
+            }
+        }
+    }
+}
+
+// sameActions returns true if two action lists are the same slice
+func sameActions(a, b modes.Actions) bool {
+    return (len(a) > 0) && (len(a) == len(b)) && (&a[0] == &b[0])
+}
+
+// actions checks a list of actions, found at path in the config
+func (c *checker) actions(tm *targets.TargetManager, path string, actions modes.Actions, format string, a ...interface{}) {
+    where := fmt.Sprintf(format, a...)
+    // Plain lists of actions have no event in their path, and the elements
+    // of some lists have no position of their own
+    parent := path[:strings.LastIndex(path, "/")]
+    actions.Map(func(index, action string) string {
+        idx := "/" + index
+        if err := tm.Check(checkPlaceholders.Replace(action)); err != nil {
+            c.report([]string{path + idx, parent + idx, path, parent}, "%s: `%s`: %s", where, action, err.Error())
+        }
+        return action
+    })
+    c.conditions(tm, path, actions, where)
+}
+
+// conditions checks the conditions of the conditional actions in a list,
+// and that the targets they query report those states
+func (c *checker) conditions(tm *targets.TargetManager, path string, actions modes.Actions, where string) {
+    parent := path[:strings.LastIndex(path, "/")]
+    actions.MapConditions(func(index, cond string) string {
+        idx := "/" + index
+        if strings.Contains(checkPlaceholders.Replace(cond), "{") {
+            // Not filled in yet, like the arguments of a macro
+            return cond
+        }
+        paths := []string{path + idx, parent + idx, path, parent}
+        parsed, err := parseCondition(checkPlaceholders.Replace(cond))
+        if err != nil {
+            c.report(paths, "%s: `if %s`: %s", where, cond, err.Error())
+            return cond
+        }
+        if cond == checkPlaceholders.Replace(cond) {
+            c.parsed[cond] = parsed
+        }
+        for _, group := range parsed {
+            for _, cmp := range group {
+                if !stateRef.MatchString(cmp.left) {
+                    continue
+                }
+                ref := strings.SplitN(cmp.left, "::", 2)
+                if err := tm.CheckState(ref[0], ref[1]); err != nil {
+                    c.report(paths, "%s: `if %s`: %s", where, cond, err.Error())
+                }
+            }
+        }
+        return cond
+    })
+}
@@ -0,0 +1,169 @@
+package dispatcher
+
+import "os"
+import "strings"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/cnf/go-claw/targets"
+
+func init() {
+    targets.RegisterTarget("checktest", func(name string, params map[string]string) (targets.Target, error) {
+        return nil, nil
+    })
+    targets.RegisterCommands("checktest", func(params map[string]string) map[string]*targets.Command {
+        return map[string]*targets.Command{
+            "VolumeUp": targets.NewCommand("Volume up"),
+            "Input": targets.NewCommand("Select an input",
+                targets.NewParameter("input", "the input").SetList("tv", "dvd"),
+            ),
+            "Channel": targets.NewCommand("Select a channel",
+                targets.NewParameter("channel", "the channel").SetNumeric(),
+            ),
+        }
+    })
+}
+
+func Test_CheckConfig(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    cfgfile := filepath.Join(dir, "config.json")
+    ioutil.WriteFile(cfgfile, []byte(`{
+    "targets": {
+        "AVR": {"module": "checktest"},
+        "Bad": {"module": "nosuchmodule"}
+    },
+    "modes": {
+        "default": {
+            "entry": ["AVR::input tv"],
+            "keys": {
+                "KEY_VOLUMEUP": ["AVR::VolumeUpp"],
+                "KEY_OK": {
+                    "press": ["AVR::VolumeUp", "TV::select"],
+                    "long": ["claw::mode nosuchmode"]
+                },
+                "KEY_1": ["AVR::input"]
+            },
+            "digits": {"actions": ["AVR::channel {digits}"]}
+        },
+        "tv": {
+            "exit": ["AVR::input vcr"],
+            "keys": {"KEY_OK": ["claw::mode default"]}
+        }
+    }
+}`), 0644)
+
+    problems, err := CheckConfig(cfgfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect := []string{
+        "config.json:4: target `Bad`: ",
+        "config.json:15: mode `default`, key `KEY_1`: `AVR::input`: ",
+        "config.json:12: mode `default`, key `KEY_OK`: `TV::select`: unknown target 'tv'",
+        "config.json:13: mode `default`, key `KEY_OK`: `claw::mode nosuchmode`: ",
+        "config.json:10: mode `default`, key `KEY_VOLUMEUP`: `AVR::VolumeUpp`: target 'avr' has no command 'volumeupp'",
+        "config.json:20: mode `tv`, exit: `AVR::input vcr`: ",
+    }
+    if len(problems) != len(expect) {
+        t.Fatalf("expected %d problems, got %d: %q", len(expect), len(problems), problems)
+    }
+    for i, p := range problems {
+        if !strings.HasPrefix(p, expect[i]) {
+            t.Errorf("problem %d: expected `%s...`, got `%s`", i, expect[i], p)
+        }
+    }
+
+    if _, err := CheckConfig(filepath.Join(dir, "missing.json")); err == nil {
+        t.Errorf("expected an error for a missing config file")
+    }
+}
+
+func Test_CheckLoadedConfig(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    cfgfile := writeConfig(t, dir, "config.json", `{
+    "listeners": {"remote": {"module": "nosuchmodule"}},
+    "targets": {"AVR": {"module": "checktest"}},
+    "modes": {"default": {"keys": {"KEY_OK": ["AVR::nope"]}}}
+}`)
+    cfg, err := loadConfig(cfgfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The loaded config is checked, not the file as it is now
+    writeConfig(t, dir, "config.json", `{}`)
+    c := checkLoadedConfig(&cfg, cfgfile)
+    expect := []string{
+        "config.json:2: listener `remote`: could not create a `nosuchmodule` listener",
+        "config.json:4: mode `default`, key `KEY_OK`: `AVR::nope`: target 'avr' has no command 'nope'",
+    }
+    if len(c.problems) != len(expect) {
+        t.Fatalf("expected %d problems, got %d: %q", len(expect), len(c.problems), c.problems)
+    }
+    for i, p := range c.problems {
+        if p != expect[i] {
+            t.Errorf("problem %d: expected `%s`, got `%s`", i, expect[i], p)
+        }
+    }
+}
+
+func Test_CheckMacros(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    cfgfile := writeConfig(t, dir, "config.json", `{
+    "targets": {"AVR": {"module": "checktest"}},
+    "macros": {
+        "input": ["AVR::VolumeUp", "AVR::input {1}"],
+        "channel": ["macro::input tv", "AVR::channel {1}"],
+        "broken": ["TV::select", "macro::nope"],
+        "loop": ["macro::loop"]
+    },
+    "modes": {
+        "default": {
+            "keys": {
+                "KEY_1": ["macro::input dvd"],
+                "KEY_2": ["macro::input vcr"],
+                "KEY_3": ["macro::input"],
+                "KEY_4": ["claw::macro channel {digits}"],
+                "KEY_5": [{"if": "AVR::power is off", "then": ["AVR::VolumeUp"], "else": ["AVR::nope"]}],
+                "KEY_6": [{"if": "claw::mode == tv and AVR::power == on", "then": ["AVR::VolumeUp"]}],
+                "KEY_7": [{"if": "claw::power == on", "then": ["AVR::VolumeUp"]}]
+            }
+        }
+    }
+}`)
+    problems, err := CheckConfig(cfgfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect := []string{
+        "config.json:6: macro `broken`: `TV::select`: unknown target 'tv'",
+        "config.json:6: macro `broken`: `macro::nope`: unknown macro 'nope'",
+        "config.json:7: macro `loop`: `macro::loop`: macro 'loop' calls itself: loop -> loop",
+        "config.json:13: mode `default`, key `KEY_2`: `macro::input vcr`: `AVR::input vcr`: ",
+        "config.json:14: mode `default`, key `KEY_3`: `macro::input`: macro 'input' takes 1 arguments, got 0",
+        "config.json:16: mode `default`, key `KEY_5`: `AVR::nope`: target 'avr' has no command 'nope'",
+        "config.json:16: mode `default`, key `KEY_5`: `if AVR::power is off`: unknown comparison `is`",
+        "config.json:17: mode `default`, key `KEY_6`: `if claw::mode == tv and AVR::power == on`: target 'avr' can not report its state",
+        "config.json:18: mode `default`, key `KEY_7`: `if claw::power == on`: target 'claw' has no state 'power', only mode",
+    }
+    if len(problems) != len(expect) {
+        t.Fatalf("expected %d problems, got %d: %q", len(expect), len(problems), problems)
+    }
+    for i, p := range problems {
+        if !strings.HasPrefix(p, expect[i]) {
+            t.Errorf("problem %d: expected `%s...`, got `%s`", i, expect[i], p)
+        }
+    }
+}
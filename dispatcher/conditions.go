@@ -0,0 +1,225 @@
+package dispatcher
+
+import "fmt"
+import "sync"
+import "time"
+import "regexp"
+import "strconv"
+import "strings"
+
+// Conditional actions run their then or else actions depending on a
+// condition, evaluated here when they run. A condition compares a value
+// with a constant:
+//
+//   AVR::power == off   the state of a target, like for claw::wait
+//   mode != tv          the name of the active mode
+//   time >= 22:00       the time of day
+//   repeat > 5          the repeat count of the key
+//
+// The comparisons are ==, !=, <, <=, > and >=, and can be combined with
+// "and" and "or", "and" binding stronger:
+//
+//   time >= 22:00 or time < 06:30
+
+// timeNow returns the time of day for conditions
+var timeNow = time.Now
+
+var stateRef = regexp.MustCompile(`^[^:\s]+::\S+$`)
+
+type comparison struct {
+    left, op, right string
+}
+
+// condition holds groups of comparisons, it holds when all comparisons of
+// one of the groups hold
+type condition [][]comparison
+
+// parseCondition parses and checks a condition
+func parseCondition(s string) (condition, error) {
+    fields := strings.Fields(s)
+    var ret condition
+    var group []comparison
+    for i := 0; ; i += 4 {
+        if i + 3 > len(fields) {
+            return nil, fmt.Errorf("incomplete condition `%s`", s)
+        }
+        c := comparison{fields[i], fields[i + 1], fields[i + 2]}
+        if err := c.check(); err != nil {
+            return nil, err
+        }
+        group = append(group, c)
+        if i + 3 == len(fields) {
+            break
+        }
+        switch strings.ToLower(fields[i + 3]) {
+        case "and":
+        case "or":
+            ret = append(ret, group)
+            group = nil
+        default:
+            return nil, fmt.Errorf("expected `and` or `or` after `%s %s %s`", c.left, c.op, c.right)
+        }
+    }
+    return append(ret, group), nil
+}
+
+// check checks the operator, and the constant for the time and repeat count
+func (c comparison) check() error {
+    switch c.op {
+    case "==", "!=", "<", "<=", ">", ">=":
+    default:
+        return fmt.Errorf("unknown comparison `%s`", c.op)
+    }
+    switch strings.ToLower(c.left) {
+    case "mode":
+        if (c.op != "==") && (c.op != "!=") {
+            return fmt.Errorf("the mode can only be compared with == or !=")
+        }
+    case "time":
+        if _, err := parseClock(c.right); err != nil {
+            return err
+        }
+    case "repeat":
+        if _, err := strconv.Atoi(c.right); err != nil {
+            return fmt.Errorf("`%s` is not a repeat count", c.right)
+        }
+    default:
+        if !stateRef.MatchString(c.left) {
+            return fmt.Errorf("`%s` is not `mode`, `time`, `repeat` or a target state like `AVR::power`", c.left)
+        }
+    }
+    return nil
+}
+
+// clock matches a time of day, with or without a leading zero
+var clock = regexp.MustCompile(`^([01]?[0-9]|2[0-3]):([0-5][0-9])$`)
+
+// parseClock returns the minutes since midnight of a time like "22:00" or
+// "6:30"
+func parseClock(s string) (int, error) {
+    m := clock.FindStringSubmatch(s)
+    if m == nil {
+        return 0, fmt.Errorf("`%s` is not a time of day like 22:00", s)
+    }
+    h, _ := strconv.Atoi(m[1])
+    min, _ := strconv.Atoi(m[2])
+    return h * 60 + min, nil
+}
+
+// parsedConditions holds the conditions of the config, parsed when it was
+// loaded
+type parsedConditions struct {
+    mu sync.RWMutex
+    parsed map[string]condition
+}
+
+// set replaces the parsed conditions
+func (p *parsedConditions) set(parsed map[string]condition) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.parsed = parsed
+}
+
+// get returns a parsed condition. Conditions filled in when their actions
+// run, like the arguments of a macro, are parsed now.
+func (p *parsedConditions) get(s string) (condition, error) {
+    p.mu.RLock()
+    cond, ok := p.parsed[s]
+    p.mu.RUnlock()
+    if ok {
+        return cond, nil
+    }
+    return parseCondition(s)
+}
+
+// conditions evaluates the conditions of the actions run for a key
+type conditions struct {
+    d *Dispatcher
+    repeat int
+}
+
+// Eval evaluates a condition, querying only the target states it needs
+func (c *conditions) Eval(s string) (bool, error) {
+    cond, err := c.d.parsed.get(s)
+    if err != nil {
+        return false, err
+    }
+    for _, group := range cond {
+        all := true
+        for _, cmp := range group {
+            ok, err := c.compare(cmp)
+            if err != nil {
+                return false, err
+            }
+            if !ok {
+                all = false
+                break
+            }
+        }
+        if all {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func (c *conditions) compare(cmp comparison) (bool, error) {
+    switch strings.ToLower(cmp.left) {
+    case "mode":
+        return compareStrings(c.d.modes.ActiveName(), cmp.op, cmp.right)
+    case "time":
+        now := timeNow()
+        clock, _ := parseClock(cmp.right)
+        return compareOrder(now.Hour() * 60 + now.Minute() - clock, cmp.op), nil
+    case "repeat":
+        count, _ := strconv.Atoi(cmp.right)
+        return compareOrder(c.repeat - count, cmp.op), nil
+    }
+    ref := strings.SplitN(cmp.left, "::", 2)
+    value, err := c.d.targetmanager.State(ref[0], ref[1])
+    if err != nil {
+        return false, err
+    }
+    l, lerr := strconv.ParseFloat(value, 64)
+    r, rerr := strconv.ParseFloat(cmp.right, 64)
+    if (lerr == nil) && (rerr == nil) {
+        switch {
+        case l < r:
+            return compareOrder(-1, cmp.op), nil
+        case l > r:
+            return compareOrder(1, cmp.op), nil
+        }
+        return compareOrder(0, cmp.op), nil
+    }
+    return compareStrings(value, cmp.op, cmp.right)
+}
+
+// compareStrings compares values that are not numbers, ignoring case
+func compareStrings(value, op, right string) (bool, error) {
+    switch op {
+    case "==":
+        return strings.EqualFold(value, right), nil
+    case "!=":
+        return !strings.EqualFold(value, right), nil
+    }
+    return false, fmt.Errorf("can not compare `%s` with `%s` using %s", value, right, op)
+}
+
+// compareOrder returns the result of op, for the difference of two values
+func compareOrder(diff int, op string) bool {
+    switch op {
+    case "==":
+        return diff == 0
+    case "!=":
+        return diff != 0
+    case "<":
+        return diff < 0
+    case "<=":
+        return diff <= 0
+    case ">":
+        return diff > 0
+    case ">=":
+        return diff >= 0
+    }
+    return false
+}
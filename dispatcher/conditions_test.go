@@ -0,0 +1,101 @@
+package dispatcher
+
+import "time"
+import "strings"
+import "testing"
+
+import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/targets"
+
+// condTarget reports a fixed state for conditions
+type condTarget map[string]string
+
+func (c condTarget) SendCommand(cmd string, args ...string) error {
+    return nil
+}
+
+func (c condTarget) Stop() error {
+    return nil
+}
+
+func (c condTarget) Commands() map[string]*targets.Command {
+    return nil
+}
+
+func (c condTarget) State(name string) (string, error) {
+    return c[name], nil
+}
+
+func init() {
+    targets.RegisterTarget("condtest", func(name string, params map[string]string) (targets.Target, error) {
+        return condTarget{"power": "off", "volume": "35"}, nil
+    })
+}
+
+func Test_Conditions(t *testing.T) {
+    m := &modes.Modes{}
+    if err := m.Setup(map[string]*modes.Mode{"default": {}, "tv": {}}); err != nil {
+        t.Fatal(err)
+    }
+    // Before a mode is set, the default mode is active
+    if ok, err := (&conditions{&Dispatcher{modes: m}, 0}).Eval("mode == default"); !ok || (err != nil) {
+        t.Errorf("expected the default mode at startup, got %t (%v)", ok, err)
+    }
+    m.SetActive("tv")
+    d := &Dispatcher{modes: m, targetmanager: targets.NewTargetManager(m)}
+    defer d.targetmanager.Stop()
+    d.targetmanager.Add("condtest", "AVR", nil)
+    defer func() { timeNow = time.Now }()
+    timeNow = func() time.Time {
+        return time.Date(2026, 1, 1, 23, 15, 0, 0, time.Local)
+    }
+
+    c := &conditions{d, 3}
+    tests := map[string]bool{
+        "AVR::power == off": true,
+        "AVR::power == ON": false,
+        "AVR::volume > 30 and AVR::volume <= 35": true,
+        "AVR::volume > 100": false,
+        "mode == tv": true,
+        "mode != tv or repeat == 3": true,
+        "mode != tv or repeat > 3": false,
+        "time >= 22:00 or time < 06:30": true,
+        "time >= 23:15 and time < 23:16": true,
+        "time > 6:30 and time < 9:00": false,
+        "time >= 08:00 and time < 22:00": false,
+        "repeat >= 1 and AVR::power != on": true,
+    }
+    for cond, expect := range tests {
+        if ok, err := c.Eval(cond); (err != nil) || (ok != expect) {
+            t.Errorf("`%s`: expected %t, got %t (%v)", cond, expect, ok, err)
+        }
+    }
+
+    errors := map[string]string{
+        "": "incomplete condition",
+        "AVR::power == off and": "incomplete condition",
+        "AVR::power = off": "unknown comparison `=`",
+        "power == off": "`power` is not `mode`, `time`, `repeat` or a target state",
+        "time > 25:00": "`25:00` is not a time of day",
+        "time > 6:5": "`6:5` is not a time of day",
+        "time > +6:30": "`+6:30` is not a time of day",
+        "repeat > many": "`many` is not a repeat count",
+        "mode > tv": "the mode can only be compared with == or !=",
+        "AVR::power == off then": "expected `and` or `or` after `AVR::power == off`",
+        "AVR::power > off": "can not compare `off` with `off` using >",
+        "TV::power == off": "unknown target 'tv'",
+    }
+    for cond, expect := range errors {
+        if _, err := c.Eval(cond); (err == nil) || !strings.HasPrefix(err.Error(), expect) {
+            t.Errorf("`%s`: expected error `%s`, got %v", cond, expect, err)
+        }
+    }
+    // Conditions parsed when the config was loaded are not parsed again
+    d.parsed.set(map[string]condition{"mode is tv": {{{"mode", "==", "tv"}}}})
+    if ok, err := c.Eval("mode is tv"); !ok || (err != nil) {
+        t.Errorf("expected the parsed condition to hold, got %t (%v)", ok, err)
+    }
+    if compareOrder(0, "=") {
+        t.Errorf("expected an unknown comparison not to hold")
+    }
+}
@@ -3,15 +3,31 @@ package dispatcher
 import "github.com/cnf/go-claw/modes"
 
 type Config struct {
+    // Config files to merge into this one, relative to it. Entries are
+    // glob patterns or directories, like "conf.d".
+    Include []string
+    // Variables used as ${name} in parameters and actions
+    Vars map[string]string
+    // Lists of actions run by "macro::name"
+    Macros map[string]modes.Actions
     // Modes map[string]map[string][]string `json:"mode"`
     Listeners map[string]ConfigListener
     Modes map[string]*modes.Mode
     Targets map[string]ConfigTarget
+    Translate Translations
+    Learned []LearnedKey
+
+    // All files and directories the config was read from
+    files []string
+    // Positions of the values in those files
+    pos positions
 }
 
 type ConfigListener struct {
     Module string
     Params map[string]string
+    // Replaces a listener of the same name from an earlier config file
+    Override bool
 }
 
 type ConfigMode map[string]Actionlist
@@ -19,6 +35,8 @@ type ConfigMode map[string]Actionlist
 type Actionlist []string
 
 type ConfigTarget struct {
-    Module string
-    Params map[string]string
+    Module string            `json:"module"`
+    Params map[string]string `json:"params"`
+    // Replaces a target of the same name from an earlier config file
+    Override bool            `json:"override,omitempty"`
 }
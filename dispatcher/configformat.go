@@ -0,0 +1,401 @@
+package dispatcher
+
+import "fmt"
+import "bytes"
+import "regexp"
+import "strings"
+import "strconv"
+import "path/filepath"
+import "encoding/json"
+
+import "gopkg.in/yaml.v3"
+import "github.com/BurntSushi/toml"
+
+// Config files can be written in JSON, YAML or TOML, chosen by the extension
+// of the file. YAML and TOML are converted to JSON, so they map onto the
+// same structures.
+
+// lineError is an error at a line of a config file
+type lineError struct {
+    line int
+    msg string
+}
+
+func (e *lineError) Error() string {
+    return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// configFormat returns the format of a config file: "json", "yaml" or "toml"
+func configFormat(path string) string {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        return "yaml"
+    case ".toml":
+        return "toml"
+    }
+    return "json"
+}
+
+// toJSON converts a config file to JSON, and returns the positions of its
+// values in the original file
+func toJSON(format string, data []byte) ([]byte, positions, error) {
+    switch format {
+    case "yaml":
+        return yamlToJSON(data)
+    case "toml":
+        return tomlToJSON(data)
+    }
+    return data, jsonPositions(data), nil
+}
+
+// jsonError adds the line number to an error decoding the JSON converted
+// from a config file
+func jsonError(err error, format string, data []byte, pos positions) error {
+    var offset int64
+    switch e := err.(type) {
+    case *json.SyntaxError:
+        offset = e.Offset
+    case *json.UnmarshalTypeError:
+        offset = e.Offset
+    default:
+        return err
+    }
+    line := 0
+    if format == "json" {
+        line = bytes.Count(data[:offset], []byte("\n")) + 1
+    } else {
+        line = pos.line(jsonPathAt(data, int(offset)))
+    }
+    if line == 0 {
+        return err
+    }
+    return &lineError{line, err.Error()}
+}
+
+var yamlLineError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func yamlToJSON(data []byte) ([]byte, positions, error) {
+    var doc yaml.Node
+    if err := yaml.Unmarshal(data, &doc); err != nil {
+        if m := yamlLineError.FindStringSubmatch(err.Error()); m != nil {
+            line, _ := strconv.Atoi(m[1])
+            return nil, nil, &lineError{line, m[2]}
+        }
+        return nil, nil, err
+    }
+    pos := make(positions)
+    v, err := yamlValue(&doc, "", pos)
+    if err != nil {
+        return nil, nil, err
+    }
+    if v == nil {
+        v = map[string]interface{}{}
+    }
+    out, err := json.Marshal(v)
+    return out, pos, err
+}
+
+func joinPath(path, name string) string {
+    if path == "" {
+        return name
+    }
+    return path + "/" + name
+}
+
+// yamlValue converts a YAML node, recording the positions of its values
+func yamlValue(n *yaml.Node, path string, pos positions) (interface{}, error) {
+    if path != "" {
+        pos.add(path, n.Line)
+    }
+    switch n.Kind {
+    case yaml.DocumentNode:
+        if len(n.Content) == 0 {
+            return nil, nil
+        }
+        return yamlValue(n.Content[0], path, pos)
+    case yaml.AliasNode:
+        return yamlValue(n.Alias, path, pos)
+    case yaml.SequenceNode:
+        ret := make([]interface{}, len(n.Content))
+        for i, c := range n.Content {
+            v, err := yamlValue(c, joinPath(path, strconv.Itoa(i)), pos)
+            if err != nil {
+                return nil, err
+            }
+            ret[i] = v
+        }
+        return ret, nil
+    case yaml.MappingNode:
+        ret := make(map[string]interface{})
+        var merges []*yaml.Node
+        for i := 0; i + 1 < len(n.Content); i += 2 {
+            k, c := n.Content[i], n.Content[i + 1]
+            if k.Tag == "!!merge" {
+                merges = append(merges, c)
+                continue
+            }
+            pos.add(joinPath(path, k.Value), k.Line)
+            v, err := yamlValue(c, joinPath(path, k.Value), pos)
+            if err != nil {
+                return nil, err
+            }
+            ret[k.Value] = v
+        }
+        // Merged mappings, the keys set in the mapping itself win
+        for _, m := range merges {
+            if m.Kind == yaml.AliasNode {
+                m = m.Alias
+            }
+            list := []*yaml.Node{m}
+            if m.Kind == yaml.SequenceNode {
+                list = m.Content
+            }
+            for _, c := range list {
+                v, err := yamlValue(c, path, pos)
+                if err != nil {
+                    return nil, err
+                }
+                mm, ok := v.(map[string]interface{})
+                if !ok {
+                    return nil, &lineError{c.Line, "can only merge mappings"}
+                }
+                for k, v := range mm {
+                    if _, ok := ret[k]; !ok {
+                        ret[k] = v
+                    }
+                }
+            }
+        }
+        return ret, nil
+    }
+    var v interface{}
+    if err := n.Decode(&v); err != nil {
+        return nil, &lineError{n.Line, err.Error()}
+    }
+    return v, nil
+}
+
+func tomlToJSON(data []byte) ([]byte, positions, error) {
+    var v map[string]interface{}
+    if _, err := toml.Decode(string(data), &v); err != nil {
+        if pe, ok := err.(toml.ParseError); ok {
+            return nil, nil, &lineError{pe.Position.Line, pe.Message}
+        }
+        return nil, nil, err
+    }
+    if v == nil {
+        v = map[string]interface{}{}
+    }
+    out, err := json.Marshal(v)
+    return out, tomlPositions(data), err
+}
+
+// tomlScanner finds the lines of the values in a TOML document the decoder
+// accepted, which does not report them itself
+type tomlScanner struct {
+    data string
+    i int
+    line int
+    pos positions
+    // arrays counts the tables of each array of tables
+    arrays map[string]int
+}
+
+// tomlPositions records the line numbers of the tables, keys and array
+// elements in a TOML document
+func tomlPositions(data []byte) positions {
+    s := &tomlScanner{data: string(data), line: 1, pos: make(positions), arrays: make(map[string]int)}
+    table := ""
+    for {
+        s.skip(true)
+        if s.i >= len(s.data) {
+            return s.pos
+        }
+        if s.data[s.i] != '[' {
+            s.keyValue(table)
+            continue
+        }
+        array := strings.HasPrefix(s.data[s.i:], "[[")
+        if array {
+            s.i += 2
+        } else {
+            s.i++
+        }
+        table = s.table(s.key(), array)
+        for (s.i < len(s.data)) && (s.data[s.i] != '\n') {
+            s.i++
+        }
+    }
+}
+
+// table returns the path of a table header, and records it and its parents
+func (s *tomlScanner) table(parts []string, array bool) string {
+    path := ""
+    for i, part := range parts {
+        path = joinPath(path, part)
+        s.pos.add(path, s.line)
+        if n, ok := s.arrays[path]; ok && ((i < len(parts) - 1) || !array) {
+            path = joinPath(path, strconv.Itoa(n - 1))
+        }
+    }
+    if array {
+        name := path
+        path = joinPath(name, strconv.Itoa(s.arrays[name]))
+        s.arrays[name]++
+        s.pos.add(path, s.line)
+    }
+    return path
+}
+
+// keyValue reads a key and its value, in a table or an inline table
+func (s *tomlScanner) keyValue(table string) {
+    path := table
+    for _, part := range s.key() {
+        path = joinPath(path, part)
+        s.pos.add(path, s.line)
+    }
+    s.skip(false)
+    if (s.i < len(s.data)) && (s.data[s.i] == '=') {
+        s.i++
+    }
+    s.value(path)
+}
+
+// key reads a dotted key, and returns its parts without quotes
+func (s *tomlScanner) key() []string {
+    var parts []string
+    for {
+        s.skip(false)
+        if s.i >= len(s.data) {
+            return parts
+        }
+        start := s.i
+        switch s.data[s.i] {
+        case '"', '\'':
+            s.str()
+            parts = append(parts, s.data[start + 1:s.i - 1])
+        default:
+            for (s.i < len(s.data)) && !strings.ContainsRune(" \t.=]\r\n", rune(s.data[s.i])) {
+                s.i++
+            }
+            parts = append(parts, s.data[start:s.i])
+        }
+        s.skip(false)
+        if (s.i >= len(s.data)) || (s.data[s.i] != '.') {
+            return parts
+        }
+        s.i++
+    }
+}
+
+// value reads a value, recording the elements of arrays and the keys of
+// inline tables
+func (s *tomlScanner) value(path string) {
+    s.skip(false)
+    if s.i >= len(s.data) {
+        return
+    }
+    switch s.data[s.i] {
+    case '[':
+        s.i++
+        for n := 0; ; n++ {
+            s.skip(true)
+            if (s.i >= len(s.data)) || (s.data[s.i] == ']') {
+                break
+            }
+            elem := joinPath(path, strconv.Itoa(n))
+            s.pos.add(elem, s.line)
+            s.value(elem)
+            s.skip(true)
+            if (s.i < len(s.data)) && (s.data[s.i] == ',') {
+                s.i++
+            }
+        }
+        s.i++
+    case '{':
+        s.i++
+        for {
+            s.skip(false)
+            if (s.i >= len(s.data)) || (s.data[s.i] == '}') {
+                break
+            }
+            s.keyValue(path)
+            s.skip(false)
+            if (s.i < len(s.data)) && (s.data[s.i] == ',') {
+                s.i++
+            }
+        }
+        s.i++
+    case '"', '\'':
+        s.str()
+    default:
+        start := s.i
+        for (s.i < len(s.data)) && !strings.ContainsRune(",]}#\r\n", rune(s.data[s.i])) {
+            s.i++
+        }
+        if s.i == start {
+            s.i++
+        }
+    }
+}
+
+// str reads a basic or literal string, on one line or several
+func (s *tomlScanner) str() {
+    q := s.data[s.i]
+    if strings.HasPrefix(s.data[s.i:], strings.Repeat(string(q), 3)) {
+        s.i += 3
+        for s.i < len(s.data) {
+            c := s.data[s.i]
+            switch {
+            case (c == '\\') && (q == '"'):
+                // A backslash can end a line
+                if s.i++; (s.i < len(s.data)) && (s.data[s.i] == '\n') {
+                    s.line++
+                }
+            case c == '\n':
+                s.line++
+            case strings.HasPrefix(s.data[s.i:], strings.Repeat(string(q), 3)):
+                // Up to two quotes can end the string
+                s.i += 3
+                for n := 0; (n < 2) && (s.i < len(s.data)) && (s.data[s.i] == q); n++ {
+                    s.i++
+                }
+                return
+            }
+            s.i++
+        }
+        return
+    }
+    for s.i++; s.i < len(s.data); s.i++ {
+        c := s.data[s.i]
+        if (c == '\\') && (q == '"') {
+            s.i++
+        } else if (c == q) || (c == '\n') {
+            break
+        }
+    }
+    s.i++
+}
+
+// skip skips spaces and comments, and newlines when newlines is set
+func (s *tomlScanner) skip(newlines bool) {
+    for s.i < len(s.data) {
+        switch s.data[s.i] {
+        case ' ', '\t', '\r':
+        case '\n':
+            if !newlines {
+                return
+            }
+            s.line++
+        case '#':
+            for (s.i < len(s.data)) && (s.data[s.i] != '\n') {
+                s.i++
+            }
+            continue
+        default:
+            return
+        }
+        s.i++
+    }
+}
+
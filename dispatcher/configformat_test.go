@@ -0,0 +1,188 @@
+package dispatcher
+
+import "os"
+import "strings"
+import "strconv"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+var formatConfigs = map[string]string{
+    "config.yaml": `# Living room
+listeners:
+  lirc:
+    module: lircsocket
+targets:
+  AVR:
+    module: onkyo
+    params: {host: 10.0.0.5}
+modes:
+  default:
+    keys:
+      KEY_VOLUMEUP: &volup
+        - AVR::volumeup
+        - AVR::volumeup
+      KEY_OK:
+        press: [claw::mode tv]
+        longpress: 1s
+  tv:
+    keys:
+      KEY_UP: *volup
+translate:
+  lirc:
+    "0x10": KEY_VOLUMEUP
+`,
+    "config.toml": `# Living room
+[listeners.lirc]
+module = "lircsocket"
+
+[targets.AVR]
+module = "onkyo"
+params = { host = "10.0.0.5" }
+
+[modes.default.keys]
+KEY_VOLUMEUP = [
+    "AVR::volumeup",
+    "AVR::volumeup", # twice
+]
+
+[modes.default.keys.KEY_OK]
+press = ["claw::mode tv"]
+longpress = "1s"
+
+[modes.tv.keys]
+KEY_UP = ["AVR::volumeup", "AVR::volumeup"]
+
+[translate.lirc]
+"0x10" = "KEY_VOLUMEUP"
+`,
+}
+
+// Lines of some values in the configs
+var formatLines = map[string]map[string]int{
+    "config.yaml": {"targets/avr": 6, "modes/default/keys/key_volumeup/1": 14, "modes/default/keys/key_ok/press/0": 16},
+    "config.toml": {"targets/avr": 5, "modes/default/keys/key_volumeup/1": 12, "modes/default/keys/key_ok/press/0": 16},
+}
+
+func writeConfig(t *testing.T, dir, name, data string) string {
+    path := filepath.Join(dir, name)
+    if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func Test_ConfigFormats(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    for name, data := range formatConfigs {
+        path := writeConfig(t, dir, name, data)
+        cfg, pos, err := readConfigFile(path)
+        if err != nil {
+            t.Errorf("%s: %s", name, err)
+            continue
+        }
+        if (cfg.Listeners["lirc"].Module != "lircsocket") || (cfg.Targets["AVR"].Params["host"] != "10.0.0.5") {
+            t.Errorf("%s: wrong listeners or targets: %v %v", name, cfg.Listeners, cfg.Targets)
+        }
+        if cfg.Translate["lirc"]["0x10"] != "KEY_VOLUMEUP" {
+            t.Errorf("%s: wrong translations: %v", name, cfg.Translate)
+        }
+        ok := cfg.Modes["default"].Keys["KEY_OK"]
+        if (ok == nil) || (len(ok.Press) != 1) || (ok.LongPress != "1s") {
+            t.Errorf("%s: wrong binding for KEY_OK: %+v", name, ok)
+        }
+        if up := cfg.Modes["tv"].Keys["KEY_UP"]; (up == nil) || (len(up.Press) != 2) {
+            t.Errorf("%s: wrong binding for KEY_UP: %+v", name, up)
+        }
+        for p, line := range formatLines[name] {
+            if pos.line(p) != line {
+                t.Errorf("%s: expected `%s` at line %d, got %d", name, p, line, pos.line(p))
+            }
+        }
+    }
+}
+
+func Test_ConfigErrors(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    tests := map[string]string{
+        "syntax.json": "{\n  \"modes\": {\n    \"default\": {,\n  }\n}\n",
+        "type.json": "{\n  \"targets\": {\n    \"AVR\": {\"module\": 12}\n  }\n}\n",
+        "syntax.yaml": "modes:\n  default:\n    keys: [\n  - x\n",
+        "type.yaml": "targets:\n  AVR:\n    module: onkyo\n    params: [1, 2]\n",
+        "syntax.toml": "[modes]\ndefault = \n",
+        "type.toml": "[targets.AVR]\nmodule = \"onkyo\"\n\n[targets.AVR.params]\nhost = [1]\n",
+    }
+    // The line of a toml syntax error depends on the version of the toml
+    // package, any line will do
+    expect := map[string]int{
+        "syntax.json": 3,
+        "type.json": 3,
+        "syntax.yaml": 3,
+        "type.yaml": 4,
+        "syntax.toml": 0,
+        "type.toml": 5,
+    }
+    for name, data := range tests {
+        _, err := loadConfig(writeConfig(t, dir, name, data))
+        if err == nil {
+            t.Errorf("%s: expected an error", name)
+            continue
+        }
+        prefix := "could not parse config file: " + name + ":"
+        if !strings.HasPrefix(err.Error(), prefix) {
+            t.Errorf("%s: expected the error in `%s`, got `%s`", name, name, err)
+            continue
+        }
+        rest := strings.TrimPrefix(err.Error(), prefix)
+        line, lerr := strconv.Atoi(rest[:strings.Index(rest + ":", ":")])
+        if (lerr != nil) || (line < 1) || ((expect[name] > 0) && (line != expect[name])) {
+            t.Errorf("%s: expected the error at line %d, got `%s`", name, expect[name], err)
+        }
+    }
+}
+
+func Test_LearnedFormats(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    for name, data := range formatConfigs {
+        path := writeConfig(t, dir, name, data)
+        err := updateConfig(learnedFile(path), func(cfg map[string]interface{}) {
+            md := object(object(cfg, field(cfg, "modes")), "default")
+            object(md, field(md, "keys"))["KEY_RED"] = []string{"claw::mode tv"}
+        })
+        if err != nil {
+            t.Errorf("%s: %s", name, err)
+            continue
+        }
+        cfg, err := loadConfig(path)
+        if err != nil {
+            t.Errorf("%s: %s", name, err)
+            continue
+        }
+        if red := cfg.Modes["default"].Keys["KEY_RED"]; (red == nil) || (len(red.Press) != 1) {
+            t.Errorf("%s: key not saved: %+v", name, cfg.Modes["default"].Keys)
+        }
+        if cfg.Targets["AVR"].Params["host"] != "10.0.0.5" {
+            t.Errorf("%s: targets lost: %v", name, cfg.Targets)
+        }
+        // The config file keeps its comments
+        if written, _ := ioutil.ReadFile(path); string(written) != data {
+            t.Errorf("%s: the config file was rewritten", name)
+        }
+        os.Remove(learnedFile(path))
+    }
+}
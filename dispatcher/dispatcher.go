@@ -1,6 +1,10 @@
 package dispatcher
 
+import "os"
+import "sync"
 import "time"
+import "strings"
+import "strconv"
 
 import "github.com/cnf/go-claw/listeners"
 import "github.com/cnf/go-claw/modes"
@@ -10,13 +14,28 @@ import "github.com/cnf/go-claw/clog"
 // Dispatcher holds all the dispatcher info
 type Dispatcher struct {
     Configfile string
+    // Learn starts learning mode, asking for the names of new keys on the
+    // terminal
+    Learn bool
+    // Watch reloads the config file when it changes, it is always reloaded
+    // on SIGHUP
+    Watch bool
     config Config
+    filesmu sync.Mutex
+    files []string
     keytimeout time.Duration
     listenermap map[string]*listeners.Listener
     targetmanager *targets.TargetManager
     modes *modes.Modes
     activemode string
     cs *listeners.CommandStream
+    gestures *gestures
+    sequences *sequences
+    pressed map[string]time.Time
+    translator *translator
+    learner *learner
+    // parsed holds the conditions of the config, parsed when it was loaded
+    parsed parsedConditions
 }
 
 func (d *Dispatcher) Start() {
@@ -24,18 +43,55 @@ func (d *Dispatcher) Start() {
     d.activemode = "default"
     d.keytimeout = time.Duration(120 * time.Millisecond)
     d.readConfig()
-    d.setupListeners()
     d.setupModes()
+    d.setupListeners()
+    d.checkListenerKeys()
     d.setupTargets()
+    d.setupLearner()
+    d.gestures = newGestures()
+    d.sequences = newSequences(d.modes)
+    d.pressed = make(map[string]time.Time)
+    reloads := d.reloadSignals()
 
-    var out listeners.RemoteCommand
+    cmds := make(chan listeners.RemoteCommand)
+    go func() {
+        defer close(cmds)
+        var out listeners.RemoteCommand
+        for d.cs.Next(&out) {
+            if d.cs.HasError() {
+                clog.Warn("An error occured somewhere: %v", d.cs.GetError())
+                d.cs.ClearError()
+            }
+            cmds <- out
+        }
+    }()
 
-    for d.cs.Next(&out) {
-        if d.cs.HasError() {
-            clog.Warn("An error occured somewhere: %v", d.cs.GetError())
-            d.cs.ClearError()
+    for {
+        select {
+        case out, ok := <- cmds:
+            if !ok {
+                return
+            }
+            d.dispatch(&out)
+        case t := <- d.gestures.timeouts:
+            rc, binding, events := d.gestures.timeout(t)
+            b := &batch{}
+            for _, event := range events {
+                clog.Debug("Dispatch: %s of key `%s` - source `%s`", event, rc.Key, rc.Source)
+                b.add(event + " of key `" + rc.Key + "`", binding.Actions(event).WithPolicy(binding.ErrorPolicy()))
+            }
+            d.runBatch(b, rc.Repeat)
+        case <- reloads:
+            if err := d.reload(); err != nil {
+                clog.Error("Could not reload config, keeping the running one: %s", err.Error())
+            }
+        case id := <- d.sequences.timeouts:
+            b := &batch{}
+            for _, actions := range d.sequences.timeout(id) {
+                b.add("key sequence", actions)
+            }
+            d.runBatch(b, 0)
         }
-        d.dispatch(&out)
     }
 }
 
@@ -47,13 +103,26 @@ func (d *Dispatcher) setupListeners() {
         l, ok := listeners.GetListener(v.Module, v.Params)
         if ok {
             clog.Info("Setting up listener: %s", k)
+            if mw, ok := l.(listeners.ModeWatcher); ok {
+                mw.SetModes(d.modes)
+            }
             d.listenermap[k] = &l
-            d.cs.AddListener(l)
+            d.cs.AddNamedListener(k, l)
         }
     }
 
 }
 
+func (d *Dispatcher) setupLearner() {
+    d.translator = newTranslator(d.config.Translate)
+    d.learner = newLearner(d.Configfile, d.config.Learned, d.modes, d.translator)
+    d.targetmanager.SetLearner(d.learner)
+    if d.Learn {
+        d.learner.interactive(os.Stdin, os.Stdout)
+        d.learner.SetLearning(true)
+    }
+}
+
 func (d *Dispatcher) setupModes() {
     d.modes = &modes.Modes{}
     err := d.modes.Setup(d.config.Modes)
@@ -62,6 +131,48 @@ func (d *Dispatcher) setupModes() {
     }
 }
 
+// checkListenerKeys warns about mode keys none of the listeners know about
+func (d *Dispatcher) checkListenerKeys() {
+    known := make(map[string]bool)
+    listed := false
+    for k, v := range d.listenermap {
+        kl, ok := (*v).(listeners.KeyLister)
+        if !ok {
+            continue
+        }
+        remotes, err := kl.KnownKeys()
+        if err != nil {
+            clog.Warn("Could not list keys for listener '%s': %s", k, err.Error())
+            continue
+        }
+        listed = true
+        for r, keys := range remotes {
+            clog.Info("Listener '%s': remote '%s' has %d keys", k, r, len(keys))
+            for _, key := range keys {
+                known[key] = true
+            }
+        }
+    }
+    if !listed {
+        return
+    }
+    // Keys renamed by translations are known too
+    for _, table := range d.config.Translate {
+        for _, key := range table {
+            known[key] = true
+        }
+    }
+    for name, mode := range d.modes.ModeMap {
+        for seq := range mode.Keys {
+            for _, key := range strings.Fields(seq) {
+                if key = modes.UnqualifiedKey(key); !known[key] {
+                    clog.Warn("Mode '%s': key '%s' is not known by any remote", name, key)
+                }
+            }
+        }
+    }
+}
+
 func (d *Dispatcher) setupTargets() {
     if d.targetmanager == nil {
         d.targetmanager = targets.NewTargetManager(d.modes)
@@ -69,6 +180,8 @@ func (d *Dispatcher) setupTargets() {
         // Stop and remove all targets if needed
         d.targetmanager.Stop()
     }
+    d.targetmanager.SetMacros(d.config.Macros)
+    d.targetmanager.SetConditions(&conditions{d: d})
 
     for k, v := range d.config.Targets {
         clog.Info("Setting up target: %s", k)
@@ -79,25 +192,130 @@ func (d *Dispatcher) setupTargets() {
 }
 
 func (d *Dispatcher) dispatch(rc *listeners.RemoteCommand) bool {
-    clog.Debug("Dispatch: repeat `%2d` - key `%s` - source `%s`", rc.Repeat, rc.Key, rc.Source)
+    clog.Debug("Dispatch: %-7s repeat `%2d` - key `%s` - source `%s` - listener `%s`", rc.Event, rc.Repeat, rc.Key, rc.Source, rc.Listener)
     tdiff := time.Since(rc.Time)
     clog.Debug("Dispatch: --> t: %s", tdiff.String())
-    if tdiff > d.keytimeout {
+    raw := *rc
+    if d.translator.translate(rc) {
+        clog.Debug("Dispatch: --> translated `%s` to `%s`", raw.Key, rc.Key)
+    }
+    // Keep the press time of dropped presses too, for the repeat policy of
+    // the holds after it. Without a press, the first hold counts.
+    name := rc.Origin() + "\x00" + rc.Key
+    switch rc.Event {
+    case listeners.KeyPress:
+        d.pressed[name] = rc.Time
+    case listeners.KeyHold:
+        if _, ok := d.pressed[name]; !ok {
+            d.pressed[name] = rc.Time
+        }
+    case listeners.KeyRelease:
+        defer delete(d.pressed, name)
+    }
+    // Never drop a release, it could leave something running
+    if (tdiff > d.keytimeout) && (rc.Event != listeners.KeyRelease) {
         clog.Info("dispatch: Key timeout reached: %# v", tdiff.String())
         return false
     }
-    var rok = true
-    actions, err := d.modes.ActionsFor(rc.Key)
+    if (rc.Event == listeners.KeyPress) && d.learner.capture(&raw, rc.Key) {
+        return true
+    }
+    // All actions of the key event end up in a single report
+    b := &batch{}
+    defer d.runBatch(b, rc.Repeat)
+    if rc.Event == listeners.KeyPress {
+        run, consumed := d.sequences.press(rc)
+        for _, actions := range run {
+            b.add("key sequence", actions)
+        }
+        if consumed {
+            return true
+        }
+    } else if d.sequences.skip(rc) {
+        return true
+    }
+    binding, err := d.modes.BindingFrom(rc.Listener, rc.Source, rc.Key)
     if err != nil {
-        clog.Debug("dispatch:ActionsFor: %s", err)
+        clog.Debug("dispatch:BindingFor: %s", err)
         return false
     }
-    for _, v := range actions {
-        err := d.targetmanager.RunCommand(v)
-        if err != nil {
-            rok = false
-            clog.Debug("dispatch:RunCommand: %s", err)
+    events := []string{rc.Event.String()}
+    if binding.HasGestures() {
+        events = d.gestures.resolve(rc, binding)
+    }
+    for _, event := range events {
+        if event == "hold" {
+            b.add("hold of key `" + rc.Key + "`", holdActions(rc, binding, d.pressed[name]))
+            continue
+        }
+        b.add(event + " of key `" + rc.Key + "`", binding.Actions(event).WithPolicy(binding.ErrorPolicy()))
+    }
+    return true
+}
+
+// holdActions returns the hold actions of a binding according to its repeat
+// policy
+func holdActions(rc *listeners.RemoteCommand, binding *modes.Binding, pressed time.Time) modes.Actions {
+    var held time.Duration
+    if !pressed.IsZero() {
+        held = rc.Time.Sub(pressed)
+    }
+    steps := binding.RepeatPolicy().Steps(rc.Repeat, held)
+    if steps == 0 {
+        clog.Debug("Dispatch: skipping repeat %d of key `%s`", rc.Repeat, rc.Key)
+        return nil
+    }
+    actions := binding.Actions("hold").WithPolicy(binding.ErrorPolicy())
+    if steps > 1 {
+        clog.Debug("Dispatch: accelerating key `%s`: %d steps", rc.Key, steps)
+    }
+    // Actions taking the step size run once, others run once per step
+    var run modes.Actions
+    for i := 0; i < steps; i++ {
+        for _, a := range actions {
+            if (i == 0) || !usesStep(a) {
+                run = append(run, a)
+            }
         }
     }
-    return rok
+    return run.Map(func(index, a string) string {
+        return strings.Replace(a, "{step}", strconv.Itoa(steps), -1)
+    })
+}
+
+// usesStep returns true if an action, or a command in its parallel block,
+// takes the step size
+func usesStep(a modes.Action) bool {
+    for _, c := range (modes.Actions{a}).Commands() {
+        if strings.Contains(c, "{step}") {
+            return true
+        }
+    }
+    return false
+}
+
+// batch collects the lists of actions of a single key event
+type batch struct {
+    what []string
+    actions []modes.Actions
+}
+
+func (b *batch) add(what string, actions modes.Actions) {
+    if len(actions) == 0 {
+        return
+    }
+    if (len(b.what) == 0) || (b.what[len(b.what) - 1] != what) {
+        b.what = append(b.what, what)
+    }
+    b.actions = append(b.actions, actions)
+}
+
+// runBatch runs the actions of a key event on the workers of their targets,
+// the results of all of them are logged in a single report when they are
+// done. Conditions get the repeat count of the key.
+func (d *Dispatcher) runBatch(b *batch, repeat int) {
+    if len(b.actions) == 0 {
+        return
+    }
+    d.targetmanager.RunBatches(strings.Join(b.what, ", "), b.actions, &conditions{d, repeat})
 }
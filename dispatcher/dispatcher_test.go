@@ -0,0 +1,58 @@
+package dispatcher
+
+import "time"
+import "testing"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/targets"
+
+func Test_PressTime(t *testing.T) {
+    m := &modes.Modes{}
+    m.Setup(map[string]*modes.Mode{"default": &modes.Mode{
+        Keys: map[string]*modes.Binding{
+            "KEY_UP": &modes.Binding{Hold: modes.NewActions("AVR::up"), Repeat: &modes.Repeat{Delay: "100ms"}},
+        },
+    }})
+    tr := newTranslator(nil)
+    d := &Dispatcher{
+        modes: m,
+        targetmanager: targets.NewTargetManager(m),
+        keytimeout: 120 * time.Millisecond,
+        gestures: newGestures(),
+        sequences: newSequences(m),
+        pressed: make(map[string]time.Time),
+        translator: tr,
+        learner: newLearner("", nil, m, tr),
+    }
+    defer d.targetmanager.Stop()
+    d.targetmanager.Add("condtest", "AVR", nil)
+    key := func(ev listeners.KeyEvent, at time.Time) *listeners.RemoteCommand {
+        return &listeners.RemoteCommand{Key: "KEY_UP", Source: "lirc", Listener: "lirc", Event: ev, Time: at}
+    }
+    name := key(listeners.KeyPress, time.Time{}).Origin() + "\x00KEY_UP"
+
+    // A press dropped for being late still starts the hold time
+    pressed := time.Now().Add(-time.Second)
+    if d.dispatch(key(listeners.KeyPress, pressed)) {
+        t.Errorf("expected the late press to be dropped")
+    }
+    if got := d.pressed[name]; !got.Equal(pressed) {
+        t.Errorf("expected the press time %s, got %s", pressed, got)
+    }
+    d.dispatch(key(listeners.KeyHold, time.Now()))
+    if got := d.pressed[name]; !got.Equal(pressed) {
+        t.Errorf("expected the press time to stay %s, got %s", pressed, got)
+    }
+    d.dispatch(key(listeners.KeyRelease, time.Now()))
+    if _, ok := d.pressed[name]; ok {
+        t.Errorf("expected the press time to be removed on release")
+    }
+
+    // Without a press, the first hold counts
+    held := time.Now()
+    d.dispatch(key(listeners.KeyHold, held))
+    if got := d.pressed[name]; !got.Equal(held) {
+        t.Errorf("expected the time of the first hold %s, got %s", held, got)
+    }
+}
@@ -0,0 +1,102 @@
+package dispatcher
+
+import "time"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+
+// gesture is a press of a key with long or double press actions, waiting
+// to be resolved
+type gesture struct {
+    id int
+    rc listeners.RemoteCommand
+    binding *modes.Binding
+    released bool
+    fired bool
+}
+
+// gestureTimeout is sent when a long or double press time has passed
+type gestureTimeout struct {
+    name string
+    id int
+}
+
+// gestures resolves long and double presses per source and key
+type gestures struct {
+    pending map[string]*gesture
+    timeouts chan gestureTimeout
+    nextid int
+}
+
+func newGestures() *gestures {
+    return &gestures{pending: make(map[string]*gesture), timeouts: make(chan gestureTimeout)}
+}
+
+func (g *gestures) startTimer(name string, gs *gesture, t time.Duration) {
+    g.nextid++
+    gs.id = g.nextid
+    id := gs.id
+    time.AfterFunc(t, func() {
+        g.timeouts <- gestureTimeout{name: name, id: id}
+    })
+}
+
+// resolve handles a key event for a binding with gestures. It returns the
+// events whose actions should run now in order, or none if nothing should
+// run yet. A short press runs its press and release actions once it is
+// known not to be a long or double press.
+func (g *gestures) resolve(rc *listeners.RemoteCommand, binding *modes.Binding) []string {
+    name := rc.Origin() + "\x00" + rc.Key
+    gs := g.pending[name]
+    switch rc.Event {
+    case listeners.KeyPress:
+        if (gs != nil) && gs.released && !gs.fired {
+            // Second press while waiting for one
+            gs.fired = true
+            gs.released = false
+            gs.id = 0
+            return []string{"double"}
+        }
+        gs = &gesture{rc: *rc, binding: binding}
+        g.pending[name] = gs
+        if len(binding.Long) > 0 {
+            g.startTimer(name, gs, binding.LongTime())
+        }
+        return nil
+    case listeners.KeyRelease:
+        if gs == nil {
+            return []string{"release"}
+        }
+        if gs.fired {
+            delete(g.pending, name)
+            return []string{"release"}
+        }
+        gs.released = true
+        if len(binding.Double) > 0 {
+            g.startTimer(name, gs, binding.DoubleTime())
+            return nil
+        }
+        // Released before the long press time
+        delete(g.pending, name)
+        return []string{"press", "release"}
+    }
+    return []string{rc.Event.String()}
+}
+
+// timeout resolves a gesture when its timer expired. It returns the
+// command and binding to run the actions of, or nil, and the events to
+// run in order.
+func (g *gestures) timeout(t gestureTimeout) (*listeners.RemoteCommand, *modes.Binding, []string) {
+    gs := g.pending[t.name]
+    if (gs == nil) || (gs.id != t.id) || gs.fired {
+        return nil, nil, nil
+    }
+    if !gs.released {
+        // Still held after the long press time
+        gs.fired = true
+        return &gs.rc, gs.binding, []string{"long"}
+    }
+    // No second press came
+    delete(g.pending, t.name)
+    return &gs.rc, gs.binding, []string{"press", "release"}
+}
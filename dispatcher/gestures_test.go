@@ -0,0 +1,72 @@
+package dispatcher
+
+import "time"
+import "strings"
+import "testing"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+
+func Test_Gestures(t *testing.T) {
+    m := &modes.Modes{}
+    m.Setup(map[string]*modes.Mode{
+        "default": &modes.Mode{
+            LongPress: "50ms",
+            DoublePress: "50ms",
+            Keys: map[string]*modes.Binding{
+                "KEY_POWER": &modes.Binding{Press: modes.NewActions("tv"), Long: modes.NewActions("all"), Double: modes.NewActions("avr")},
+                "KEY_OK": &modes.Binding{Press: modes.NewActions("tv"), Release: modes.NewActions("avr"), Long: modes.NewActions("all")},
+            },
+        },
+    })
+    binding, _ := m.BindingFor("KEY_POWER")
+    g := newGestures()
+    key := func(ev listeners.KeyEvent) string {
+        return strings.Join(g.resolve(&listeners.RemoteCommand{Key: "KEY_POWER", Source: "lirc", Event: ev}, binding), ",")
+    }
+    wait := func() string {
+        for {
+            select {
+            case to := <- g.timeouts:
+                // Skip timers of gestures that were already resolved
+                if _, _, events := g.timeout(to); len(events) > 0 {
+                    return strings.Join(events, ",")
+                }
+            case <- time.After(200 * time.Millisecond):
+                return "none"
+            }
+        }
+    }
+    expect := func(what, got, want string) {
+        if got != want {
+            t.Errorf("%s: expected '%s', got '%s'", what, want, got)
+        }
+    }
+
+    // Short press: press and release run after the double press time
+    expect("short press", key(listeners.KeyPress), "")
+    expect("short release", key(listeners.KeyRelease), "")
+    expect("short timeout", wait(), "press,release")
+
+    // Long press
+    expect("long press", key(listeners.KeyPress), "")
+    expect("long hold", key(listeners.KeyHold), "hold")
+    expect("long timeout", wait(), "long")
+    expect("long release", key(listeners.KeyRelease), "release")
+
+    // Double press
+    expect("double press", key(listeners.KeyPress), "")
+    expect("double release", key(listeners.KeyRelease), "")
+    expect("double second press", key(listeners.KeyPress), "double")
+    expect("double second release", key(listeners.KeyRelease), "release")
+    expect("double timeout", wait(), "none")
+
+    // Without double press actions a short press resolves on release
+    binding, _ = m.BindingFor("KEY_OK")
+    ok := func(ev listeners.KeyEvent) string {
+        return strings.Join(g.resolve(&listeners.RemoteCommand{Key: "KEY_OK", Source: "lirc", Event: ev}, binding), ",")
+    }
+    expect("long only press", ok(listeners.KeyPress), "")
+    expect("long only release", ok(listeners.KeyRelease), "press,release")
+    expect("long only timeout", wait(), "none")
+}
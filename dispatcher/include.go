@@ -0,0 +1,323 @@
+package dispatcher
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/clog"
+
+// A config file can include other config files, which are merged into it
+// in order: first the file itself, then its includes. Listeners, targets
+// and modes can only be defined once, unless a later definition sets
+// Override, replacing the earlier one. Translations for the same code and
+// variables of the same name must agree, learned keys are collected from
+// all files. The file written by learning mode is read last, the keys in it
+// are added to modes defined by the other files.
+
+// configReader reads a config file and the files it includes
+type configReader struct {
+    dir string
+    cfg Config
+    pos positions
+    // The name and file of every listener, target and mode, by the lower
+    // case path of its definition, like "targets/avr"
+    defined map[string]definition
+    // The files being read, to find include loops
+    reading map[string]bool
+}
+
+type definition struct {
+    name string
+    file string
+}
+
+// readConfigFile reads a config file and all files it includes, and returns
+// the merged config and the positions of its values
+func readConfigFile(path string) (Config, positions, error) {
+    r := &configReader{
+        dir: filepath.Dir(path),
+        pos: make(positions),
+        defined: make(map[string]definition),
+        reading: make(map[string]bool),
+    }
+    r.cfg.Listeners = make(map[string]ConfigListener)
+    r.cfg.Targets = make(map[string]ConfigTarget)
+    r.cfg.Modes = make(map[string]*modes.Mode)
+    r.cfg.Translate = make(Translations)
+    r.cfg.Vars = make(map[string]string)
+    r.cfg.Macros = make(map[string]modes.Actions)
+    err := r.read(path)
+    if err == nil {
+        err = r.readLearned(learnedFile(path))
+    }
+    if err == nil {
+        err = r.expandVars(filepath.Base(path))
+    }
+    return r.cfg, r.pos, err
+}
+
+// name returns the name of a file in errors, relative to the main config
+// file
+func (r *configReader) name(path string) string {
+    rel, err := filepath.Rel(r.dir, path)
+    if (err != nil) || strings.HasPrefix(rel, "..") {
+        return path
+    }
+    return rel
+}
+
+// read merges a config file, followed by the files it includes
+func (r *configReader) read(path string) error {
+    name := r.name(path)
+    abs, _ := filepath.Abs(path)
+    if r.reading[abs] {
+        return fmt.Errorf("could not read config file: %s is included in a loop", name)
+    }
+    r.reading[abs] = true
+    defer delete(r.reading, abs)
+
+    cfg, pos, err := parseConfigFile(path, name)
+    r.cfg.files = append(r.cfg.files, path)
+    if err != nil {
+        return err
+    }
+    if err = r.merge(&cfg, pos, name); err != nil {
+        return fmt.Errorf("could not parse config file: %s", err.Error())
+    }
+    for i, pattern := range cfg.Include {
+        files, err := r.includeFiles(filepath.Dir(path), pattern)
+        if err != nil {
+            return fmt.Errorf("could not read config file: %s: include `%s`: %s", where(pos, name, fmt.Sprintf("include/%d", i)), pattern, err.Error())
+        }
+        for _, f := range files {
+            if err = r.read(f); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// learnedFile returns the file learning mode writes to for a config file,
+// like "claw.learned.json" for "claw.yaml". It is always JSON, so files in
+// other formats are never rewritten.
+func learnedFile(path string) string {
+    return strings.TrimSuffix(path, filepath.Ext(path)) + ".learned.json"
+}
+
+// readLearned merges the file written by learning mode, if there is one.
+// Its keys are added to the modes of the same name, replacing the keys of
+// the config files with a warning.
+func (r *configReader) readLearned(path string) error {
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        return nil
+    }
+    name := r.name(path)
+    cfg, pos, err := parseConfigFile(path, name)
+    r.cfg.files = append(r.cfg.files, path)
+    if err != nil {
+        return err
+    }
+    for _, k := range sortedKeys(cfg.Modes) {
+        def, ok := r.defined["modes/" + strings.ToLower(k)]
+        if !ok || (cfg.Modes[k] == nil) {
+            continue
+        }
+        md := r.cfg.Modes[def.name]
+        if md == nil {
+            md = &modes.Mode{}
+            r.cfg.Modes[def.name] = md
+        }
+        if md.Keys == nil {
+            md.Keys = make(map[string]*modes.Binding)
+        }
+        for _, key := range sortedKeys(cfg.Modes[k].Keys) {
+            if _, ok := md.Keys[key]; ok {
+                clog.Warn("%s: learned key `%s` overrides the one of mode `%s` at %s", where(pos, name, "modes/" + k + "/keys/" + key),
+                    key, def.name, where(r.pos, "an earlier file", "modes/" + def.name + "/keys/" + key))
+            }
+            md.Keys[key] = cfg.Modes[k].Keys[key]
+        }
+        delete(cfg.Modes, k)
+    }
+    cfg.Include = nil
+    if err = r.merge(&cfg, pos, name); err != nil {
+        return fmt.Errorf("could not parse config file: %s", err.Error())
+    }
+    return nil
+}
+
+// where returns the "file:line" of the first path found, or the file name
+func where(pos positions, file string, paths ...string) string {
+    if w := pos.where(paths...); w != "" {
+        return w
+    }
+    return file
+}
+
+// isConfigFile returns true for the files included from directories and
+// glob patterns
+func isConfigFile(name string) bool {
+    if strings.HasPrefix(name, ".") {
+        return false
+    }
+    switch strings.ToLower(filepath.Ext(name)) {
+    case ".json", ".yaml", ".yml", ".toml":
+        return true
+    }
+    return false
+}
+
+// includeFiles returns the files matching an include entry, relative to
+// dir. Glob patterns and directories only match config files, by extension,
+// in sorted order. The directories are watched for new files.
+func (r *configReader) includeFiles(dir, pattern string) ([]string, error) {
+    if !filepath.IsAbs(pattern) {
+        pattern = filepath.Join(dir, pattern)
+    }
+    glob := strings.ContainsAny(pattern, "*?[")
+    matches, err := filepath.Glob(pattern)
+    if err != nil {
+        return nil, err
+    }
+    if glob {
+        r.cfg.files = append(r.cfg.files, filepath.Dir(pattern))
+    } else if len(matches) == 0 {
+        return nil, fmt.Errorf("no such file or directory")
+    }
+    var files []string
+    for _, m := range matches {
+        fi, err := os.Stat(m)
+        if err != nil {
+            return nil, err
+        }
+        if !fi.IsDir() {
+            if !glob || isConfigFile(m) {
+                files = append(files, m)
+            }
+            continue
+        }
+        r.cfg.files = append(r.cfg.files, m)
+        entries, err := ioutil.ReadDir(m)
+        if err != nil {
+            return nil, err
+        }
+        for _, e := range entries {
+            if !e.IsDir() && isConfigFile(e.Name()) {
+                files = append(files, filepath.Join(m, e.Name()))
+            }
+        }
+    }
+    return files, nil
+}
+
+// define records the definition of a listener, target or mode. It returns
+// the name of the definition it overrides, if any.
+func (r *configReader) define(section, kind, name string, override bool, pos positions, file string) (string, error) {
+    path := section + "/" + strings.ToLower(name)
+    old, ok := r.defined[path]
+    if ok && !override {
+        return "", fmt.Errorf("%s: %s `%s` is already defined at %s, set `override` to replace it",
+            where(pos, file, path), kind, name, where(r.pos, old.file, path))
+    }
+    r.defined[path] = definition{name, file}
+    if ok {
+        r.pos.remove(path)
+    }
+    return old.name, nil
+}
+
+// merge adds the contents of a config file to the config
+func (r *configReader) merge(cfg *Config, pos positions, file string) error {
+    for _, k := range sortedKeys(cfg.Listeners) {
+        old, err := r.define("listeners", "listener", k, cfg.Listeners[k].Override, pos, file)
+        if err != nil {
+            return err
+        }
+        delete(r.cfg.Listeners, old)
+        r.cfg.Listeners[k] = cfg.Listeners[k]
+    }
+    for _, k := range sortedKeys(cfg.Targets) {
+        old, err := r.define("targets", "target", k, cfg.Targets[k].Override, pos, file)
+        if err != nil {
+            return err
+        }
+        delete(r.cfg.Targets, old)
+        r.cfg.Targets[k] = cfg.Targets[k]
+    }
+    for _, k := range sortedKeys(cfg.Modes) {
+        override := (cfg.Modes[k] != nil) && cfg.Modes[k].Override
+        old, err := r.define("modes", "mode", k, override, pos, file)
+        if err != nil {
+            return err
+        }
+        delete(r.cfg.Modes, old)
+        r.cfg.Modes[k] = cfg.Modes[k]
+    }
+    for _, k := range sortedKeys(cfg.Macros) {
+        if _, err := r.define("macros", "macro", k, false, pos, file); err != nil {
+            return err
+        }
+        r.cfg.Macros[k] = cfg.Macros[k]
+    }
+    tables := make([]string, 0, len(cfg.Translate))
+    for table := range cfg.Translate {
+        tables = append(tables, table)
+    }
+    sort.Strings(tables)
+    for _, table := range tables {
+        if r.cfg.Translate[table] == nil {
+            r.cfg.Translate[table] = make(map[string]string)
+        }
+        raws := make([]string, 0, len(cfg.Translate[table]))
+        for raw := range cfg.Translate[table] {
+            raws = append(raws, raw)
+        }
+        sort.Strings(raws)
+        for _, raw := range raws {
+            key := cfg.Translate[table][raw]
+            if old, ok := r.cfg.Translate[table][raw]; ok && (old != key) {
+                path := "translate/" + table + "/" + raw
+                return fmt.Errorf("%s: `%s` of `%s` is already translated to `%s` at %s",
+                    where(pos, file, path), raw, table, old, where(r.pos, "an earlier file", path))
+            }
+            r.cfg.Translate[table][raw] = key
+        }
+    }
+    for _, k := range sortedKeys(cfg.Vars) {
+        if old, ok := r.cfg.Vars[k]; ok && (old != cfg.Vars[k]) {
+            path := "vars/" + k
+            return fmt.Errorf("%s: variable `%s` is already set to `%s` at %s",
+                where(pos, file, path), k, old, where(r.pos, "an earlier file", path))
+        }
+        r.cfg.Vars[k] = cfg.Vars[k]
+    }
+    for _, k := range cfg.Learned {
+        if !r.learned(k) {
+            r.cfg.Learned = append(r.cfg.Learned, k)
+        }
+    }
+    if r.cfg.Include == nil {
+        r.cfg.Include = cfg.Include
+    }
+    for k, v := range pos {
+        if _, ok := r.pos[k]; !ok {
+            r.pos[k] = v
+        }
+    }
+    return nil
+}
+
+// learned returns true if a key was learned in an earlier file
+func (r *configReader) learned(key LearnedKey) bool {
+    for _, k := range r.cfg.Learned {
+        if k == key {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,86 @@
+package dispatcher
+
+import "os"
+import "strings"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+func Test_Include(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+    cfgfile := writeConfig(t, dir, "config.json", `{
+    "include": ["conf.d", "local/*.yaml"],
+    "listeners": {"lirc": {"module": "lircsocket"}},
+    "modes": {"default": {"keys": {"KEY_OK": ["AVR::select"]}}},
+    "translate": {"lirc": {"0x10": "KEY_OK"}}
+}`)
+    writeConfig(t, dir, "conf.d/10-avr.yaml", `targets:
+  AVR:
+    module: onkyo
+translate:
+  lirc:
+    "0x10": KEY_OK
+    "0x11": KEY_UP
+`)
+    writeConfig(t, dir, "conf.d/20-tv.toml", `[targets.TV]
+module = "cec"
+
+[modes.tv.keys]
+KEY_OK = ["TV::select"]
+`)
+    writeConfig(t, dir, "conf.d/20-tv.toml~", `not a config`)
+    os.Mkdir(filepath.Join(dir, "local"), 0755)
+    writeConfig(t, dir, "local/override.yaml", `modes:
+  tv:
+    override: true
+    keys:
+      KEY_OK: [TV::play]
+`)
+
+    cfg, pos, err := readConfigFile(cfgfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if (len(cfg.Listeners) != 1) || (len(cfg.Targets) != 2) || (len(cfg.Modes) != 2) {
+        t.Errorf("wrong listeners, targets or modes: %v %v %v", cfg.Listeners, cfg.Targets, cfg.Modes)
+    }
+    if actions := cfg.Modes["tv"].Keys["KEY_OK"].Press; (len(actions) != 1) || (actions[0].Command != "TV::play") {
+        t.Errorf("mode `tv` not overridden: %v", actions)
+    }
+    if cfg.Translate["lirc"]["0x11"] != "KEY_UP" {
+        t.Errorf("translations not merged: %v", cfg.Translate)
+    }
+    for path, expect := range map[string]string{
+        "targets/avr": "conf.d/10-avr.yaml:2",
+        "targets/tv": "conf.d/20-tv.toml:1",
+        "modes/default/keys/key_ok/0": "config.json:4",
+        "modes/tv/keys/key_ok/0": "local/override.yaml:5",
+    } {
+        if w := pos.where(path); w != expect {
+            t.Errorf("expected `%s` at %s, got %s", path, expect, w)
+        }
+    }
+    if len(cfg.files) != 6 {
+        t.Errorf("expected 4 files and 2 directories, got %v", cfg.files)
+    }
+
+    // Conflicts and loops
+    tests := map[string]string{
+        "modes:\n  tv:\n    keys: {}\n": "could not parse config file: local/override.yaml:2: mode `tv` is already defined at conf.d/20-tv.toml:4",
+        "translate:\n  lirc:\n    \"0x11\": KEY_DOWN\n": "could not parse config file: local/override.yaml:3: `0x11` of `lirc` is already translated to `KEY_UP` at conf.d/10-avr.yaml:7",
+        "include: [../config.json]\n": "could not read config file: config.json is included in a loop",
+        "include: [missing.yaml]\n": "could not read config file: local/override.yaml:1: include `missing.yaml`: no such file or directory",
+    }
+    for data, expect := range tests {
+        writeConfig(t, dir, "local/override.yaml", data)
+        _, err := loadConfig(cfgfile)
+        if (err == nil) || !strings.HasPrefix(err.Error(), expect) {
+            t.Errorf("expected error `%s`, got `%v`", expect, err)
+        }
+    }
+}
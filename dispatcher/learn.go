@@ -0,0 +1,262 @@
+package dispatcher
+
+import "os"
+import "io"
+import "fmt"
+import "sync"
+import "bufio"
+import "strings"
+import "io/ioutil"
+import "encoding/json"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/clog"
+
+// LearnedKey is a key captured in learning mode
+type LearnedKey struct {
+    Listener string `json:"listener,omitempty"`
+    Source string   `json:"source,omitempty"`
+    Code string     `json:"code,omitempty"`
+    Key string      `json:"key,omitempty"`
+}
+
+// matches returns true if a command was sent by the learned key
+func (k *LearnedKey) matches(rc *listeners.RemoteCommand) bool {
+    if (k.Listener != "") && (k.Listener != rc.Listener) {
+        return false
+    }
+    if k.Source != rc.Source {
+        return false
+    }
+    if k.Code != "" {
+        return k.Code == rc.Code
+    }
+    return k.Key == rc.Key
+}
+
+// learner records unknown keys while learning mode is on
+type learner struct {
+    mu sync.Mutex
+    on bool
+    configfile string
+    keys []LearnedKey
+    // Learned keys are saved in the background, so dispatching does not
+    // wait for the disk. filemu keeps the saves in order.
+    filemu sync.Mutex
+    saving sync.WaitGroup
+    modes *modes.Modes
+    translator *translator
+
+    // Reads key names and actions for captured keys, nil when claw does
+    // not run interactively
+    in *bufio.Reader
+    out io.Writer
+    captured chan LearnedKey
+}
+
+func newLearner(configfile string, keys []LearnedKey, m *modes.Modes, t *translator) *learner {
+    return &learner{configfile: configfile, keys: keys, modes: m, translator: t}
+}
+
+// reset replaces the learned keys, after the config was reloaded
+func (l *learner) reset(keys []LearnedKey) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.keys = keys
+}
+
+// interactive asks for the name, mode and actions of every captured key
+func (l *learner) interactive(in io.Reader, out io.Writer) {
+    l.in = bufio.NewReader(in)
+    l.out = out
+    l.captured = make(chan LearnedKey, 16)
+    go l.prompt()
+}
+
+// Learning returns true if learning mode is on
+func (l *learner) Learning() bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.on
+}
+
+// SetLearning switches learning mode on or off
+func (l *learner) SetLearning(on bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if on != l.on {
+        clog.Warn("Learning mode switched %s", map[bool]string{true: "on", false: "off"}[on])
+    }
+    l.on = on
+}
+
+// capture records a key press in learning mode, given the command as sent
+// by the listener and the translated key name. It returns true if the key
+// is not used by any mode and was not learned yet, it should not be
+// dispatched then.
+func (l *learner) capture(rc *listeners.RemoteCommand, key string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if !l.on || l.modes.Uses(key) {
+        return false
+    }
+    for i := range l.keys {
+        if l.keys[i].matches(rc) {
+            return false
+        }
+    }
+    learned := LearnedKey{Listener: rc.Listener, Source: rc.Source, Code: rc.Code, Key: rc.Key}
+    l.keys = append(l.keys, learned)
+    clog.Warn("Learned key `%s` - code `%s` - source `%s` - listener `%s`", rc.Key, rc.Code, rc.Source, rc.Listener)
+    l.saving.Add(1)
+    go func() {
+        defer l.saving.Done()
+        if err := l.save(nil); err != nil {
+            clog.Error("Could not save learned key: %s", err)
+        }
+    }()
+    if l.captured != nil {
+        select {
+        case l.captured <- learned:
+        default:
+            clog.Warn("Too many learned keys waiting, not asking for `%s`", rc.Key)
+        }
+    }
+    return true
+}
+
+// assign translates a learned key to name, and binds actions to it in a
+// mode
+func (l *learner) assign(key LearnedKey, name, mode string, actions []string) error {
+    table, raw := key.Listener + ":" + key.Source, key.Code
+    if raw == "" {
+        raw = key.Key
+    }
+    l.mu.Lock()
+    if name != key.Key {
+        l.translator.set(table, raw, name)
+    }
+    if len(actions) > 0 {
+        if err := l.modes.Bind(mode, name, modes.NewBinding(modes.NewActions(actions...))); err != nil {
+            l.mu.Unlock()
+            return err
+        }
+    }
+    l.mu.Unlock()
+    return l.save(func(cfg map[string]interface{}) {
+        if name != key.Key {
+            object(object(cfg, field(cfg, "translate")), table)[raw] = name
+        }
+        if len(actions) > 0 {
+            md := object(object(cfg, field(cfg, "modes")), mode)
+            object(md, field(md, "keys"))[name] = actions
+        }
+    })
+}
+
+// prompt asks for the name, mode and actions of captured keys
+func (l *learner) prompt() {
+    for key := range l.captured {
+        fmt.Fprintf(l.out, "\nLearned key `%s` - code `%s` - source `%s` - listener `%s`\n", key.Key, key.Code, key.Source, key.Listener)
+        name := l.ask("Key name", key.Key)
+        mode := l.ask("Mode", "default")
+        fmt.Fprintf(l.out, "Actions, one per line, end with an empty line:\n")
+        var actions []string
+        for {
+            a := l.ask("", "")
+            if a == "" {
+                break
+            }
+            actions = append(actions, a)
+        }
+        if err := l.assign(key, name, mode, actions); err != nil {
+            fmt.Fprintf(l.out, "Could not assign key `%s`: %s\n", name, err)
+            continue
+        }
+        fmt.Fprintf(l.out, "Saved key `%s` in mode `%s`\n", name, mode)
+    }
+}
+
+// ask reads a line, returning def for an empty one
+func (l *learner) ask(question, def string) string {
+    if question != "" {
+        fmt.Fprintf(l.out, "%s [%s]: ", question, def)
+    } else {
+        fmt.Fprintf(l.out, "> ")
+    }
+    line, err := l.in.ReadString('\n')
+    if (err != nil) && (line == "") {
+        return def
+    }
+    if line = strings.TrimSpace(line); line == "" {
+        return def
+    }
+    return line
+}
+
+// wait waits until the learned keys are saved
+func (l *learner) wait() {
+    l.saving.Wait()
+}
+
+// save writes the learned keys and any other changes made by fn to the
+// learned file of the config file, so the files written by hand are left
+// alone
+func (l *learner) save(fn func(cfg map[string]interface{})) error {
+    l.filemu.Lock()
+    defer l.filemu.Unlock()
+    l.mu.Lock()
+    keys := append([]LearnedKey{}, l.keys...)
+    l.mu.Unlock()
+    return updateConfig(learnedFile(l.configfile), func(cfg map[string]interface{}) {
+        cfg[field(cfg, "learned")] = keys
+        if fn != nil {
+            fn(cfg)
+        }
+    })
+}
+
+// updateConfig rewrites a JSON config file after fn changed its raw data
+func updateConfig(path string, fn func(cfg map[string]interface{})) error {
+    cfg := make(map[string]interface{})
+    data, err := ioutil.ReadFile(path)
+    if err == nil {
+        if err = json.Unmarshal(data, &cfg); err != nil {
+            return err
+        }
+    } else if !os.IsNotExist(err) {
+        return err
+    }
+    fn(cfg)
+    if data, err = json.MarshalIndent(cfg, "", "  "); err != nil {
+        return err
+    }
+    data = append(data, '\n')
+    tmp := path + ".tmp"
+    if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// field returns the name of the member of a JSON object matching name, case
+// insensitively like encoding/json
+func field(obj map[string]interface{}, name string) string {
+    for k := range obj {
+        if strings.EqualFold(k, name) {
+            return k
+        }
+    }
+    return name
+}
+
+// object returns the JSON object stored in a member, creating it if needed
+func object(obj map[string]interface{}, name string) map[string]interface{} {
+    if ret, ok := obj[name].(map[string]interface{}); ok {
+        return ret
+    }
+    ret := make(map[string]interface{})
+    obj[name] = ret
+    return ret
+}
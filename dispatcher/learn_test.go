@@ -0,0 +1,85 @@
+package dispatcher
+
+import "os"
+import "strings"
+import "testing"
+import "io/ioutil"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+
+func Test_Learner(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    cfgfile := writeConfig(t, dir, "config.json", `{"Include": ["modes.json"], "Targets": {"TV": {"module": "onkyo"}}}`)
+    writeConfig(t, dir, "modes.json", `{
+        "Modes": {"default": {"keys": {"KEY_UP": ["TV::up"]}}},
+        "Learned": [{"listener": "lirc", "source": "kids", "code": "0x20", "key": "BLUE"}]
+    }`)
+
+    m := &modes.Modes{}
+    m.Setup(map[string]*modes.Mode{"default": &modes.Mode{
+        Keys: map[string]*modes.Binding{"KEY_UP": modes.NewBinding(modes.NewActions("TV::up"))},
+    }})
+    tr := newTranslator(nil)
+    l := newLearner(cfgfile, nil, m, tr)
+    rc := &listeners.RemoteCommand{Code: "0x10", Key: "OK", Source: "kids", Listener: "lirc", Event: listeners.KeyPress}
+    if l.capture(rc, rc.Key) {
+        t.Errorf("captured a key while not learning")
+    }
+    l.SetLearning(true)
+    if !l.capture(rc, rc.Key) {
+        t.Errorf("did not capture a new key")
+    }
+    if l.capture(rc, rc.Key) {
+        t.Errorf("captured a key twice")
+    }
+    up := &listeners.RemoteCommand{Code: "0x11", Key: "UP", Source: "kids", Listener: "lirc", Event: listeners.KeyPress}
+    if l.capture(up, "KEY_UP") {
+        t.Errorf("captured a key used in a mode")
+    }
+    if err := l.assign(l.keys[0], "KEY_OK", "default", []string{"TV::select"}); err != nil {
+        t.Fatal(err)
+    }
+
+    // Learned keys are translated and bound
+    ok := &listeners.RemoteCommand{Code: "0x10", Key: "OK", Source: "kids", Listener: "lirc"}
+    if !tr.translate(ok) || (ok.Key != "KEY_OK") {
+        t.Errorf("expected key 'KEY_OK', got '%s'", ok.Key)
+    }
+    b, err := m.BindingFor("KEY_OK")
+    if (err != nil) || (strings.Join(b.Press.Commands(), ",") != "TV::select") {
+        t.Errorf("KEY_OK not bound: %v %v", b, err)
+    }
+
+    // And saved to the learned file, which adds them to the included mode
+    cfg, err := loadConfig(cfgfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if (len(cfg.Learned) != 2) || (cfg.Learned[1].Code != "0x10") {
+        t.Errorf("unexpected learned keys: %+v", cfg.Learned)
+    }
+    if cfg.Translate["lirc:kids"]["0x10"] != "KEY_OK" {
+        t.Errorf("unexpected translations: %+v", cfg.Translate)
+    }
+    keys := cfg.Modes["default"].Keys
+    if (keys["KEY_UP"] == nil) || (keys["KEY_OK"] == nil) || (strings.Join(keys["KEY_OK"].Press.Commands(), ",") != "TV::select") {
+        t.Errorf("unexpected keys: %+v", keys)
+    }
+    if data, _ := ioutil.ReadFile(cfgfile); string(data) != `{"Include": ["modes.json"], "Targets": {"TV": {"module": "onkyo"}}}` {
+        t.Errorf("the config file was changed: %s", data)
+    }
+
+    // Keys learned in other files are not duplicated
+    l = newLearner(cfgfile, cfg.Learned, m, tr)
+    l.SetLearning(true)
+    l.capture(&listeners.RemoteCommand{Code: "0x12", Key: "RED", Source: "kids", Listener: "lirc"}, "RED")
+    l.wait()
+    if cfg, err = loadConfig(cfgfile); (err != nil) || (len(cfg.Learned) != 3) {
+        t.Errorf("unexpected learned keys: %+v (%v)", cfg.Learned, err)
+    }
+}
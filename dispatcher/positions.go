@@ -0,0 +1,157 @@
+package dispatcher
+
+import "bytes"
+import "strings"
+import "strconv"
+import "encoding/json"
+
+// positions maps the paths of the values in a config, like
+// "modes/default/keys/key_ok/0", to the file and line they are found at.
+// Paths are lower case, like the matching of config members in
+// encoding/json.
+type positions map[string]position
+
+type position struct {
+    file string
+    line int
+}
+
+type posFrame struct {
+    array bool
+    index int
+    key string
+    wantKey bool
+}
+
+// jsonPositions finds the line numbers of all values in a JSON document
+func jsonPositions(data []byte) positions {
+    pos := make(positions)
+    walkJSON(data, func(path string, off int) {
+        if _, ok := pos[path]; !ok {
+            pos[path] = position{line: bytes.Count(data[:off], []byte("\n")) + 1}
+        }
+    })
+    return pos
+}
+
+// jsonPathAt returns the path of the last value ending at or before an
+// offset in a JSON document
+func jsonPathAt(data []byte, offset int) string {
+    var ret string
+    walkJSON(data, func(path string, off int) {
+        if off <= offset {
+            ret = path
+        }
+    })
+    return ret
+}
+
+// walkJSON calls fn with the path and end offset of every value in a JSON
+// document, in order
+func walkJSON(data []byte, fn func(path string, off int)) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    var stack []*posFrame
+    path := func() string {
+        parts := make([]string, len(stack))
+        for i, f := range stack {
+            if f.array {
+                parts[i] = strconv.Itoa(f.index)
+            } else {
+                parts[i] = strings.ToLower(f.key)
+            }
+        }
+        return strings.Join(parts, "/")
+    }
+    record := func() {
+        off := int(dec.InputOffset())
+        if off > len(data) {
+            off = len(data)
+        }
+        fn(path(), off)
+    }
+    next := func() {
+        if len(stack) == 0 {
+            return
+        }
+        if top := stack[len(stack) - 1]; top.array {
+            top.index++
+        } else {
+            top.wantKey = true
+        }
+    }
+    for {
+        tok, err := dec.Token()
+        if err != nil {
+            break
+        }
+        switch v := tok.(type) {
+        case json.Delim:
+            switch v {
+            case '{', '[':
+                record()
+                stack = append(stack, &posFrame{array: v == '[', wantKey: v == '{'})
+            case '}', ']':
+                stack = stack[:len(stack) - 1]
+                next()
+            }
+        default:
+            if len(stack) > 0 {
+                if top := stack[len(stack) - 1]; !top.array && top.wantKey {
+                    top.key, _ = v.(string)
+                    top.wantKey = false
+                    continue
+                }
+            }
+            record()
+            next()
+        }
+    }
+}
+
+// add records the line of a path, unless it is known already
+func (p positions) add(path string, line int) {
+    path = strings.ToLower(path)
+    if _, ok := p[path]; !ok {
+        p[path] = position{line: line}
+    }
+}
+
+// remove removes a path and all paths below it
+func (p positions) remove(path string) {
+    path = strings.ToLower(path)
+    for k := range p {
+        if (k == path) || strings.HasPrefix(k, path + "/") {
+            delete(p, k)
+        }
+    }
+}
+
+// setFile sets the file of all positions without one
+func (p positions) setFile(file string) {
+    for k, v := range p {
+        if v.file == "" {
+            v.file = file
+            p[k] = v
+        }
+    }
+}
+
+// line returns the line number of the first path found, or 0
+func (p positions) line(paths ...string) int {
+    for _, path := range paths {
+        if l, ok := p[strings.ToLower(path)]; ok {
+            return l.line
+        }
+    }
+    return 0
+}
+
+// where returns the "file:line" of the first path found, or ""
+func (p positions) where(paths ...string) string {
+    for _, path := range paths {
+        if l, ok := p[strings.ToLower(path)]; ok {
+            return l.file + ":" + strconv.Itoa(l.line)
+        }
+    }
+    return ""
+}
@@ -1,6 +1,7 @@
 package dispatcher
 
 // import "os"
+import "fmt"
 import "io/ioutil"
 import "encoding/json"
 
@@ -8,15 +9,48 @@ import "github.com/cnf/go-claw/clog"
 
 func (d *Dispatcher) readConfig() {
     clog.Info("Reading config file: %s", d.Configfile)
-    file, ferr := ioutil.ReadFile(d.Configfile)
-    if ferr != nil {
-        clog.Error("Failed to open file: %s", ferr.Error())
+    var err error
+    d.config, err = loadConfig(d.Configfile)
+    d.setConfigFiles(d.config.files)
+    if err != nil {
+        clog.Error("%s", err.Error())
         // clog.Stop()
         // os.Exit(1)
+        return
     }
+    d.parsed.set(d.checkConfig(&d.config))
+}
 
-    err := json.Unmarshal(file, &d.config)
-    if err != nil {
-        clog.Error("Failed to parse json data: %s", err.Error())
+// loadConfig reads and parses a config file, and keeps the positions of its
+// values
+func loadConfig(path string) (Config, error) {
+    cfg, pos, err := readConfigFile(path)
+    cfg.pos = pos
+    return cfg, err
+}
+
+// parseConfigFile reads and parses a single JSON, YAML or TOML config file,
+// and returns the positions of its values. Errors and positions refer to
+// the file by name.
+func parseConfigFile(path, name string) (Config, positions, error) {
+    var cfg Config
+    file, ferr := ioutil.ReadFile(path)
+    if ferr != nil {
+        return cfg, nil, fmt.Errorf("could not open config file: %s", ferr.Error())
+    }
+
+    format := configFormat(path)
+    data, pos, err := toJSON(format, file)
+    if err == nil {
+        if err = json.Unmarshal(data, &cfg); err != nil {
+            err = jsonError(err, format, data, pos)
+        }
+    }
+    if le, ok := err.(*lineError); ok {
+        return cfg, pos, fmt.Errorf("could not parse config file: %s:%d: %s", name, le.line, le.msg)
+    } else if err != nil {
+        return cfg, pos, fmt.Errorf("could not parse config file: %s: %s", name, err.Error())
     }
+    pos.setFile(name)
+    return cfg, pos, nil
 }
@@ -0,0 +1,162 @@
+package dispatcher
+
+import "os"
+import "fmt"
+import "time"
+import "reflect"
+import "strings"
+import "syscall"
+import "os/signal"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/targets"
+import "github.com/cnf/go-claw/clog"
+
+// configPoll is how often the config file is checked for changes
+const configPoll = 2 * time.Second
+
+// reloadSignals returns a channel receiving a value for every SIGHUP, and
+// every change of the config files if Watch is set
+func (d *Dispatcher) reloadSignals() chan bool {
+    reloads := make(chan bool, 1)
+    send := func() {
+        select {
+        case reloads <- true:
+        default:
+        }
+    }
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    go func() {
+        for range hup {
+            clog.Info("Received SIGHUP, reloading config")
+            send()
+        }
+    }()
+    if d.Watch {
+        go func() {
+            last := d.configState()
+            for {
+                time.Sleep(configPoll)
+                state := d.configState()
+                if state == last {
+                    continue
+                }
+                last = state
+                clog.Info("Config file changed, reloading")
+                send()
+            }
+        }()
+    }
+    return reloads
+}
+
+// setConfigFiles sets the files and directories the config was read from,
+// which are watched for changes
+func (d *Dispatcher) setConfigFiles(files []string) {
+    d.filesmu.Lock()
+    defer d.filesmu.Unlock()
+    d.files = files
+}
+
+// configState returns the modification times of the config files and the
+// directories they were included from
+func (d *Dispatcher) configState() string {
+    d.filesmu.Lock()
+    files := append([]string{d.Configfile}, d.files...)
+    d.filesmu.Unlock()
+    var state []string
+    for _, f := range files {
+        if fi, err := os.Stat(f); err == nil {
+            state = append(state, f + "@" + fi.ModTime().String())
+        }
+    }
+    return strings.Join(state, "\n")
+}
+
+// reload reads the config file again, and applies the changes. Listeners
+// and targets that did not change keep running. Nothing changes if the new
+// config has errors.
+func (d *Dispatcher) reload() error {
+    // Keys learned so far are read back from the learned file
+    d.learner.wait()
+    cfg, err := loadConfig(d.Configfile)
+    if err != nil {
+        d.setConfigFiles(cfg.files)
+        return err
+    }
+    // Checked before its modes are shared with the running dispatcher
+    parsed := d.checkConfig(&cfg)
+
+    // Create the new and changed listeners first, they are only started
+    // once everything checks out
+    created := make(map[string]listeners.Listener)
+    for k, v := range cfg.Listeners {
+        if old, ok := d.config.Listeners[k]; ok && reflect.DeepEqual(old, v) {
+            continue
+        }
+        l, ok := listeners.GetListener(v.Module, v.Params)
+        if !ok {
+            return fmt.Errorf("could not create listener '%s'", k)
+        }
+        created[k] = l
+    }
+    for k, v := range cfg.Targets {
+        if !targets.HasModule(v.Module) {
+            return fmt.Errorf("target '%s': module '%s' is not registered", k, v.Module)
+        }
+    }
+    if err := d.modes.Reload(cfg.Modes); err != nil {
+        return err
+    }
+    d.targetmanager.UpdateModes()
+
+    for k := range d.config.Listeners {
+        if _, ok := cfg.Listeners[k]; ok && (created[k] == nil) {
+            continue
+        }
+        clog.Info("Removing listener: %s", k)
+        d.cs.RemoveListener(k)
+        delete(d.listenermap, k)
+    }
+    for k, l := range created {
+        clog.Info("Setting up listener: %s", k)
+        if mw, ok := l.(listeners.ModeWatcher); ok {
+            mw.SetModes(d.modes)
+        }
+        l := l
+        d.listenermap[k] = &l
+        d.cs.AddNamedListener(k, l)
+    }
+
+    changed := 0
+    for k, v := range d.config.Targets {
+        if nv, ok := cfg.Targets[k]; ok && reflect.DeepEqual(v, nv) {
+            continue
+        }
+        clog.Info("Removing target: %s", k)
+        if err := d.targetmanager.Remove(strings.ToLower(k)); err != nil {
+            clog.Warn("Could not remove target '%s': %s", k, err.Error())
+        }
+    }
+    for k, v := range cfg.Targets {
+        if ov, ok := d.config.Targets[k]; ok && reflect.DeepEqual(v, ov) {
+            continue
+        }
+        changed++
+        clog.Info("Setting up target: %s", k)
+        if err := d.targetmanager.Add(v.Module, k, v.Params); err != nil {
+            clog.Warn("Could not add target '%s:%s': %s", v.Module, k, err.Error())
+        }
+    }
+
+    d.targetmanager.SetMacros(cfg.Macros)
+    d.translator.reset(cfg.Translate)
+    d.learner.reset(cfg.Learned)
+    d.config = cfg
+    d.setConfigFiles(cfg.files)
+    d.checkListenerKeys()
+    d.parsed.set(parsed)
+    clog.Warn("Reloaded config: %d listeners and %d targets (re)started", len(created), changed)
+    return nil
+}
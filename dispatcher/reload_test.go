@@ -0,0 +1,114 @@
+package dispatcher
+
+import "os"
+import "sync"
+import "time"
+import "reflect"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/cnf/go-claw/listeners"
+
+type reloadListener struct {
+    id string
+    stop chan bool
+}
+
+var reloadMu sync.Mutex
+var reloadStarted = make(map[string]bool)
+var reloadStopped = make(map[string]bool)
+
+func (l *reloadListener) RunListener(cs *listeners.CommandStream) {
+    reloadMu.Lock()
+    reloadStarted[l.id] = true
+    reloadMu.Unlock()
+    <- l.stop
+}
+
+func (l *reloadListener) StopListener() error {
+    reloadMu.Lock()
+    reloadStopped[l.id] = true
+    reloadMu.Unlock()
+    close(l.stop)
+    return nil
+}
+
+func Test_Reload(t *testing.T) {
+    listeners.RegisterListener("reloadtest", func(params map[string]string) (listeners.Listener, bool) {
+        return &reloadListener{id: params["id"], stop: make(chan bool)}, params["id"] != ""
+    })
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    cfgfile := filepath.Join(dir, "config.json")
+    ioutil.WriteFile(cfgfile, []byte(`{
+        "listeners": {
+            "a": {"module": "reloadtest", "params": {"id": "a1"}},
+            "b": {"module": "reloadtest", "params": {"id": "b1"}},
+            "c": {"module": "reloadtest", "params": {"id": "c1"}}
+        },
+        "modes": {"default": {"keys": {}}, "tv": {"keys": {"KEY_OK": ["TV::select"]}}}
+    }`), 0644)
+
+    d := &Dispatcher{Configfile: cfgfile}
+    d.readConfig()
+    d.setupModes()
+    d.setupListeners()
+    d.setupTargets()
+    d.setupLearner()
+    d.modes.SetActive("tv")
+
+    // Broken configs are not applied
+    ioutil.WriteFile(cfgfile, []byte(`{"modes": `), 0644)
+    if err := d.reload(); err == nil {
+        t.Errorf("expected an error for a broken config")
+    }
+    ioutil.WriteFile(cfgfile, []byte(`{"listeners": {"a": {"module": "nosuchmodule"}}}`), 0644)
+    if err := d.reload(); err == nil {
+        t.Errorf("expected an error for an unknown listener module")
+    }
+
+    ioutil.WriteFile(cfgfile, []byte(`{
+        "listeners": {
+            "a": {"module": "reloadtest", "params": {"id": "a1"}},
+            "b": {"module": "reloadtest", "params": {"id": "b2"}},
+            "d": {"module": "reloadtest", "params": {"id": "d1"}}
+        },
+        "modes": {"default": {"keys": {}}, "tv": {"keys": {"KEY_BACK": ["TV::back"], "KEY_INFO": [{"if": "mode == tv", "then": ["TV::info"]}]}}, "music": {"keys": {}}}
+    }`), 0644)
+    if err := d.reload(); err != nil {
+        t.Fatal(err)
+    }
+    if name := d.modes.ActiveName(); name != "tv" {
+        t.Errorf("expected mode 'tv' to stay active, got '%s'", name)
+    }
+    if _, err := d.modes.BindingFor("KEY_BACK"); err != nil {
+        t.Errorf("new key binding not loaded: %s", err)
+    }
+    if _, ok := d.parsed.parsed["mode == tv"]; !ok {
+        t.Errorf("expected the conditions of the reloaded config to be parsed")
+    }
+    if err := d.targetmanager.Check("claw::mode music"); err != nil {
+        t.Errorf("new mode not accepted by claw::mode: %s", err)
+    }
+    if d.cs.Count() != 3 {
+        t.Errorf("expected 3 listeners, got %d", d.cs.Count())
+    }
+    started := map[string]bool{"a1": true, "b1": true, "b2": true, "c1": true, "d1": true}
+    stopped := map[string]bool{"b1": true, "c1": true}
+    for i := 0; i < 100; i++ {
+        reloadMu.Lock()
+        ok := reflect.DeepEqual(reloadStarted, started) && reflect.DeepEqual(reloadStopped, stopped)
+        reloadMu.Unlock()
+        if ok {
+            return
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    reloadMu.Lock()
+    defer reloadMu.Unlock()
+    t.Errorf("expected started %v and stopped %v listeners, got %v and %v", started, stopped, reloadStarted, reloadStopped)
+}
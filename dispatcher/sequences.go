@@ -0,0 +1,179 @@
+package dispatcher
+
+import "time"
+import "strings"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+
+// sequences collects key presses into key sequences and numbers
+type sequences struct {
+    modes *modes.Modes
+    // keys pressed so far that start a sequence
+    keys []listeners.RemoteCommand
+    match *modes.Binding
+    // numeric entry buffer
+    digits string
+    digitcfg *modes.Digits
+    // keys whose hold and release events should be skipped
+    consumed map[string]bool
+
+    timeouts chan int
+    seqid int
+    digitid int
+    nextid int
+}
+
+func newSequences(m *modes.Modes) *sequences {
+    return &sequences{modes: m, consumed: make(map[string]bool), timeouts: make(chan int)}
+}
+
+func (s *sequences) startTimer(t time.Duration) int {
+    s.nextid++
+    id := s.nextid
+    time.AfterFunc(t, func() {
+        s.timeouts <- id
+    })
+    return id
+}
+
+// press handles a key press. It returns the action lists to run, and if the
+// key was consumed by a sequence or the numeric entry buffer.
+func (s *sequences) press(rc *listeners.RemoteCommand) ([]modes.Actions, bool) {
+    var run []modes.Actions
+    delete(s.consumed, rc.Origin() + "\x00" + rc.Key)
+    keys := append(append([]listeners.RemoteCommand{}, s.keys...), *rc)
+    pressed := make([]modes.PressedKey, len(keys))
+    for i := range keys {
+        pressed[i] = modes.PressedKey{Listener: keys[i].Listener, Source: keys[i].Source, Key: keys[i].Key}
+    }
+    binding, longer := s.modes.MatchSequence(pressed)
+    if longer {
+        // Wait for the next key of the sequence
+        s.keys = keys
+        s.match = binding
+        s.seqid = s.startTimer(s.modes.SequenceTimeout())
+        s.consume(rc)
+        return nil, true
+    }
+    if binding != nil {
+        s.keys = nil
+        s.match = nil
+        s.seqid = 0
+        s.consume(rc)
+        return append(run, tap(binding)...), true
+    }
+    if len(s.keys) > 0 {
+        // Not a sequence after all, start over with this key
+        run = s.flushSequence()
+        more, consumed := s.press(rc)
+        return append(run, more...), consumed
+    }
+    more, consumed := s.single(rc)
+    if consumed {
+        s.consume(rc)
+    }
+    return append(run, more...), consumed
+}
+
+// single passes a key that is not part of a sequence to the numeric entry
+// buffer
+func (s *sequences) single(rc *listeners.RemoteCommand) ([]modes.Actions, bool) {
+    key := rc.Key
+    if (s.digits != "") && (key == s.digitcfg.Enter) {
+        return s.flushDigits(), true
+    }
+    if !modes.IsDigitKey(key) {
+        if s.digits != "" {
+            return s.flushDigits(), false
+        }
+        return nil, false
+    }
+    cfg := s.modes.Digits()
+    if (cfg == nil) || (len(cfg.Actions) == 0) {
+        return nil, false
+    }
+    s.digitcfg = cfg
+    s.digits += key[4:]
+    if (cfg.Max > 0) && (len(s.digits) >= cfg.Max) {
+        return s.flushDigits(), true
+    }
+    s.digitid = s.startTimer(cfg.TimeoutDuration())
+    return nil, true
+}
+
+// flushSequence runs the sequence matched so far, or the keys one by one
+func (s *sequences) flushSequence() []modes.Actions {
+    keys, match := s.keys, s.match
+    s.keys = nil
+    s.match = nil
+    s.seqid = 0
+    if match != nil {
+        return tap(match)
+    }
+    var run []modes.Actions
+    for i := range keys {
+        k := &keys[i]
+        more, consumed := s.single(k)
+        run = append(run, more...)
+        if consumed {
+            continue
+        }
+        if binding, err := s.modes.BindingFrom(k.Listener, k.Source, k.Key); err == nil {
+            run = append(run, tap(binding)...)
+        }
+    }
+    return run
+}
+
+// tap returns the press and release actions of a binding. The hold and
+// release events of keys in a sequence are skipped, so anything started on
+// press is stopped right away.
+func tap(binding *modes.Binding) []modes.Actions {
+    run := []modes.Actions{binding.Press.WithPolicy(binding.ErrorPolicy())}
+    if len(binding.Release) > 0 {
+        run = append(run, binding.Release.WithPolicy(binding.ErrorPolicy()))
+    }
+    return run
+}
+
+// flushDigits returns the digit actions with the collected number filled in
+func (s *sequences) flushDigits() []modes.Actions {
+    digits, cfg := s.digits, s.digitcfg
+    s.digits = ""
+    s.digitid = 0
+    if (digits == "") || (cfg == nil) {
+        return nil
+    }
+    actions := cfg.Actions.WithPolicy(cfg.ErrorPolicy()).Map(func(index, a string) string {
+        return strings.Replace(a, "{digits}", digits, -1)
+    })
+    return []modes.Actions{actions}
+}
+
+// timeout handles an expired sequence or digit timer
+func (s *sequences) timeout(id int) []modes.Actions {
+    switch id {
+    case s.seqid:
+        return s.flushSequence()
+    case s.digitid:
+        return s.flushDigits()
+    }
+    return nil
+}
+
+func (s *sequences) consume(rc *listeners.RemoteCommand) {
+    s.consumed[rc.Origin() + "\x00" + rc.Key] = true
+}
+
+// skip returns true for hold and release events of consumed key presses
+func (s *sequences) skip(rc *listeners.RemoteCommand) bool {
+    name := rc.Origin() + "\x00" + rc.Key
+    if !s.consumed[name] {
+        return false
+    }
+    if rc.Event == listeners.KeyRelease {
+        delete(s.consumed, name)
+    }
+    return true
+}
@@ -0,0 +1,91 @@
+package dispatcher
+
+import "time"
+import "strings"
+import "testing"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+
+func Test_Sequences(t *testing.T) {
+    m := &modes.Modes{}
+    m.Setup(map[string]*modes.Mode{
+        "default": &modes.Mode{
+            SequenceTimeout: "50ms",
+            Digits: &modes.Digits{Actions: modes.NewActions("TV::channel {digits}"), Timeout: "50ms", Enter: "KEY_OK", Max: 3},
+            Keys: map[string]*modes.Binding{
+                "KEY_RED": &modes.Binding{Press: modes.NewActions("red")},
+                "KEY_RED KEY_GREEN": &modes.Binding{Press: modes.NewActions("redgreen")},
+                "KEY_UP": &modes.Binding{Press: modes.NewActions("start"), Hold: modes.NewActions("hold"), Release: modes.NewActions("stop")},
+                "KEY_UP KEY_UP": &modes.Binding{Press: modes.NewActions("top")},
+                "KEY_1 KEY_2 KEY_3": &modes.Binding{Press: modes.NewActions("pin")},
+            },
+        },
+    })
+    s := newSequences(m)
+    str := func(run []modes.Actions) string {
+        var ret []string
+        for _, a := range run {
+            ret = append(ret, strings.Join(a.Commands(), ","))
+        }
+        return strings.Join(ret, ";")
+    }
+    press := func(key string, want string, wantconsumed bool) {
+        run, consumed := s.press(&listeners.RemoteCommand{Key: key, Source: "lirc", Event: listeners.KeyPress})
+        if (str(run) != want) || (consumed != wantconsumed) {
+            t.Errorf("%s: expected '%s' %v, got '%s' %v", key, want, wantconsumed, str(run), consumed)
+        }
+    }
+    wait := func(want string) {
+        for {
+            select {
+            case id := <- s.timeouts:
+                if run := s.timeout(id); run != nil {
+                    if str(run) != want {
+                        t.Errorf("timeout: expected '%s', got '%s'", want, str(run))
+                    }
+                    return
+                }
+            case <- time.After(200 * time.Millisecond):
+                t.Errorf("timeout: expected '%s', got nothing", want)
+                return
+            }
+        }
+    }
+
+    press("KEY_RED", "", true)
+    press("KEY_GREEN", "redgreen", true)
+
+    // Sequence broken by another key
+    press("KEY_RED", "", true)
+    press("KEY_BLUE", "red", false)
+
+    // Sequence timed out
+    press("KEY_RED", "", true)
+    wait("red")
+
+    press("KEY_1", "", true)
+    press("KEY_2", "", true)
+    press("KEY_3", "pin", true)
+
+    // Flushed keys run their release actions too, their own release is
+    // skipped
+    press("KEY_UP", "", true)
+    wait("start;stop")
+    if !s.skip(&listeners.RemoteCommand{Key: "KEY_UP", Source: "lirc", Event: listeners.KeyRelease}) {
+        t.Errorf("expected the release of a flushed key to be skipped")
+    }
+    press("KEY_UP", "", true)
+    press("KEY_RED", "start;stop", true)
+    wait("red")
+
+    // Numeric entry
+    press("KEY_1", "", true)
+    press("KEY_5", "", true)
+    press("KEY_OK", "TV::channel 15", true)
+    press("KEY_4", "", true)
+    wait("TV::channel 4")
+    press("KEY_9", "", true)
+    press("KEY_9", "", true)
+    press("KEY_9", "TV::channel 999", true)
+}
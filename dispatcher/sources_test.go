@@ -0,0 +1,58 @@
+package dispatcher
+
+import "strings"
+import "testing"
+
+import "github.com/cnf/go-claw/modes"
+
+func Test_SourceBindings(t *testing.T) {
+    m := &modes.Modes{}
+    m.Setup(map[string]*modes.Mode{
+        "default": &modes.Mode{
+            Keys: map[string]*modes.Binding{
+                "KEY_OK": &modes.Binding{Press: modes.NewActions("ok")},
+                "kids/KEY_OK": &modes.Binding{Press: modes.NewActions("kids")},
+                "lounge:KEY_OK": &modes.Binding{Press: modes.NewActions("lounge")},
+                "lounge:kids/KEY_OK": &modes.Binding{Press: modes.NewActions("lounge kids")},
+                "KEY_BACK": &modes.Binding{Press: modes.NewActions("back")},
+            },
+        },
+        "tv": &modes.Mode{
+            Keys: map[string]*modes.Binding{
+                "KEY_BACK": &modes.Binding{Press: modes.NewActions("tv back")},
+            },
+        },
+    })
+    tests := []struct {
+        listener, source, key, want string
+    }{
+        {"", "", "KEY_OK", "ok"},
+        {"lirc", "living", "KEY_OK", "ok"},
+        {"lirc", "kids", "KEY_OK", "kids"},
+        {"lounge", "living", "KEY_OK", "lounge"},
+        {"lounge", "kids", "KEY_OK", "lounge kids"},
+        {"lounge", "kids", "KEY_BACK", "back"},
+    }
+    check := func() {
+        for _, tt := range tests {
+            b, err := m.BindingFrom(tt.listener, tt.source, tt.key)
+            if err != nil {
+                t.Errorf("%s:%s/%s: %s", tt.listener, tt.source, tt.key, err)
+                continue
+            }
+            if got := strings.Join(b.Press.Commands(), ","); got != tt.want {
+                t.Errorf("%s:%s/%s: expected '%s', got '%s'", tt.listener, tt.source, tt.key, tt.want, got)
+            }
+        }
+    }
+    check()
+
+    // The active mode wins over qualified bindings in the default mode
+    m.SetActive("tv")
+    tests[5].want = "tv back"
+    check()
+
+    if got := strings.Join(m.Keys(), ","); got != "KEY_BACK,KEY_OK" {
+        t.Errorf("Keys: expected 'KEY_BACK,KEY_OK', got '%s'", got)
+    }
+}
@@ -0,0 +1,80 @@
+package dispatcher
+
+import "sync"
+
+import "github.com/cnf/go-claw/listeners"
+
+// Translations map the raw codes or key names a remote sends to the key
+// names used in the modes. The tables are keyed by "listener:source",
+// "source", "listener:" or "*" for every remote, the most specific table
+// with a match wins. Within a table a code wins over a key name.
+type Translations map[string]map[string]string
+
+// translator renames incoming keys using the translation tables
+type translator struct {
+    mu sync.Mutex
+    tables Translations
+}
+
+func newTranslator(t Translations) *translator {
+    if t == nil {
+        t = make(Translations)
+    }
+    return &translator{tables: t}
+}
+
+// tableNames returns the names of the tables that apply to a command, most
+// specific first
+func tableNames(rc *listeners.RemoteCommand) []string {
+    var names []string
+    if rc.Listener != "" {
+        names = append(names, rc.Listener + ":" + rc.Source)
+    }
+    names = append(names, rc.Source)
+    if rc.Listener != "" {
+        names = append(names, rc.Listener + ":")
+    }
+    return append(names, "*")
+}
+
+// translate sets the key of a command to its canonical name, and returns
+// true if it was translated
+func (t *translator) translate(rc *listeners.RemoteCommand) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for _, name := range tableNames(rc) {
+        table := t.tables[name]
+        if table == nil {
+            continue
+        }
+        if key, ok := table[rc.Code]; ok && (rc.Code != "") {
+            rc.Key = key
+            return true
+        }
+        if key, ok := table[rc.Key]; ok {
+            rc.Key = key
+            return true
+        }
+    }
+    return false
+}
+
+// reset replaces all translation tables
+func (t *translator) reset(tables Translations) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if tables == nil {
+        tables = make(Translations)
+    }
+    t.tables = tables
+}
+
+// set adds a translation to a table
+func (t *translator) set(table, raw, key string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.tables[table] == nil {
+        t.tables[table] = make(map[string]string)
+    }
+    t.tables[table][raw] = key
+}
@@ -0,0 +1,33 @@
+package dispatcher
+
+import "testing"
+
+import "github.com/cnf/go-claw/listeners"
+
+func Test_Translate(t *testing.T) {
+    tr := newTranslator(Translations{
+        "*": {"KEY_ENTER": "KEY_OK", "OK": "KEY_OK"},
+        "kids": {"OK": "KEY_SELECT", "0x10": "KEY_MENU"},
+        "lounge:": {"KEY_ENTER": "KEY_PLAY"},
+        "lounge:kids": {"0x20": "KEY_STOP"},
+    })
+    tests := []struct {
+        listener, source, code, key, want string
+    }{
+        {"lirc", "living", "", "KEY_ENTER", "KEY_OK"},
+        {"lirc", "living", "", "OK", "KEY_OK"},
+        {"lirc", "living", "", "KEY_UP", "KEY_UP"},
+        {"lirc", "kids", "", "OK", "KEY_SELECT"},
+        {"lirc", "kids", "0x10", "OK", "KEY_MENU"},
+        {"lounge", "living", "", "KEY_ENTER", "KEY_PLAY"},
+        {"lounge", "kids", "0x20", "KEY_ENTER", "KEY_STOP"},
+        {"lounge", "kids", "0x21", "OK", "KEY_SELECT"},
+    }
+    for _, tt := range tests {
+        rc := &listeners.RemoteCommand{Listener: tt.listener, Source: tt.source, Code: tt.code, Key: tt.key}
+        translated := tr.translate(rc)
+        if (rc.Key != tt.want) || (translated != (tt.key != tt.want)) {
+            t.Errorf("%s:%s %s/%s: expected '%s', got '%s' %v", tt.listener, tt.source, tt.code, tt.key, tt.want, rc.Key, translated)
+        }
+    }
+}
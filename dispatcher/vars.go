@@ -0,0 +1,239 @@
+package dispatcher
+
+import "os"
+import "fmt"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/cnf/go-claw/modes"
+
+// Variables are substituted in listener and target parameters, and in
+// action strings, when the config is read:
+//
+//   ${name}       a variable from the vars section
+//   ${env:NAME}   an environment variable
+//   ${file:path}  the contents of a file without trailing newlines, relative
+//                 to the config file, for secrets
+//
+// Variables can use other variables. A literal ${ is written as $${.
+
+// variables expands references to variables, the environment and files
+type variables struct {
+    vars map[string]string
+    dir string
+    // The variables being expanded, to find loops
+    expanding map[string]bool
+}
+
+func newVariables(vars map[string]string, dir string) *variables {
+    return &variables{vars: vars, dir: dir, expanding: make(map[string]bool)}
+}
+
+// expand substitutes all references in s
+func (v *variables) expand(s string) (string, error) {
+    if !strings.Contains(s, "${") {
+        return s, nil
+    }
+    var ret []string
+    for {
+        i := strings.Index(s, "${")
+        if i < 0 {
+            break
+        }
+        if (i > 0) && (s[i - 1] == '$') {
+            ret = append(ret, s[:i - 1], "${")
+            s = s[i + 2:]
+            continue
+        }
+        end := strings.Index(s[i:], "}")
+        if end < 0 {
+            return "", fmt.Errorf("missing `}` after `%s`", s[i:])
+        }
+        val, err := v.lookup(s[i + 2:i + end])
+        if err != nil {
+            return "", err
+        }
+        ret = append(ret, s[:i], val)
+        s = s[i + end + 1:]
+    }
+    return strings.Join(append(ret, s), ""), nil
+}
+
+// lookup returns the value of a reference
+func (v *variables) lookup(ref string) (string, error) {
+    switch {
+    case strings.HasPrefix(ref, "env:"):
+        val, ok := os.LookupEnv(ref[4:])
+        if !ok {
+            return "", fmt.Errorf("environment variable `%s` is not set", ref[4:])
+        }
+        return val, nil
+    case strings.HasPrefix(ref, "file:"):
+        path := ref[5:]
+        if !filepath.IsAbs(path) {
+            path = filepath.Join(v.dir, path)
+        }
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return "", fmt.Errorf("could not read `%s`: %s", ref[5:], err.Error())
+        }
+        return strings.TrimRight(string(data), "\r\n"), nil
+    }
+    val, ok := v.vars[ref]
+    if !ok {
+        return "", fmt.Errorf("undefined variable `%s`", ref)
+    }
+    if v.expanding[ref] {
+        return "", fmt.Errorf("variable `%s` uses itself", ref)
+    }
+    v.expanding[ref] = true
+    defer delete(v.expanding, ref)
+    return v.expand(val)
+}
+
+// params returns a copy of params with all references expanded
+func (v *variables) params(params map[string]string, path string) (map[string]string, error) {
+    if params == nil {
+        return nil, nil
+    }
+    ret := make(map[string]string, len(params))
+    for k, p := range params {
+        val, err := v.expand(p)
+        if err != nil {
+            return nil, &varError{[]string{path + "/params/" + k}, "parameter `" + k + "`", err}
+        }
+        ret[k] = val
+    }
+    return ret, nil
+}
+
+// actions returns a copy of a list of actions with all references expanded
+func (v *variables) actions(actions modes.Actions, paths ...string) (modes.Actions, error) {
+    var fail error
+    expand := func(what string) func(index, s string) string {
+        return func(index, s string) string {
+            if fail != nil {
+                return s
+            }
+            val, err := v.expand(s)
+            if err != nil {
+                var at []string
+                for _, p := range paths {
+                    at = append(at, p + "/" + index)
+                }
+                fail = &varError{append(at, paths...), what + " `" + s + "`", err}
+            }
+            return val
+        }
+    }
+    ret := actions.Map(expand("action")).MapConditions(expand("condition"))
+    if fail != nil {
+        return nil, fail
+    }
+    return ret, nil
+}
+
+// varError is an error expanding a value at one of paths
+type varError struct {
+    paths []string
+    what string
+    err error
+}
+
+func (e *varError) Error() string {
+    return e.what + ": " + e.err.Error()
+}
+
+// expandVars expands the references in the parameters and actions of the
+// config read from file
+func (r *configReader) expandVars(file string) error {
+    v := newVariables(r.cfg.Vars, r.dir)
+    fail := func(err error, what string) error {
+        if ve, ok := err.(*varError); ok {
+            return fmt.Errorf("could not parse config file: %s: %s, %s", where(r.pos, file, ve.paths...), what, ve.Error())
+        }
+        return err
+    }
+    for _, k := range sortedKeys(r.cfg.Listeners) {
+        l := r.cfg.Listeners[k]
+        params, err := v.params(l.Params, "listeners/" + k)
+        if err != nil {
+            return fail(err, "listener `" + k + "`")
+        }
+        l.Params = params
+        r.cfg.Listeners[k] = l
+    }
+    for _, k := range sortedKeys(r.cfg.Targets) {
+        t := r.cfg.Targets[k]
+        params, err := v.params(t.Params, "targets/" + k)
+        if err != nil {
+            return fail(err, "target `" + k + "`")
+        }
+        t.Params = params
+        r.cfg.Targets[k] = t
+    }
+    for _, name := range sortedKeys(r.cfg.Macros) {
+        actions, err := v.actions(r.cfg.Macros[name], "macros/" + name)
+        if err != nil {
+            return fail(err, "macro `" + name + "`")
+        }
+        r.cfg.Macros[name] = actions
+    }
+    for _, name := range sortedKeys(r.cfg.Modes) {
+        if m := r.cfg.Modes[name]; m != nil {
+            if err := v.mode(m, "modes/" + name); err != nil {
+                return fail(err, "mode `" + name + "`")
+            }
+        }
+    }
+    return nil
+}
+
+// mode expands the references in the actions of a mode
+func (v *variables) mode(m *modes.Mode, path string) error {
+    var err error
+    if m.Entry, err = v.actions(m.Entry, path + "/entry"); err != nil {
+        return err
+    }
+    if m.Exit, err = v.actions(m.Exit, path + "/exit"); err != nil {
+        return err
+    }
+    if m.Digits != nil {
+        if m.Digits.Actions, err = v.actions(m.Digits.Actions, path + "/digits/actions"); err != nil {
+            return err
+        }
+    }
+    if m.OnError != nil {
+        if m.OnError.Fallback, err = v.actions(m.OnError.Fallback, path + "/onerror/fallback"); err != nil {
+            return err
+        }
+    }
+    for _, key := range sortedKeys(m.Keys) {
+        b := m.Keys[key]
+        if b == nil {
+            continue
+        }
+        kpath := path + "/keys/" + key
+        if b.OnError != nil {
+            if b.OnError.Fallback, err = v.actions(b.OnError.Fallback, kpath + "/onerror/fallback"); err != nil {
+                return err
+            }
+        }
+        // A plain list of actions is both the press and hold actions
+        plain := sameActions(b.Press, b.Hold)
+        for _, e := range []struct{
+            actions *modes.Actions
+            event string
+        }{{&b.Press, "press"}, {&b.Hold, "hold"}, {&b.Release, "release"}, {&b.Long, "long"}, {&b.Double, "double"}} {
+            if (e.event == "hold") && plain {
+                b.Hold = b.Press
+                continue
+            }
+            if *e.actions, err = v.actions(*e.actions, kpath + "/" + e.event, kpath); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,104 @@
+package dispatcher
+
+import "os"
+import "strings"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+func Test_ExpandVars(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    writeConfig(t, dir, "token", "s3cret\n")
+    os.Setenv("CLAW_TEST_HOST", "10.0.0.5")
+    defer os.Unsetenv("CLAW_TEST_HOST")
+
+    v := newVariables(map[string]string{
+        "avr": "${env:CLAW_TEST_HOST}",
+        "url": "http://${avr}:8080",
+        "volume": "40",
+        "loop": "${loop2}",
+        "loop2": "${loop}",
+    }, dir)
+    tests := map[string]string{
+        "AVR::volume ${volume}": "AVR::volume 40",
+        "${url}/x": "http://10.0.0.5:8080/x",
+        "${file:token}": "s3cret",
+        "${file:" + filepath.Join(dir, "token") + "}": "s3cret",
+        "$${volume} ${volume}": "${volume} 40",
+        "no vars {digits}": "no vars {digits}",
+    }
+    for in, expect := range tests {
+        out, err := v.expand(in)
+        if (err != nil) || (out != expect) {
+            t.Errorf("expand(`%s`): expected `%s`, got `%s` (%v)", in, expect, out, err)
+        }
+    }
+    errors := map[string]string{
+        "${nope}": "undefined variable `nope`",
+        "${env:CLAW_TEST_NOPE}": "environment variable `CLAW_TEST_NOPE` is not set",
+        "${file:nope}": "could not read `nope`",
+        "${loop}": "variable `loop` uses itself",
+        "${volume": "missing `}`",
+    }
+    for in, expect := range errors {
+        if _, err := v.expand(in); (err == nil) || !strings.HasPrefix(err.Error(), expect) {
+            t.Errorf("expand(`%s`): expected error `%s`, got %v", in, expect, err)
+        }
+    }
+}
+
+func Test_ConfigVars(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    writeConfig(t, dir, "token", "s3cret\n")
+    cfgfile := writeConfig(t, dir, "config.json", `{
+    "vars": {"avr": "10.0.0.5", "preset": "40"},
+    "listeners": {"web": {"module": "webremote", "params": {"token": "${file:token}"}}},
+    "targets": {"AVR": {"module": "onkyo", "params": {"host": "${avr}"}}},
+    "modes": {
+        "default": {
+            "entry": ["AVR::volume ${preset}"],
+            "keys": {
+                "KEY_OK": ["AVR::volume ${preset}"],
+                "KEY_UP": {"press": ["AVR::volume ${preset}"], "hold": ["AVR::volumeup"]}
+            }
+        }
+    }
+}`)
+    cfg, err := loadConfig(cfgfile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if (cfg.Listeners["web"].Params["token"] != "s3cret") || (cfg.Targets["AVR"].Params["host"] != "10.0.0.5") {
+        t.Errorf("parameters not expanded: %v %v", cfg.Listeners, cfg.Targets)
+    }
+    m := cfg.Modes["default"]
+    ok, up := m.Keys["KEY_OK"], m.Keys["KEY_UP"]
+    if (m.Entry[0].Command != "AVR::volume 40") || (ok.Press[0].Command != "AVR::volume 40") || (up.Press[0].Command != "AVR::volume 40") {
+        t.Errorf("actions not expanded: %v %v %v", m.Entry, ok.Press, up.Press)
+    }
+    if !sameActions(ok.Press, ok.Hold) || (up.Hold[0].Command != "AVR::volumeup") {
+        t.Errorf("wrong hold actions: %v %v", ok.Hold, up.Hold)
+    }
+
+    writeConfig(t, dir, "config.json", `{
+    "modes": {
+        "default": {
+            "keys": {
+                "KEY_OK": ["AVR::volume ${preset}"]
+            }
+        }
+    }
+}`)
+    expect := "could not parse config file: config.json:5: mode `default`, action `AVR::volume ${preset}`: undefined variable `preset`"
+    if _, err := loadConfig(cfgfile); (err == nil) || (err.Error() != expect) {
+        t.Errorf("expected error `%s`, got `%v`", expect, err)
+    }
+}
@@ -11,7 +11,7 @@ import "os/signal"
 //import "strings"
 //import "strconv"
 import "bufio"
-import "code.google.com/p/go.net/websocket"
+import "golang.org/x/net/websocket"
 
 //import "os"
 
@@ -168,7 +168,8 @@ var indexpg = template.Must(template.New("index").Parse(`
         <title>Web remote</title>
         <script>
 var path = window.location.pathname;
-var wsURL = "ws://" + window.location.host +  path.substring(0, path.lastIndexOf('/')) + "/sock";
+var wsScheme = (window.location.protocol == "https:") ? "wss://" : "ws://";
+var wsURL = wsScheme + window.location.host +  path.substring(0, path.lastIndexOf('/')) + "/sock";
 var ws;
 var connected = false;
 var lastsent = ""
@@ -0,0 +1,320 @@
+package main
+
+import "os"
+import "fmt"
+import "flag"
+import "sort"
+import "strings"
+import "io/ioutil"
+import "encoding/json"
+
+import "github.com/cnf/go-claw/dispatcher"
+
+// harmonyConfig is the hub configuration as saved by Harmony backup tools
+type harmonyConfig struct {
+    Activity []*harmonyActivity `json:"activity"`
+    Device []*harmonyDevice     `json:"device"`
+}
+
+type harmonyDevice struct {
+    ID string                    `json:"id"`
+    Label string                 `json:"label"`
+    Manufacturer string          `json:"manufacturer"`
+    Model string                 `json:"model"`
+    DeviceTypeDisplayName string `json:"deviceTypeDisplayName"`
+}
+
+type harmonyActivity struct {
+    ID string                            `json:"id"`
+    Label string                         `json:"label"`
+    ControlGroup []*harmonyControlGroup  `json:"controlGroup"`
+    // Power and input state of every device used by the activity
+    Fixit map[string]*harmonyFixit       `json:"fixit"`
+}
+
+type harmonyControlGroup struct {
+    Name string                  `json:"name"`
+    Function []*harmonyFunction  `json:"function"`
+}
+
+type harmonyFunction struct {
+    Name string   `json:"name"`
+    Label string  `json:"label"`
+    // JSON encoded harmonyAction
+    Action string `json:"action"`
+}
+
+type harmonyAction struct {
+    Command string  `json:"command"`
+    DeviceID string `json:"deviceId"`
+}
+
+type harmonyFixit struct {
+    Power string `json:"Power"`
+    Input string `json:"Input"`
+}
+
+// harmonyModules maps harmony commands to the commands of claw target
+// modules, by module
+var harmonyModules = map[string]map[string]string{
+    "denon": {
+        "poweron": "poweron", "poweroff": "poweroff",
+        "volumeup": "volumeup", "volumedown": "volumedown", "mute": "mute",
+        "directionup": "moveup", "directiondown": "movedown",
+        "directionleft": "moveleft", "directionright": "moveright",
+        "select": "select", "ok": "select", "back": "back", "info": "info",
+    },
+    "onkyo": {
+        "poweron": "power on", "poweroff": "power off", "powertoggle": "power toggle",
+        "volumeup": "volumeup", "volumedown": "volumedown", "mute": "mute toggle",
+    },
+    "plex": {
+        "poweron": "poweron",
+        "directionup": "smartup", "directiondown": "smartdown",
+        "directionleft": "smartleft", "directionright": "smartright",
+        "select": "smartselect", "ok": "smartselect", "back": "back", "home": "home",
+        "play": "play", "pause": "pause", "stop": "stop",
+        "skipforward": "skipnext", "skipbackward": "skipprevious",
+    },
+    "cec": {
+        "poweron": "poweron", "poweroff": "standby",
+        "volumeup": "volumeup", "volumedown": "volumedown", "mute": "mute",
+    },
+    "linux": {
+        "poweron": "poweron",
+    },
+}
+
+// harmonyKeys maps harmony button names to key names
+var harmonyKeys = map[string]string{
+    "directionup": "KEY_UP", "directiondown": "KEY_DOWN",
+    "directionleft": "KEY_LEFT", "directionright": "KEY_RIGHT",
+    "select": "KEY_OK", "ok": "KEY_OK", "return": "KEY_BACK",
+    "guide": "KEY_EPG", "skipforward": "KEY_NEXT", "skipbackward": "KEY_PREVIOUS",
+    "prevchannel": "KEY_LAST",
+}
+
+// importHarmony prints a config with targets and modes for the devices and
+// activities of a harmony hub configuration
+func importHarmony(args []string) int {
+    fs := flag.NewFlagSet("import-harmony", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(os.Stderr, "Usage: %s import-harmony harmony.json\n", os.Args[0])
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fs.Usage()
+        return 2
+    }
+    data, err := ioutil.ReadFile(fs.Arg(0))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Could not read %s: %s\n", fs.Arg(0), err)
+        return 1
+    }
+    var hc harmonyConfig
+    if err = json.Unmarshal(data, &hc); err != nil {
+        fmt.Fprintf(os.Stderr, "Could not parse %s: %s\n", fs.Arg(0), err)
+        return 1
+    }
+    cfg, warnings := harmonyImport(&hc)
+    for _, w := range warnings {
+        fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+    }
+    if data, err = json.MarshalIndent(cfg, "", "  "); err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err)
+        return 1
+    }
+    fmt.Printf("%s\n", data)
+    return 0
+}
+
+type harmonyMode struct {
+    Entry []string           `json:"entry"`
+    Exit []string            `json:"exit"`
+    Keys map[string][]string `json:"keys"`
+}
+
+type harmonyResult struct {
+    Targets map[string]dispatcher.ConfigTarget `json:"targets"`
+    Modes map[string]*harmonyMode              `json:"modes"`
+}
+
+// harmonyImport converts a harmony configuration, and returns warnings about
+// what could not be converted
+func harmonyImport(hc *harmonyConfig) (*harmonyResult, []string) {
+    h := &harmonyImporter{
+        cfg: &harmonyResult{Targets: make(map[string]dispatcher.ConfigTarget), Modes: make(map[string]*harmonyMode)},
+        devices: make(map[string]*harmonyDevice),
+        names: make(map[string]string),
+        modules: make(map[string]string),
+        warned: make(map[string]bool),
+    }
+    for _, d := range hc.Device {
+        h.addDevice(d)
+    }
+    // Count the activities using each device, shared devices stay on when
+    // leaving an activity
+    used := make(map[string]int)
+    for _, a := range hc.Activity {
+        for id, f := range a.Fixit {
+            if strings.EqualFold(f.Power, "on") {
+                used[id]++
+            }
+        }
+    }
+    for _, a := range hc.Activity {
+        h.addActivity(a, used)
+    }
+    return h.cfg, h.warnings
+}
+
+type harmonyImporter struct {
+    cfg *harmonyResult
+    devices map[string]*harmonyDevice
+    // target name and module by device id
+    names map[string]string
+    modules map[string]string
+    warnings []string
+    warned map[string]bool
+}
+
+func (h *harmonyImporter) warn(format string, a ...interface{}) {
+    w := fmt.Sprintf(format, a...)
+    if !h.warned[w] {
+        h.warned[w] = true
+        h.warnings = append(h.warnings, w)
+    }
+}
+
+// harmonyModule returns the claw module able to control a device, if any
+func harmonyModule(d *harmonyDevice) string {
+    manufacturer := strings.ToLower(d.Manufacturer)
+    devtype := strings.ToLower(d.DeviceTypeDisplayName)
+    switch {
+    case strings.Contains(manufacturer, "denon"):
+        return "denon"
+    case strings.Contains(manufacturer, "onkyo"), strings.Contains(manufacturer, "integra"):
+        return "onkyo"
+    case strings.Contains(manufacturer, "plex"), strings.Contains(strings.ToLower(d.Model), "plex"):
+        return "plex"
+    case strings.Contains(devtype, "television"), devtype == "tv":
+        return "cec"
+    case strings.Contains(devtype, "computer"):
+        return "linux"
+    }
+    return ""
+}
+
+// harmonyName turns a harmony label into a target or mode name
+func harmonyName(label string, sep string) string {
+    parts := strings.FieldsFunc(label, func(r rune) bool {
+        return !(((r >= 'a') && (r <= 'z')) || ((r >= 'A') && (r <= 'Z')) || ((r >= '0') && (r <= '9')))
+    })
+    return strings.Join(parts, sep)
+}
+
+func (h *harmonyImporter) addDevice(d *harmonyDevice) {
+    h.devices[d.ID] = d
+    name := harmonyName(d.Label, "")
+    if name == "" {
+        name = "Device" + d.ID
+    }
+    h.names[d.ID] = name
+    module := harmonyModule(d)
+    if module == "" {
+        h.warn("no module for device `%s` (%s %s), add a target named `%s`", d.Label, d.Manufacturer, d.Model, name)
+        return
+    }
+    h.modules[d.ID] = module
+    params := make(map[string]string)
+    switch module {
+    case "denon":
+        params["address"] = ""
+    case "onkyo":
+        params["host"] = ""
+    case "plex":
+        params["name"] = d.Label
+    case "cec":
+        params["device"] = "0"
+    }
+    for k := range params {
+        if params[k] == "" {
+            h.warn("target `%s` needs the `%s` parameter", name, k)
+        }
+    }
+    h.cfg.Targets[name] = dispatcher.ConfigTarget{Module: module, Params: params}
+}
+
+// action returns the claw action sending a harmony command to a device. A
+// command the module of the device does not have is left out, so the config
+// passes the check.
+func (h *harmonyImporter) action(id, command string) (string, bool) {
+    name, ok := h.names[id]
+    if !ok {
+        name = "Device" + id
+        h.warn("unknown device `%s`", id)
+    }
+    cmd := strings.ToLower(command)
+    if module, ok := h.modules[id]; ok {
+        if c, ok := harmonyModules[module][cmd]; ok {
+            return name + "::" + c, true
+        }
+        h.warn("module `%s` of target `%s` has no command for `%s`, left out", module, name, command)
+        return "", false
+    }
+    return name + "::" + cmd, true
+}
+
+// appendAction appends the action for a harmony command, if there is one
+func (h *harmonyImporter) appendAction(actions []string, id, command string) []string {
+    if a, ok := h.action(id, command); ok {
+        return append(actions, a)
+    }
+    return actions
+}
+
+func (h *harmonyImporter) addActivity(a *harmonyActivity, used map[string]int) {
+    name := strings.ToLower(harmonyName(a.Label, "_"))
+    if name == "" {
+        name = "activity" + a.ID
+    }
+    mode := &harmonyMode{Entry: []string{}, Exit: []string{}, Keys: make(map[string][]string)}
+    ids := make([]string, 0, len(a.Fixit))
+    for id := range a.Fixit {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    for _, id := range ids {
+        f := a.Fixit[id]
+        switch strings.ToLower(f.Power) {
+        case "on":
+            mode.Entry = h.appendAction(mode.Entry, id, "PowerOn")
+            if used[id] == 1 {
+                mode.Exit = h.appendAction(mode.Exit, id, "PowerOff")
+            }
+        case "off":
+            mode.Entry = h.appendAction(mode.Entry, id, "PowerOff")
+        }
+        if f.Input != "" {
+            mode.Entry = h.appendAction(mode.Entry, id, "Input" + harmonyName(f.Input, ""))
+        }
+    }
+    for _, g := range a.ControlGroup {
+        for _, f := range g.Function {
+            var act harmonyAction
+            if err := json.Unmarshal([]byte(f.Action), &act); err != nil {
+                h.warn("activity `%s`: invalid action for `%s`: %s", a.Label, f.Name, err)
+                continue
+            }
+            key := harmonyKeys[strings.ToLower(f.Name)]
+            if key == "" {
+                key = canonicalKey(strings.TrimPrefix(f.Name, "Number"))
+            }
+            if action, ok := h.action(act.DeviceID, act.Command); ok {
+                mode.Keys[key] = []string{action}
+            }
+        }
+    }
+    h.cfg.Modes[name] = mode
+}
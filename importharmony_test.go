@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+import "testing"
+import "io/ioutil"
+import "encoding/json"
+
+func Test_HarmonyImport(t *testing.T) {
+    data, err := ioutil.ReadFile("testdata/harmony.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var hc harmonyConfig
+    if err = json.Unmarshal(data, &hc); err != nil {
+        t.Fatal(err)
+    }
+    cfg, warnings := harmonyImport(&hc)
+
+    if tgt := cfg.Targets["OnkyoAVReceiver"]; tgt.Module != "onkyo" {
+        t.Errorf("expected an onkyo target, got %+v", tgt)
+    }
+    if tgt := cfg.Targets["SamsungTV"]; tgt.Module != "cec" {
+        t.Errorf("expected a cec target, got %+v", tgt)
+    }
+    tv := cfg.Modes["watch_tv"]
+    if tv == nil {
+        t.Fatalf("missing mode watch_tv: %+v", cfg.Modes)
+    }
+    // Commands the modules do not have are left out
+    want := "SamsungTV::poweron,OnkyoAVReceiver::power on"
+    if got := strings.Join(tv.Entry, ","); got != want {
+        t.Errorf("entry: expected '%s', got '%s'", want, got)
+    }
+    // The receiver is used by another activity, and stays on
+    if got := strings.Join(tv.Exit, ","); got != "SamsungTV::standby" {
+        t.Errorf("exit: expected 'SamsungTV::standby', got '%s'", got)
+    }
+    keys := map[string]string{
+        "KEY_VOLUMEUP": "OnkyoAVReceiver::volumeup",
+        "KEY_MUTE": "OnkyoAVReceiver::mute toggle",
+        "KEY_UP": "",
+        "KEY_1": "",
+    }
+    for k, v := range keys {
+        if got := strings.Join(tv.Keys[k], ","); got != v {
+            t.Errorf("%s: expected '%s', got '%s'", k, v, got)
+        }
+    }
+    if got := strings.Join(cfg.Modes["plex"].Keys["KEY_OK"], ","); got != "PlexBox::smartselect" {
+        t.Errorf("KEY_OK: expected 'PlexBox::smartselect', got '%s'", got)
+    }
+    if len(warnings) == 0 {
+        t.Errorf("expected warnings about unsupported commands")
+    }
+}
@@ -0,0 +1,123 @@
+package main
+
+import "os"
+import "fmt"
+import "flag"
+import "sort"
+import "strings"
+import "encoding/json"
+
+import "github.com/cnf/go-claw/dispatcher"
+import "github.com/cnf/go-claw/listeners/lircsocket"
+
+// importLirc prints a starter config section for a remote in a lircd.conf
+// file: a translation table for its buttons, and a default mode listing
+// every button
+func importLirc(args []string) int {
+    fs := flag.NewFlagSet("import-lirc", flag.ExitOnError)
+    remote := fs.String("remote", "", "the remote to import, needed if the file has more than one")
+    listener := fs.String("listener", "", "only translate keys from this listener")
+    fs.Usage = func() {
+        fmt.Fprintf(os.Stderr, "Usage: %s import-lirc [options] lircd.conf\n", os.Args[0])
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fs.Usage()
+        return 2
+    }
+    remotes, err := lircsocket.ReadConfig(fs.Arg(0))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Could not read %s: %s\n", fs.Arg(0), err)
+        return 1
+    }
+    var names []string
+    var r *lircsocket.Remote
+    for _, v := range remotes {
+        names = append(names, v.Name)
+        if (v.Name == *remote) || ((*remote == "") && (len(remotes) == 1)) {
+            r = v
+        }
+    }
+    if r == nil {
+        fmt.Fprintf(os.Stderr, "Pick a remote with -remote: %s\n", strings.Join(names, ", "))
+        return 1
+    }
+    data, err := json.MarshalIndent(lircConfig(r, *listener), "", "  ")
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err)
+        return 1
+    }
+    fmt.Printf("%s\n", data)
+    return 0
+}
+
+// lircConfig returns the config section for a remote
+func lircConfig(r *lircsocket.Remote, listener string) interface{} {
+    table := make(map[string]string)
+    keys := make(map[string][]string)
+    buttons := r.Buttons()
+    sort.Strings(buttons)
+    for _, b := range buttons {
+        key := canonicalKey(b)
+        // Buttons like "vol_up" and "VOL+" must not end up as the same key
+        for i := 2; keys[key] != nil; i++ {
+            key = fmt.Sprintf("%s_%d", canonicalKey(b), i)
+        }
+        if key != b {
+            table[b] = key
+        }
+        keys[key] = []string{}
+    }
+    var cfg struct {
+        Translate dispatcher.Translations `json:"translate,omitempty"`
+        Modes map[string]map[string]interface{} `json:"modes"`
+    }
+    if len(table) > 0 {
+        name := r.Name
+        if listener != "" {
+            name = listener + ":" + name
+        }
+        cfg.Translate = dispatcher.Translations{name: table}
+    }
+    cfg.Modes = map[string]map[string]interface{}{"default": {"keys": keys}}
+    return cfg
+}
+
+// keyAliases are the linux input layer names of common lircd buttons
+var keyAliases = map[string]string{
+    "KEY_VOL_UP": "KEY_VOLUMEUP",
+    "KEY_VOL_DOWN": "KEY_VOLUMEDOWN",
+    "KEY_CH_UP": "KEY_CHANNELUP",
+    "KEY_CH_DOWN": "KEY_CHANNELDOWN",
+    "KEY_PLAY_PAUSE": "KEY_PLAYPAUSE",
+}
+
+// canonicalKey turns a lircd button name into a key name like the ones of
+// the linux input layer, "ok" becomes "KEY_OK" and "vol+" "KEY_VOLUMEUP"
+func canonicalKey(name string) string {
+    // Only a trailing + or - means up or down, like in "vol+"
+    name = strings.TrimSpace(name)
+    if strings.HasSuffix(name, "+") {
+        name = strings.TrimSuffix(name, "+") + "_UP"
+    } else if strings.HasSuffix(name, "-") {
+        name = strings.TrimSuffix(name, "-") + "_DOWN"
+    }
+    key := strings.Map(func(r rune) rune {
+        if ((r >= 'A') && (r <= 'Z')) || ((r >= '0') && (r <= '9')) {
+            return r
+        }
+        return '_'
+    }, strings.ToUpper(name))
+    for strings.Contains(key, "__") {
+        key = strings.Replace(key, "__", "_", -1)
+    }
+    key = strings.Trim(key, "_")
+    if !strings.HasPrefix(key, "KEY_") && !strings.HasPrefix(key, "BTN_") {
+        key = "KEY_" + key
+    }
+    if alias, ok := keyAliases[key]; ok {
+        return alias
+    }
+    return key
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+import "io/ioutil"
+import "encoding/json"
+
+import "github.com/cnf/go-claw/listeners/lircsocket"
+
+func Test_CanonicalKey(t *testing.T) {
+    tests := map[string]string{
+        "ok": "KEY_OK",
+        "KEY_POWER": "KEY_POWER",
+        "BTN_LEFT": "BTN_LEFT",
+        "vol+": "KEY_VOLUMEUP",
+        "Vol-": "KEY_VOLUMEDOWN",
+        "VOL+": "KEY_VOLUMEUP",
+        "ch -": "KEY_CHANNELDOWN",
+        "CH-UP": "KEY_CHANNELUP",
+        "PIP-SWAP": "KEY_PIP_SWAP",
+        "A+B": "KEY_A_B",
+        "ch_up": "KEY_CHANNELUP",
+        "play/pause": "KEY_PLAYPAUSE",
+        "Fast Forward": "KEY_FAST_FORWARD",
+        "1": "KEY_1",
+    }
+    for name, expect := range tests {
+        if got := canonicalKey(name); got != expect {
+            t.Errorf("%s: expected '%s', got '%s'", name, expect, got)
+        }
+    }
+}
+
+func Test_LircImport(t *testing.T) {
+    remotes, err := lircsocket.ReadConfig("testdata/lircd.conf")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var remote *lircsocket.Remote
+    for _, r := range remotes {
+        if r.Name == "Apple_A1156" {
+            remote = r
+        }
+    }
+    if remote == nil {
+        t.Fatalf("remote Apple_A1156 not found in %v", remotes)
+    }
+    data, err := json.MarshalIndent(lircConfig(remote, "lirc"), "", "  ")
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect, err := ioutil.ReadFile("testdata/lircd.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) + "\n" != string(expect) {
+        t.Errorf("expected the config in testdata/lircd.json, got:\n%s", data)
+    }
+}
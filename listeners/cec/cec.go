@@ -0,0 +1,163 @@
+package cec
+
+import "fmt"
+import "time"
+import "regexp"
+import "strconv"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/tools"
+import "github.com/cnf/go-claw/clog"
+
+// CecListener turns the key presses cec-client reports into RemoteCommands
+type CecListener struct {
+    Command string
+    Port string
+    Source string
+
+    lastcode int
+    repeat int
+    // client is the cec-client to use instead of the shared one
+    client *tools.CecClient
+}
+
+var pressedRe = regexp.MustCompile(`key pressed: (.*) \(([0-9a-f]+)\)`)
+var releasedRe = regexp.MustCompile(`key released: (.*) \(([0-9a-f]+)\)`)
+
+// keyNames maps CEC user control codes to the key names lircd uses
+var keyNames = map[int]string{
+    0x00: "KEY_OK",
+    0x01: "KEY_UP",
+    0x02: "KEY_DOWN",
+    0x03: "KEY_LEFT",
+    0x04: "KEY_RIGHT",
+    0x09: "KEY_MENU",
+    0x0a: "KEY_SETUP",
+    0x0b: "KEY_CONTEXT_MENU",
+    0x0d: "KEY_EXIT",
+    0x20: "KEY_0",
+    0x21: "KEY_1",
+    0x22: "KEY_2",
+    0x23: "KEY_3",
+    0x24: "KEY_4",
+    0x25: "KEY_5",
+    0x26: "KEY_6",
+    0x27: "KEY_7",
+    0x28: "KEY_8",
+    0x29: "KEY_9",
+    0x2b: "KEY_ENTER",
+    0x30: "KEY_CHANNELUP",
+    0x31: "KEY_CHANNELDOWN",
+    0x32: "KEY_LAST",
+    0x35: "KEY_INFO",
+    0x40: "KEY_POWER",
+    0x41: "KEY_VOLUMEUP",
+    0x42: "KEY_VOLUMEDOWN",
+    0x43: "KEY_MUTE",
+    0x44: "KEY_PLAY",
+    0x45: "KEY_STOP",
+    0x46: "KEY_PAUSE",
+    0x47: "KEY_RECORD",
+    0x48: "KEY_REWIND",
+    0x49: "KEY_FASTFORWARD",
+    0x4a: "KEY_EJECTCD",
+    0x4b: "KEY_NEXT",
+    0x4c: "KEY_PREVIOUS",
+    0x53: "KEY_EPG",
+    0x71: "KEY_BLUE",
+    0x72: "KEY_RED",
+    0x73: "KEY_GREEN",
+    0x74: "KEY_YELLOW",
+}
+
+// Register registers the cec listener module
+func Register() {
+    listeners.RegisterListener("cec", Create)
+}
+
+// Create creates a new cec listener. The optional 'command' parameter is
+// the cec-client binary to run, 'port' the adapter to use.
+func Create(params map[string]string) (l listeners.Listener, ok bool) {
+    cl := &CecListener{Command: "cec-client", Source: "cec", lastcode: -1}
+    if val, ok := params["command"]; ok {
+        cl.Command = val
+    }
+    cl.Port = params["port"]
+    if val, ok := params["source"]; ok {
+        cl.Source = val
+    }
+    return cl, true
+}
+
+// RunListener reads the cec-client output and sends key presses to the
+// command stream
+func (l *CecListener) RunListener(cs *listeners.CommandStream) {
+    lines, err := l.cecClient().Lines()
+    if err != nil {
+        clog.Warn("cec: could not start %s: %s", l.Command, err.Error())
+        cs.Fatal = true
+        cs.ChErr <- err
+        return
+    }
+    for {
+        for line := range lines {
+            if rc := l.parseLine(line); rc != nil {
+                cs.Ch <- rc
+            }
+        }
+        // cec-client exited, restart it
+        time.Sleep(3000 * time.Millisecond)
+        if lines, err = l.cecClient().Lines(); err != nil {
+            clog.Error("cec: could not restart %s: %s", l.Command, err.Error())
+            lines = make(chan string)
+            close(lines)
+        }
+    }
+}
+
+// cecClient returns the cec-client of the listener, the shared one unless
+// a test set its own
+func (l *CecListener) cecClient() *tools.CecClient {
+    if l.client != nil {
+        return l.client
+    }
+    return tools.GetCecClient(l.Command, l.Port)
+}
+
+// parseLine returns a RemoteCommand for key press lines, nil otherwise
+func (l *CecListener) parseLine(line string) *listeners.RemoteCommand {
+    event := listeners.KeyPress
+    m := pressedRe.FindStringSubmatch(line)
+    if m == nil {
+        if m = releasedRe.FindStringSubmatch(line); m == nil {
+            return nil
+        }
+        event = listeners.KeyRelease
+    }
+    code, err := strconv.ParseInt(m[2], 16, 0)
+    if err != nil {
+        return nil
+    }
+    if event == listeners.KeyRelease {
+        l.lastcode = -1
+    } else if int(code) == l.lastcode {
+        // cec keeps reporting the key as pressed while it is held
+        event = listeners.KeyHold
+        l.repeat++
+    } else {
+        l.repeat = 0
+        l.lastcode = int(code)
+    }
+    key, ok := keyNames[int(code)]
+    if !ok {
+        key = fmt.Sprintf("CEC_%02X", code)
+    }
+    return &listeners.RemoteCommand{
+        Code: fmt.Sprintf("%02x", code),
+        Repeat: l.repeat,
+        Key: key,
+        Source: l.Source,
+        Time: time.Now(),
+        Event: event,
+    }
+}
@@ -0,0 +1,37 @@
+package cec
+
+import "testing"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/tools"
+
+func Test_Listener(t *testing.T) {
+    l, _ := Create(map[string]string{"command": "testdata/cec-client"})
+    l.(*CecListener).client = tools.NewCecClient("testdata/cec-client", "")
+    cs := listeners.NewCommandStream()
+    go l.RunListener(cs)
+
+    var expect = []struct {
+        key string
+        repeat int
+        event listeners.KeyEvent
+    }{
+        {"KEY_OK", 0, listeners.KeyPress},
+        {"KEY_OK", 0, listeners.KeyRelease},
+        {"KEY_VOLUMEUP", 0, listeners.KeyPress},
+        {"KEY_VOLUMEUP", 1, listeners.KeyHold},
+        {"KEY_VOLUMEUP", 2, listeners.KeyHold},
+        {"KEY_VOLUMEUP", 2, listeners.KeyRelease},
+        {"KEY_RED", 0, listeners.KeyPress},
+    }
+    for _, e := range expect {
+        select {
+        case rc := <- cs.Ch:
+            if (rc.Key != e.key) || (rc.Repeat != e.repeat) || (rc.Event != e.event) || (rc.Source != "cec") {
+                t.Errorf("expected %s %s repeat %d, got %v", e.key, e.event, e.repeat, rc)
+            }
+        case err := <- cs.ChErr:
+            t.Fatalf("listener failed: %s", err.Error())
+        }
+    }
+}
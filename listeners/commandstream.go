@@ -1,5 +1,8 @@
 package listeners
 
+import "sync"
+import "time"
+
 import "github.com/cnf/go-claw/clog"
 
 type CommandStream struct {
@@ -8,14 +11,19 @@ type CommandStream struct {
     Fatal bool
     count int
     err error
+    synth *keySynth
+    named map[string]*namedListener
+    mu sync.Mutex
 }
 
 func NewCommandStream() *CommandStream {
-    cs := &CommandStream{ Ch: make(chan *RemoteCommand), ChErr: make(chan error), count: 0, err: nil}
+    cs := &CommandStream{ Ch: make(chan *RemoteCommand), ChErr: make(chan error), count: 0, err: nil, synth: newKeySynth(), named: make(map[string]*namedListener)}
     return cs
 }
 
 func (cs *CommandStream) Count() int {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
     return cs.count
 }
 
@@ -29,10 +37,114 @@ func (cs *CommandStream) Close() {
 
 func (cs *CommandStream) AddListener(l RemoteListener) bool {
     go l.RunListener(cs)
+    cs.mu.Lock()
     cs.count++
+    cs.mu.Unlock()
+    return true
+}
+
+// AddNamedListener starts a listener and sets the Listener field of every
+// command it sends to name
+func (cs *CommandStream) AddNamedListener(name string, l RemoteListener) bool {
+    n := &namedListener{l: l, cs: &CommandStream{Ch: make(chan *RemoteCommand), ChErr: make(chan error)}, stop: make(chan bool)}
+    cs.mu.Lock()
+    cs.named[name] = n
+    cs.mu.Unlock()
+    go func() {
+        for {
+            select {
+            case <- n.stop:
+                return
+            case rc := <- n.cs.Ch:
+                if cs.removed(n) {
+                    continue
+                }
+                rc.Listener = name
+                select {
+                case cs.Ch <- rc:
+                case <- n.stop:
+                    return
+                }
+            case err := <- n.cs.ChErr:
+                if cs.removed(n) {
+                    continue
+                }
+                cs.mu.Lock()
+                n.failed = n.cs.Fatal
+                cs.mu.Unlock()
+                select {
+                case cs.ChErr <- &listenerError{err, n.cs.Fatal}:
+                case <- n.stop:
+                    return
+                }
+            }
+        }
+    }()
+    return cs.AddListener(n)
+}
+
+// RemoveListener stops a listener added with AddNamedListener, and the
+// forwarding of its commands. Listeners that can not be stopped keep
+// running, but their commands are no longer read.
+func (cs *CommandStream) RemoveListener(name string) bool {
+    cs.mu.Lock()
+    n, ok := cs.named[name]
+    if ok {
+        delete(cs.named, name)
+        if !n.failed {
+            cs.count--
+        }
+    }
+    cs.mu.Unlock()
+    if !ok {
+        return false
+    }
+    close(n.stop)
+    if s, ok := n.l.(Stopper); ok {
+        if err := s.StopListener(); err != nil {
+            clog.Warn("Could not stop listener `%s`: %s", name, err.Error())
+        }
+    } else {
+        clog.Warn("Listener `%s` can not be stopped, it keeps running until claw restarts", name)
+    }
     return true
 }
 
+// removed returns true if a named listener was removed
+func (cs *CommandStream) removed(n *namedListener) bool {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    for _, v := range cs.named {
+        if v == n {
+            return false
+        }
+    }
+    return true
+}
+
+// namedListener runs a listener on its own CommandStream, stop is closed
+// when it is removed
+type namedListener struct {
+    l RemoteListener
+    cs *CommandStream
+    failed bool
+    stop chan bool
+}
+
+// listenerError is an error of a named listener, fatal is its Fatal flag
+type listenerError struct {
+    err error
+    fatal bool
+}
+
+func (e *listenerError) Error() string {
+    return e.err.Error()
+}
+
+func (n *namedListener) RunListener(cs *CommandStream) {
+    n.l.RunListener(n.cs)
+}
+
 func (cs *CommandStream) HasError() bool {
     return (cs.err != nil)
 }
@@ -47,27 +159,48 @@ func (cs *CommandStream) ClearError() {
 }
 
 func (cs *CommandStream) Next(cmd *RemoteCommand) bool {
-    if (cs.count <= 0) {
+    if (cs.Count() <= 0) {
         clog.Warn("No listeners, shutting down")
         return false
     }
     for {
+        // Deliver synthesized press, hold and release events first
+        if rc := cs.synth.pop(); rc != nil {
+            *cmd = *rc
+            return true
+        }
+        wait, waiting := cs.synth.expire(time.Now())
+        if len(cs.synth.pending) > 0 {
+            continue
+        }
+        var expired <-chan time.Time
+        if waiting {
+            expired = time.After(wait)
+        }
         select {
+        case <- expired:
+            continue
         case tmp, ok := <- cs.Ch:
             if (!ok) {
                 clog.Warn("Error encountered while reading the next command")
                 return false
             }
-            *cmd = *tmp
-            return true
+            cs.synth.add(tmp, time.Now())
+            continue
         case err := <- cs.ChErr:
+            fatal := cs.Fatal
+            if lerr, ok := err.(*listenerError); ok {
+                err, fatal = lerr.err, lerr.fatal
+            }
             cs.err = err
-            if (cs.Fatal) {
+            if (fatal) {
                 clog.Error("Fatal error, listener shutting down")
+                cs.mu.Lock()
                 cs.count--
+                cs.mu.Unlock()
             }
             clog.Error("Listener exited and reported an error: %v", err)
-            if (cs.count > 0) {
+            if (cs.Count() > 0) {
                 continue
             }
             clog.Warn("Nothing to listen to!")
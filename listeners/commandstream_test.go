@@ -0,0 +1,64 @@
+package listeners
+
+import "time"
+import "errors"
+import "testing"
+
+// failingListener sends a key, and fails with a fatal error when fatal is
+// set
+type failingListener struct {
+    fatal bool
+}
+
+func (f *failingListener) RunListener(cs *CommandStream) {
+    cs.Ch <- &RemoteCommand{Key: "KEY_OK", Event: KeyPress}
+    cs.Fatal = f.fatal
+    cs.ChErr <- errors.New("failed")
+}
+
+// blockedListener sends a key, and closes sent once it was taken
+type blockedListener struct {
+    sent chan bool
+}
+
+func (b *blockedListener) RunListener(cs *CommandStream) {
+    cs.Ch <- &RemoteCommand{Key: "KEY_OK", Event: KeyPress}
+    close(b.sent)
+}
+
+func Test_NamedListeners(t *testing.T) {
+    cs := NewCommandStream()
+    cs.AddNamedListener("first", &failingListener{fatal: true})
+    var rc RemoteCommand
+    if !cs.Next(&rc) || (rc.Listener != "first") {
+        t.Fatalf("expected a key from the first listener, got %v", rc)
+    }
+    // The fatal error stops the only listener
+    if cs.Next(&rc) || (cs.Count() != 0) {
+        t.Errorf("expected the stream to stop after a fatal error")
+    }
+    if cs.Fatal || (cs.Error() == nil) {
+        t.Errorf("expected the error of the listener, without the fatal flag on the stream")
+    }
+
+    cs.AddNamedListener("second", &failingListener{})
+    if !cs.RemoveListener("second") || cs.RemoveListener("second") {
+        t.Errorf("expected to remove the listener once")
+    }
+    if cs.Count() != 0 {
+        t.Errorf("expected no listeners, got %d", cs.Count())
+    }
+
+    // A removed listener that can not be stopped no longer holds up its
+    // forwarding of a key nobody reads
+    b := &blockedListener{sent: make(chan bool)}
+    cs.AddNamedListener("third", b)
+    <- b.sent
+    time.Sleep(20 * time.Millisecond)
+    cs.RemoveListener("third")
+    select {
+    case rc := <- cs.Ch:
+        t.Errorf("expected no key from a removed listener, got %v", rc)
+    case <- time.After(50 * time.Millisecond):
+    }
+}
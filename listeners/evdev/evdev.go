@@ -0,0 +1,266 @@
+package evdev
+
+import "os"
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "errors"
+import "strings"
+import "strconv"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/binary"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/clog"
+
+// Event types and codes we are interested in, from linux/input.h
+const (
+    evKey = 0x01
+    evMsc = 0x04
+    mscScan = 0x04
+)
+
+// Key values as reported in an EV_KEY event
+const (
+    keyRelease = 0
+    keyPress = 1
+    keyRepeat = 2
+)
+
+// EvdevListener reads key events from a linux input event device
+type EvdevListener struct {
+    Path string
+    Name string
+    Phys string
+    Grab bool
+    Source string
+
+    dev *os.File
+    evsize int
+    scancode int32
+    lastcode uint16
+    repeat int
+    stopped bool
+    mu sync.Mutex
+}
+
+type inputEvent struct {
+    Type uint16
+    Code uint16
+    Value int32
+}
+
+// Register registers the evdev listener module
+func Register() {
+    listeners.RegisterListener("evdev", Create)
+}
+
+// Create creates a new evdev listener. Either the 'device' parameter, or
+// the 'name' and/or 'phys' parameters to look the device up must be given.
+func Create(params map[string]string) (l listeners.Listener, ok bool) {
+    el := &EvdevListener{}
+    el.Path = params["device"]
+    el.Name = params["name"]
+    el.Phys = params["phys"]
+    if (el.Path == "") && (el.Name == "") && (el.Phys == "") {
+        clog.Warn("evdev: need a 'device', 'name' or 'phys' parameter")
+        return nil, false
+    }
+    if val, ok := params["grab"]; ok {
+        grab, err := strconv.ParseBool(val)
+        if err != nil {
+            clog.Warn("evdev: invalid 'grab' parameter: %s", val)
+            return nil, false
+        }
+        el.Grab = grab
+    }
+    el.Source = params["source"]
+    // struct input_event starts with a struct timeval, which is two longs
+    el.evsize = 2*(strconv.IntSize/8) + 8
+    return el, true
+}
+
+// findDevice looks up the event device matching the configured name and phys
+func (l *EvdevListener) findDevice() (string, error) {
+    devs, err := filepath.Glob("/sys/class/input/event*")
+    if err != nil {
+        return "", err
+    }
+    for _, d := range devs {
+        if (l.Name != "") && (readSysfs(filepath.Join(d, "device/name")) != l.Name) {
+            continue
+        }
+        if (l.Phys != "") && (readSysfs(filepath.Join(d, "device/phys")) != l.Phys) {
+            continue
+        }
+        return filepath.Join("/dev/input", filepath.Base(d)), nil
+    }
+    return "", fmt.Errorf("no input device found with name '%s' and phys '%s'", l.Name, l.Phys)
+}
+
+func readSysfs(path string) string {
+    b, err := ioutil.ReadFile(path)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(b))
+}
+
+func (l *EvdevListener) setup() error {
+    path := l.Path
+    if path == "" {
+        var err error
+        if path, err = l.findDevice(); err != nil {
+            return err
+        }
+    }
+    clog.Debug("evdev: opening device: %s", path)
+    dev, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    if l.Grab {
+        if err := grabDevice(dev); err != nil {
+            dev.Close()
+            return fmt.Errorf("could not grab %s: %s", path, err.Error())
+        }
+    }
+    if l.Source == "" {
+        l.Source = readSysfs(filepath.Join("/sys/class/input", filepath.Base(path), "device/name"))
+        if l.Source == "" {
+            l.Source = filepath.Base(path)
+        }
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.stopped {
+        dev.Close()
+        return errStopped
+    }
+    l.dev = dev
+    return nil
+}
+
+var errStopped = errors.New("listener stopped")
+
+func (l *EvdevListener) isStopped() bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.stopped
+}
+
+// StopListener closes the device, releasing the grab
+func (l *EvdevListener) StopListener() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.stopped = true
+    if l.dev != nil {
+        return l.dev.Close()
+    }
+    return nil
+}
+
+// RunListener reads events from the device and sends them to the command stream
+func (l *EvdevListener) RunListener(cs *listeners.CommandStream) {
+    if err := l.setup(); err == errStopped {
+        return
+    } else if err != nil {
+        clog.Warn("evdev: device setup failed: %s", err.Error())
+        cs.Fatal = true
+        cs.ChErr <- err
+        return
+    }
+    for {
+        ev, err := l.readEvent(l.dev)
+        if err != nil {
+            if l.isStopped() {
+                return
+            }
+            // Device unplugged or bluetooth remote gone to sleep, reopen
+            clog.Error("evdev: could not read event: %s", err.Error())
+            l.dev.Close()
+            for {
+                time.Sleep(3000 * time.Millisecond)
+                if err := l.setup(); err == nil {
+                    break
+                } else if err == errStopped {
+                    return
+                }
+            }
+            continue
+        }
+        if rc := l.handleEvent(ev); rc != nil {
+            cs.Ch <- rc
+        }
+    }
+}
+
+func (l *EvdevListener) readEvent(r io.Reader) (*inputEvent, error) {
+    buf := make([]byte, l.evsize)
+    if _, err := io.ReadFull(r, buf); err != nil {
+        return nil, err
+    }
+    if len(buf) < 8 {
+        return nil, errors.New("input event too short")
+    }
+    // Skip the timestamp, use our own clock for the key timeout
+    data := buf[len(buf)-8:]
+    ev := &inputEvent{
+        Type: binary.LittleEndian.Uint16(data[0:2]),
+        Code: binary.LittleEndian.Uint16(data[2:4]),
+        Value: int32(binary.LittleEndian.Uint32(data[4:8])),
+    }
+    return ev, nil
+}
+
+// handleEvent turns a key event into a RemoteCommand, or returns nil if the
+// event should not be passed on
+func (l *EvdevListener) handleEvent(ev *inputEvent) *listeners.RemoteCommand {
+    if (ev.Type == evMsc) && (ev.Code == mscScan) {
+        // The scancode precedes the key event it belongs to
+        l.scancode = ev.Value
+        return nil
+    }
+    if ev.Type != evKey {
+        return nil
+    }
+    event := listeners.KeyPress
+    switch ev.Value {
+    case keyPress:
+        l.repeat = 0
+    case keyRepeat:
+        event = listeners.KeyHold
+        if ev.Code == l.lastcode {
+            l.repeat++
+        } else {
+            l.repeat = 0
+        }
+    case keyRelease:
+        event = listeners.KeyRelease
+    default:
+        return nil
+    }
+    l.lastcode = ev.Code
+
+    code := int64(ev.Code)
+    if l.scancode != 0 {
+        code = int64(uint32(l.scancode))
+    }
+    if event == listeners.KeyRelease {
+        l.scancode = 0
+    }
+    key, ok := keyNames[ev.Code]
+    if !ok {
+        key = fmt.Sprintf("EV_KEY_%d", ev.Code)
+    }
+    return &listeners.RemoteCommand{
+        Code: fmt.Sprintf("%016x", code),
+        Repeat: l.repeat,
+        Key: key,
+        Source: l.Source,
+        Time: time.Now(),
+        Event: event,
+    }
+}
@@ -0,0 +1,66 @@
+package evdev
+
+import "testing"
+import "bytes"
+import "strconv"
+import "encoding/binary"
+
+import "github.com/cnf/go-claw/listeners"
+
+func writeEvent(buf *bytes.Buffer, etype, code uint16, value int32) {
+    buf.Write(make([]byte, 2*(strconv.IntSize/8)))
+    binary.Write(buf, binary.LittleEndian, etype)
+    binary.Write(buf, binary.LittleEndian, code)
+    binary.Write(buf, binary.LittleEndian, value)
+}
+
+func Test_Events(t *testing.T) {
+    l, ok := Create(map[string]string{"device": "/dev/null", "source": "test"})
+    if !ok {
+        t.Fatal("could not create evdev listener")
+    }
+    el := l.(*EvdevListener)
+
+    buf := new(bytes.Buffer)
+    writeEvent(buf, evMsc, mscScan, 0x70028)
+    writeEvent(buf, evKey, 0x160, keyPress)
+    writeEvent(buf, 0, 0, 0)
+    writeEvent(buf, evKey, 0x160, keyRepeat)
+    writeEvent(buf, evKey, 0x160, keyRepeat)
+    writeEvent(buf, evKey, 0x160, keyRelease)
+    writeEvent(buf, evKey, 0x073, keyPress)
+
+    var expect = []struct {
+        key string
+        code string
+        repeat int
+        event listeners.KeyEvent
+    }{
+        {"KEY_OK", "0000000000070028", 0, listeners.KeyPress},
+        {"KEY_OK", "0000000000070028", 1, listeners.KeyHold},
+        {"KEY_OK", "0000000000070028", 2, listeners.KeyHold},
+        {"KEY_OK", "0000000000070028", 2, listeners.KeyRelease},
+        {"KEY_VOLUMEUP", "0000000000000073", 0, listeners.KeyPress},
+    }
+    var i int
+    for {
+        ev, err := el.readEvent(buf)
+        if err != nil {
+            break
+        }
+        rc := el.handleEvent(ev)
+        if rc == nil {
+            continue
+        }
+        if i >= len(expect) {
+            t.Fatalf("unexpected command: %v", rc)
+        }
+        if (rc.Key != expect[i].key) || (rc.Code != expect[i].code) || (rc.Repeat != expect[i].repeat) || (rc.Event != expect[i].event) || (rc.Source != "test") {
+            t.Errorf("expected %v, got %v", expect[i], rc)
+        }
+        i++
+    }
+    if i != len(expect) {
+        t.Errorf("expected %d commands, got %d", len(expect), i)
+    }
+}
@@ -0,0 +1,19 @@
+// +build linux
+
+package evdev
+
+import "os"
+import "syscall"
+
+// EVIOCGRAB, _IOW('E', 0x90, int)
+const eviocgrab = 0x40044590
+
+// grabDevice takes exclusive access of the device, so no other process
+// receives the key events
+func grabDevice(dev *os.File) error {
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dev.Fd(), eviocgrab, 1)
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package evdev
+
+import "os"
+import "errors"
+
+func grabDevice(dev *os.File) error {
+    return errors.New("grabbing input devices is only supported on linux")
+}
@@ -0,0 +1,118 @@
+package httplistener
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "strings"
+import "strconv"
+import "net/http"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/clog"
+
+// HTTPListener accepts key presses over HTTP:
+//   POST /keys/<KEY>[?repeat=<n>&source=<name>]
+type HTTPListener struct {
+    Address string
+    Source string
+
+    cs *listeners.CommandStream
+    ln net.Listener
+    stopped bool
+    // stop is closed when the listener is stopped
+    stop chan bool
+    mu sync.Mutex
+}
+
+// Register registers the http listener module
+func Register() {
+    listeners.RegisterListener("http", Create)
+}
+
+// Create creates a new http listener, listening on the 'address' parameter
+func Create(params map[string]string) (l listeners.Listener, ok bool) {
+    hl := &HTTPListener{Address: ":8081", Source: "http", stop: make(chan bool)}
+    if val, ok := params["address"]; ok {
+        hl.Address = val
+    }
+    if val, ok := params["source"]; ok {
+        hl.Source = val
+    }
+    return hl, true
+}
+
+// RunListener starts the http server
+func (l *HTTPListener) RunListener(cs *listeners.CommandStream) {
+    l.cs = cs
+    mux := http.NewServeMux()
+    mux.Handle("/keys/", l)
+    clog.Info("http: listening for keys on %s", l.Address)
+    ln, err := net.Listen("tcp", l.Address)
+    if err == nil {
+        l.mu.Lock()
+        l.ln = ln
+        if l.stopped {
+            ln.Close()
+        }
+        l.mu.Unlock()
+        err = http.Serve(ln, mux)
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.stopped {
+        clog.Info("http: server on %s stopped", l.Address)
+        return
+    }
+    clog.Warn("http: server on %s stopped: %s", l.Address, err.Error())
+    cs.Fatal = true
+    cs.ChErr <- err
+}
+
+// StopListener stops the http server
+func (l *HTTPListener) StopListener() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if !l.stopped {
+        close(l.stop)
+    }
+    l.stopped = true
+    if l.ln != nil {
+        return l.ln.Close()
+    }
+    return nil
+}
+
+// ServeHTTP handles a single key press
+func (l *HTTPListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        w.Header().Set("Allow", "POST")
+        http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    key := strings.TrimPrefix(r.URL.Path, "/keys/")
+    if (key == "") || strings.Contains(key, "/") {
+        http.Error(w, "invalid key", http.StatusNotFound)
+        return
+    }
+    rc := &listeners.RemoteCommand{Code: key, Key: key, Source: l.Source, Time: time.Now()}
+    if val := r.FormValue("repeat"); val != "" {
+        rpt, err := strconv.Atoi(val)
+        if (err != nil) || (rpt < 0) {
+            http.Error(w, fmt.Sprintf("invalid repeat '%s'", val), http.StatusBadRequest)
+            return
+        }
+        rc.Repeat = rpt
+    }
+    if val := r.FormValue("source"); val != "" {
+        rc.Source = val
+    }
+    clog.Debug("http: key %s from %s", key, r.RemoteAddr)
+    select {
+    case l.cs.Ch <- rc:
+        w.WriteHeader(http.StatusNoContent)
+    case <- l.stop:
+        http.Error(w, "listener stopped", http.StatusServiceUnavailable)
+    case <- r.Context().Done():
+    }
+}
@@ -0,0 +1,58 @@
+package httplistener
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+
+import "github.com/cnf/go-claw/listeners"
+
+func Test_Keys(t *testing.T) {
+    l, _ := Create(map[string]string{"source": "phone"})
+    hl := l.(*HTTPListener)
+    hl.cs = listeners.NewCommandStream()
+    got := make(chan *listeners.RemoteCommand, 1)
+    go func() {
+        for rc := range hl.cs.Ch {
+            got <- rc
+        }
+    }()
+
+    var tests = []struct {
+        method string
+        url string
+        status int
+        key string
+        repeat int
+        source string
+    }{
+        {"POST", "/keys/KEY_OK", http.StatusNoContent, "KEY_OK", 0, "phone"},
+        {"POST", "/keys/KEY_VOLUMEUP?repeat=3&source=kitchen", http.StatusNoContent, "KEY_VOLUMEUP", 3, "kitchen"},
+        {"GET", "/keys/KEY_OK", http.StatusMethodNotAllowed, "", 0, ""},
+        {"POST", "/keys/", http.StatusNotFound, "", 0, ""},
+        {"POST", "/keys/KEY_OK?repeat=x", http.StatusBadRequest, "", 0, ""},
+    }
+    for _, tc := range tests {
+        w := httptest.NewRecorder()
+        hl.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, nil))
+        if w.Code != tc.status {
+            t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.url, tc.status, w.Code)
+            continue
+        }
+        if tc.key == "" {
+            continue
+        }
+        rc := <- got
+        if (rc.Key != tc.key) || (rc.Repeat != tc.repeat) || (rc.Source != tc.source) {
+            t.Errorf("%s %s: unexpected command %v", tc.method, tc.url, rc)
+        }
+    }
+
+    // Nobody reads the keys of a stopped listener
+    hl.cs = listeners.NewCommandStream()
+    hl.StopListener()
+    w := httptest.NewRecorder()
+    hl.ServeHTTP(w, httptest.NewRequest("POST", "/keys/KEY_OK", nil))
+    if w.Code != http.StatusServiceUnavailable {
+        t.Errorf("expected status %d after stopping, got %d", http.StatusServiceUnavailable, w.Code)
+    }
+}
@@ -0,0 +1,131 @@
+package listeners
+
+import "time"
+
+// Release timeouts used when a listener does not report key releases itself
+const (
+    defaultReleaseTimeout = 300 * time.Millisecond
+    minReleaseTimeout = 100 * time.Millisecond
+    maxReleaseTimeout = 1000 * time.Millisecond
+)
+
+// heldKey is the key currently held down on a source
+type heldKey struct {
+    rc RemoteCommand
+    last time.Time
+    gap time.Duration
+}
+
+// keySynth turns repeat counts into press, hold and release events
+type keySynth struct {
+    held map[string]*heldKey
+    // explicit is set for sources whose listener reports key events itself
+    explicit map[string]bool
+    pending []*RemoteCommand
+}
+
+func newKeySynth() *keySynth {
+    return &keySynth{held: make(map[string]*heldKey), explicit: make(map[string]bool)}
+}
+
+func (s *keySynth) push(rc RemoteCommand, ev KeyEvent) {
+    rc.Event = ev
+    s.pending = append(s.pending, &rc)
+}
+
+func (s *keySynth) pop() *RemoteCommand {
+    if len(s.pending) == 0 {
+        return nil
+    }
+    rc := s.pending[0]
+    s.pending = s.pending[1:]
+    return rc
+}
+
+// add queues the events resulting from a command sent by a listener
+func (s *keySynth) add(rc *RemoteCommand, now time.Time) {
+    origin := rc.Origin()
+    h := s.held[origin]
+    if rc.Event != KeyUnknown {
+        // This listener knows about key events, and will report releases
+        s.explicit[origin] = true
+    }
+    if rc.Event == KeyRelease {
+        if (h != nil) && (h.rc.Key == rc.Key) {
+            s.release(origin, now)
+        }
+        return
+    }
+    ev := rc.Event
+    if ev == KeyUnknown {
+        if rc.Repeat == 0 {
+            ev = KeyPress
+        } else {
+            ev = KeyHold
+        }
+    }
+    if (h != nil) && ((h.rc.Key != rc.Key) || (ev == KeyPress)) {
+        // Another key, or the same key pressed again
+        s.release(origin, now)
+        h = nil
+    }
+    if h == nil {
+        // A hold without a press means we missed the first frame
+        s.held[origin] = &heldKey{rc: *rc, last: now}
+        s.push(*rc, KeyPress)
+        return
+    }
+    h.gap = now.Sub(h.last)
+    h.last = now
+    h.rc = *rc
+    s.push(*rc, KeyHold)
+}
+
+func (s *keySynth) release(source string, now time.Time) {
+    h := s.held[source]
+    delete(s.held, source)
+    rc := h.rc
+    rc.Time = now
+    s.push(rc, KeyRelease)
+}
+
+// timeout returns how long to wait for the next repeat before a held key
+// on a source is considered released
+func (s *keySynth) timeout(source string, h *heldKey) time.Duration {
+    if s.explicit[source] {
+        return 0
+    }
+    if h.gap == 0 {
+        return defaultReleaseTimeout
+    }
+    t := 3 * h.gap
+    if t < minReleaseTimeout {
+        t = minReleaseTimeout
+    } else if t > maxReleaseTimeout {
+        t = maxReleaseTimeout
+    }
+    return t
+}
+
+// expire queues releases for held keys that timed out, and returns how long
+// to wait for the next one to time out
+func (s *keySynth) expire(now time.Time) (time.Duration, bool) {
+    var next time.Duration
+    found := false
+    for src, h := range s.held {
+        t := s.timeout(src, h)
+        if t == 0 {
+            continue
+        }
+        left := h.last.Add(t).Sub(now)
+        if left <= 0 {
+            s.release(src, now)
+            continue
+        }
+        if !found || (left < next) {
+            next = left
+            found = true
+        }
+    }
+    return next, found
+}
@@ -0,0 +1,60 @@
+package listeners
+
+import "time"
+import "testing"
+
+func Test_KeySynth(t *testing.T) {
+    s := newKeySynth()
+    start := time.Now()
+    at := func(ms int) time.Time {
+        return start.Add(time.Duration(ms) * time.Millisecond)
+    }
+    var got []string
+    collect := func() {
+        for rc := s.pop(); rc != nil; rc = s.pop() {
+            got = append(got, rc.Source + ":" + rc.Key + ":" + rc.Event.String())
+        }
+    }
+
+    // A held key on lircd, repeating every 110ms
+    s.add(&RemoteCommand{Key: "KEY_VOLUMEUP", Source: "lirc", Repeat: 0}, at(0))
+    s.add(&RemoteCommand{Key: "KEY_VOLUMEUP", Source: "lirc", Repeat: 1}, at(110))
+    // Another source does not interfere
+    s.add(&RemoteCommand{Key: "KEY_OK", Source: "evdev", Event: KeyPress}, at(150))
+    s.add(&RemoteCommand{Key: "KEY_VOLUMEUP", Source: "lirc", Repeat: 2}, at(220))
+    collect()
+    if _, ok := s.expire(at(500)); !ok {
+        t.Errorf("expected a key waiting for release")
+    }
+    collect()
+    // 3 times the repeat gap passed
+    s.expire(at(560))
+    collect()
+    // Explicit releases never time out
+    s.expire(at(5000))
+    s.add(&RemoteCommand{Key: "KEY_OK", Source: "evdev", Event: KeyRelease}, at(5000))
+    // Another key releases the held one
+    s.add(&RemoteCommand{Key: "KEY_UP", Source: "lirc", Repeat: 0}, at(6000))
+    s.add(&RemoteCommand{Key: "KEY_DOWN", Source: "lirc", Repeat: 0}, at(6050))
+    collect()
+
+    expect := []string{
+        "lirc:KEY_VOLUMEUP:press",
+        "lirc:KEY_VOLUMEUP:hold",
+        "evdev:KEY_OK:press",
+        "lirc:KEY_VOLUMEUP:hold",
+        "lirc:KEY_VOLUMEUP:release",
+        "evdev:KEY_OK:release",
+        "lirc:KEY_UP:press",
+        "lirc:KEY_UP:release",
+        "lirc:KEY_DOWN:press",
+    }
+    if len(got) != len(expect) {
+        t.Fatalf("expected %v, got %v", expect, got)
+    }
+    for i := range expect {
+        if got[i] != expect[i] {
+            t.Errorf("event %d: expected %s, got %s", i, expect[i], got[i])
+        }
+    }
+}
@@ -0,0 +1,185 @@
+package lircdev
+
+import "fmt"
+
+// Sample is a single pulse or space, with its duration in microseconds
+type Sample struct {
+    Pulse bool
+    Duration uint32
+}
+
+// Result is a decoded infra red frame
+type Result struct {
+    Protocol string
+    Code uint32
+    Repeat int
+
+    toggle bool
+    // repeat is set for repeat frames which do not carry a code themselves
+    repeat bool
+}
+
+// String returns the scancode in the form used in RemoteCommand.Code
+func (r *Result) String() string {
+    return fmt.Sprintf("0x%x", r.Code)
+}
+
+// gapTimeout is the minimum space that ends a frame
+const gapTimeout = 10000
+
+// repeatTimeout is the maximum space between two frames of a held key
+const repeatTimeout = 150000
+
+// frameDecoder tries to decode a complete frame, without the trailing space
+type frameDecoder func(frame []Sample) (*Result, bool)
+
+var frameDecoders = []frameDecoder{
+    decodeNEC,
+    decodeSamsung,
+    decodeSony,
+    decodeRC6,
+    decodeRC5,
+}
+
+// Decoder collects samples into frames, and decodes them
+type Decoder struct {
+    frame []Sample
+    last *Result
+    since uint32
+    gap uint32
+}
+
+// NewDecoder creates a new infra red decoder
+func NewDecoder() *Decoder {
+    return &Decoder{since: repeatTimeout}
+}
+
+// Feed adds a sample to the current frame. A decoded frame is returned when
+// the sample ends the frame.
+func (d *Decoder) Feed(s Sample) *Result {
+    if !s.Pulse {
+        if len(d.frame) == 0 {
+            d.addSince(s.Duration)
+            return nil
+        }
+        if s.Duration >= gapTimeout {
+            r := d.Flush()
+            d.addSince(s.Duration)
+            return r
+        }
+    } else if len(d.frame) == 0 {
+        d.gap = d.since
+    }
+    d.frame = append(d.frame, s)
+    return nil
+}
+
+// Flush decodes the frame collected so far
+func (d *Decoder) Flush() *Result {
+    if len(d.frame) == 0 {
+        return nil
+    }
+    frame := d.frame
+    d.frame = nil
+    d.since = 0
+    for _, s := range frame {
+        d.addSince(s.Duration)
+    }
+    for _, dec := range frameDecoders {
+        r, ok := dec(frame)
+        if !ok {
+            continue
+        }
+        return d.repeat(r)
+    }
+    return nil
+}
+
+func (d *Decoder) addSince(dur uint32) {
+    if d.since < repeatTimeout {
+        d.since += dur
+    }
+}
+
+// repeat fills in the repeat count by comparing with the previous frame
+func (d *Decoder) repeat(r *Result) *Result {
+    held := (d.last != nil) && (d.gap < repeatTimeout)
+    if r.repeat {
+        if !held {
+            return nil
+        }
+        ret := *d.last
+        ret.Repeat++
+        d.last = &ret
+        return &ret
+    }
+    if held && (d.last.Protocol == r.Protocol) && (d.last.Code == r.Code) && (d.last.toggle == r.toggle) {
+        r.Repeat = d.last.Repeat + 1
+    }
+    d.last = r
+    ret := *r
+    return &ret
+}
+
+// within checks if a duration is within 30% of what is expected
+func within(dur, expect uint32) bool {
+    tol := expect * 3 / 10
+    return (dur + tol >= expect) && (dur <= expect + tol)
+}
+
+// decodePulseDistance decodes the data bits of a pulse distance encoded
+// frame, lsb first, after the header
+func decodePulseDistance(frame []Sample, bits int, pulse, zero, one uint32) (uint32, bool) {
+    if len(frame) != 2*bits + 1 {
+        return 0, false
+    }
+    var data uint32
+    for i := 0; i < bits; i++ {
+        if !within(frame[2*i].Duration, pulse) {
+            return 0, false
+        }
+        space := frame[2*i + 1].Duration
+        if within(space, one) {
+            data |= 1 << uint(i)
+        } else if !within(space, zero) {
+            return 0, false
+        }
+    }
+    if !within(frame[len(frame)-1].Duration, pulse) {
+        return 0, false
+    }
+    return data, true
+}
+
+// toUnits converts manchester encoded samples to one level per time unit
+func toUnits(frame []Sample, unit uint32, maxunits uint32) ([]bool, bool) {
+    var levels []bool
+    for _, s := range frame {
+        n := (s.Duration + unit/2) / unit
+        if (n == 0) || (n > maxunits) || !within(s.Duration, n*unit) {
+            return nil, false
+        }
+        for i := uint32(0); i < n; i++ {
+            levels = append(levels, s.Pulse)
+        }
+    }
+    return levels, true
+}
+
+// manchesterBits turns pairs of levels into bits, msb first. A bit is one
+// if its first half equals first.
+func manchesterBits(levels []bool, first bool) (uint32, int, bool) {
+    var data uint32
+    var n int
+    for i := 0; i + 1 < len(levels); i += 2 {
+        if levels[i] == levels[i+1] {
+            return 0, 0, false
+        }
+        data <<= 1
+        if levels[i] == first {
+            data |= 1
+        }
+        n++
+    }
+    return data, n, true
+}
@@ -0,0 +1,77 @@
+package lircdev
+
+import "os"
+import "testing"
+
+var fixtures = []struct {
+    file string
+    expect []Result
+}{
+    {"nec.mode2", []Result{
+        {Protocol: "nec", Code: 0x0408, Repeat: 0},
+        {Protocol: "nec", Code: 0x0408, Repeat: 1},
+        {Protocol: "nec", Code: 0x0408, Repeat: 2},
+        {Protocol: "nec", Code: 0x0409, Repeat: 0},
+        {Protocol: "necx", Code: 0x123456, Repeat: 0},
+    }},
+    {"samsung.mode2", []Result{
+        {Protocol: "samsung", Code: 0x0702, Repeat: 0},
+        {Protocol: "samsung", Code: 0x0702, Repeat: 1},
+    }},
+    {"sony.mode2", []Result{
+        {Protocol: "sony12", Code: 0x0115, Repeat: 0},
+        {Protocol: "sony12", Code: 0x0115, Repeat: 1},
+        {Protocol: "sony12", Code: 0x0115, Repeat: 2},
+        {Protocol: "sony20", Code: 0x3a1a39, Repeat: 0},
+    }},
+    {"rc5.mode2", []Result{
+        {Protocol: "rc5", Code: 0x000c, Repeat: 0},
+        {Protocol: "rc5", Code: 0x000c, Repeat: 1},
+        {Protocol: "rc5", Code: 0x000c, Repeat: 0},
+        {Protocol: "rc5", Code: 0x0545, Repeat: 0},
+    }},
+    {"rc6.mode2", []Result{
+        {Protocol: "rc6", Code: 0x000c, Repeat: 0},
+        {Protocol: "rc6-6a-32", Code: 0x800f040c, Repeat: 0},
+        {Protocol: "rc6-6a-32", Code: 0x800f040c, Repeat: 1},
+        {Protocol: "rc6-6a-32", Code: 0x800f040c, Repeat: 0},
+    }},
+}
+
+func Test_Decoders(t *testing.T) {
+    for _, fx := range fixtures {
+        f, err := os.Open("testdata/" + fx.file)
+        if err != nil {
+            t.Fatalf("could not open fixture: %s", err.Error())
+        }
+        samples, err := ParseText(f)
+        f.Close()
+        if err != nil {
+            t.Fatalf("%s: %s", fx.file, err.Error())
+        }
+        var got []*Result
+        dec := NewDecoder()
+        for _, s := range samples {
+            if r := dec.Feed(s); r != nil {
+                got = append(got, r)
+            }
+        }
+        if r := dec.Flush(); r != nil {
+            got = append(got, r)
+        }
+        if len(got) != len(fx.expect) {
+            t.Errorf("%s: expected %d results, got %d", fx.file, len(fx.expect), len(got))
+            for _, r := range got {
+                t.Logf("%s: got %s %s repeat %d", fx.file, r.Protocol, r.String(), r.Repeat)
+            }
+            continue
+        }
+        for i, r := range got {
+            e := fx.expect[i]
+            if (r.Protocol != e.Protocol) || (r.Code != e.Code) || (r.Repeat != e.Repeat) {
+                t.Errorf("%s: result %d: expected %s %s repeat %d, got %s %s repeat %d",
+                        fx.file, i, e.Protocol, e.String(), e.Repeat, r.Protocol, r.String(), r.Repeat)
+            }
+        }
+    }
+}
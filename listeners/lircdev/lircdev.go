@@ -0,0 +1,262 @@
+package lircdev
+
+import "os"
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "bufio"
+import "errors"
+import "strings"
+import "strconv"
+import "unsafe"
+import "encoding/binary"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/clog"
+
+// Sample types in LIRC_MODE_MODE2, from linux/lirc.h
+const (
+    mode2Space = 0x00000000
+    mode2Pulse = 0x01000000
+    mode2Frequency = 0x02000000
+    mode2Timeout = 0x03000000
+    mode2Overflow = 0x04000000
+    mode2Value = 0x00ffffff
+    mode2Type = 0xff000000
+)
+
+// flushTimeout is how long to wait for more samples before decoding a frame
+const flushTimeout = 20 * time.Millisecond
+
+// LircDevListener decodes raw infra red timings from a LIRC character
+// device, or from a file recorded with mode2
+type LircDevListener struct {
+    Path string
+    Recorded bool
+    Source string
+
+    stop chan bool
+    file *os.File
+    mu sync.Mutex
+}
+
+// Register registers the lircdev listener module
+func Register() {
+    listeners.RegisterListener("lircdev", Create)
+}
+
+// Create creates a new lircdev listener, reading from either the 'device'
+// or the 'file' parameter
+func Create(params map[string]string) (l listeners.Listener, ok bool) {
+    dl := &LircDevListener{stop: make(chan bool)}
+    if val, ok := params["device"]; ok {
+        dl.Path = val
+    } else if val, ok := params["file"]; ok {
+        dl.Path = val
+        dl.Recorded = true
+    } else {
+        clog.Warn("lircdev: need a 'device' or 'file' parameter")
+        return nil, false
+    }
+    dl.Source = params["source"]
+    return dl, true
+}
+
+// RunListener decodes samples and sends the results to the command stream
+func (l *LircDevListener) RunListener(cs *listeners.CommandStream) {
+    f, err := l.open()
+    if err == errStopped {
+        return
+    } else if err != nil {
+        clog.Warn("lircdev: could not open %s", l.Path)
+        cs.Fatal = true
+        cs.ChErr <- err
+        return
+    }
+    defer l.close()
+
+    samples := make(chan Sample)
+    errs := make(chan error, 1)
+    if l.Recorded {
+        go readText(f, samples, errs, l.stop)
+    } else {
+        go readMode2(f, samples, errs, l.stop)
+    }
+
+    dec := NewDecoder()
+    for {
+        var r *Result
+        select {
+        case s := <- samples:
+            r = dec.Feed(s)
+        case <- time.After(flushTimeout):
+            // The space ending a frame only arrives with the next pulse
+            r = dec.Flush()
+        case <- l.stop:
+            return
+        case err := <- errs:
+            if r = dec.Flush(); r != nil {
+                l.send(cs, r)
+            }
+            cs.Fatal = true
+            cs.ChErr <- err
+            return
+        }
+        if r != nil {
+            l.send(cs, r)
+        }
+    }
+}
+
+var errStopped = errors.New("lircdev: stopped")
+
+// open opens the device or file, unless the listener was stopped
+func (l *LircDevListener) open() (*os.File, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    select {
+    case <- l.stop:
+        return nil, errStopped
+    default:
+    }
+    f, err := os.Open(l.Path)
+    if err != nil {
+        return nil, err
+    }
+    l.file = f
+    return f, nil
+}
+
+// close closes the device or file, if it is still open
+func (l *LircDevListener) close() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.file == nil {
+        return nil
+    }
+    err := l.file.Close()
+    l.file = nil
+    return err
+}
+
+// send sends a decoded result to the command stream, unless the listener
+// is stopped first
+func (l *LircDevListener) send(cs *listeners.CommandStream, r *Result) {
+    select {
+    case cs.Ch <- l.remoteCommand(r):
+    case <- l.stop:
+    }
+}
+
+// StopListener stops decoding and closes the device
+func (l *LircDevListener) StopListener() error {
+    l.mu.Lock()
+    select {
+    case <- l.stop:
+    default:
+        close(l.stop)
+    }
+    l.mu.Unlock()
+    return l.close()
+}
+
+func (l *LircDevListener) remoteCommand(r *Result) *listeners.RemoteCommand {
+    source := l.Source
+    if source == "" {
+        source = r.Protocol
+    }
+    clog.Debug("lircdev: decoded %s %s repeat %d", r.Protocol, r.String(), r.Repeat)
+    return &listeners.RemoteCommand{
+        Code: r.String(),
+        Repeat: r.Repeat,
+        Key: r.String(),
+        Source: source,
+        Time: time.Now(),
+    }
+}
+
+// nativeEndian is the byte order of the host
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+    one := uint16(1)
+    if *(*byte)(unsafe.Pointer(&one)) == 0 {
+        nativeEndian = binary.BigEndian
+    }
+}
+
+// readMode2 reads binary LIRC_MODE_MODE2 samples from a device, until stop
+// is closed
+func readMode2(r io.Reader, samples chan<- Sample, errs chan<- error, stop <-chan bool) {
+    buf := make([]byte, 4)
+    for {
+        if _, err := io.ReadFull(r, buf); err != nil {
+            errs <- err
+            return
+        }
+        // The kernel writes the samples as unsigned ints in the byte order
+        // of the host
+        v := nativeEndian.Uint32(buf)
+        var s Sample
+        switch v & mode2Type {
+        case mode2Pulse:
+            s = Sample{Pulse: true, Duration: v & mode2Value}
+        case mode2Space, mode2Timeout, mode2Overflow:
+            s = Sample{Pulse: false, Duration: v & mode2Value}
+        default:
+            continue
+        }
+        select {
+        case samples <- s:
+        case <- stop:
+            return
+        }
+    }
+}
+
+// readText reads samples in the text format the mode2 tool outputs, until
+// stop is closed
+func readText(r io.Reader, samples chan<- Sample, errs chan<- error, stop <-chan bool) {
+    s, err := ParseText(r)
+    if err != nil {
+        errs <- err
+        return
+    }
+    for _, v := range s {
+        select {
+        case samples <- v:
+        case <- stop:
+            return
+        }
+    }
+    errs <- errors.New("lircdev: end of recorded file")
+}
+
+// ParseText parses samples in the text format the mode2 tool outputs
+func ParseText(r io.Reader) ([]Sample, error) {
+    var ret []Sample
+    sc := bufio.NewScanner(r)
+    lnr := 0
+    for sc.Scan() {
+        lnr++
+        f := strings.Fields(sc.Text())
+        if (len(f) == 0) || strings.HasPrefix(f[0], "#") {
+            continue
+        }
+        if len(f) != 2 {
+            return nil, fmt.Errorf("lircdev: line %d: expected '<type> <duration>'", lnr)
+        }
+        d, err := strconv.ParseUint(f[1], 10, 32)
+        if err != nil {
+            return nil, fmt.Errorf("lircdev: line %d: invalid duration '%s'", lnr, f[1])
+        }
+        switch f[0] {
+        case "pulse":
+            ret = append(ret, Sample{Pulse: true, Duration: uint32(d)})
+        case "space", "timeout":
+            ret = append(ret, Sample{Pulse: false, Duration: uint32(d)})
+        }
+    }
+    return ret, sc.Err()
+}
@@ -0,0 +1,81 @@
+package lircdev
+
+import "os"
+import "io"
+import "time"
+import "bytes"
+import "reflect"
+import "testing"
+import "encoding/binary"
+
+import "github.com/cnf/go-claw/listeners"
+
+func Test_StopListener(t *testing.T) {
+    l, ok := Create(map[string]string{"file": "testdata/nec.mode2"})
+    if !ok {
+        t.Fatal("could not create the listener")
+    }
+    dl := l.(*LircDevListener)
+    // Nobody reads the decoded keys
+    cs := listeners.NewCommandStream()
+    done := make(chan bool)
+    go func() {
+        dl.RunListener(cs)
+        close(done)
+    }()
+    time.Sleep(50 * time.Millisecond)
+    if err := dl.StopListener(); err != nil {
+        t.Errorf("unexpected error stopping: %s", err.Error())
+    }
+    select {
+    case <- done:
+    case <- time.After(time.Second):
+        t.Fatalf("the listener did not stop")
+    }
+    if dl.file != nil {
+        t.Errorf("expected the file to be closed")
+    }
+
+    // The reader stops while nobody reads its samples
+    r, w, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r.Close()
+    defer w.Close()
+    w.Write([]byte{0x2c, 0x01, 0x00, 0x01, 0x2c, 0x01, 0x00, 0x01})
+    stop := make(chan bool)
+    done = make(chan bool)
+    go func() {
+        readMode2(r, make(chan Sample), make(chan error, 1), stop)
+        close(done)
+    }()
+    close(stop)
+    select {
+    case <- done:
+    case <- time.After(time.Second):
+        t.Errorf("the reader did not stop")
+    }
+}
+
+func Test_ReadMode2(t *testing.T) {
+    var data bytes.Buffer
+    for _, v := range []uint32{mode2Pulse | 560, mode2Space | 1690, 0x05000000, mode2Timeout | 20000} {
+        binary.Write(&data, nativeEndian, v)
+    }
+    samples := make(chan Sample, 4)
+    errs := make(chan error, 1)
+    readMode2(&data, samples, errs, make(chan bool))
+    close(samples)
+    var got []Sample
+    for s := range samples {
+        got = append(got, s)
+    }
+    expect := []Sample{{true, 560}, {false, 1690}, {false, 20000}}
+    if !reflect.DeepEqual(got, expect) {
+        t.Errorf("expected samples %v, got %v", expect, got)
+    }
+    if err := <- errs; err != io.EOF {
+        t.Errorf("expected EOF, got %v", err)
+    }
+}
@@ -0,0 +1,46 @@
+package lircdev
+
+// decodeNEC decodes NEC, extended NEC and NEC repeat frames
+func decodeNEC(frame []Sample) (*Result, bool) {
+    if (len(frame) < 3) || !within(frame[0].Duration, 9000) {
+        return nil, false
+    }
+    if (len(frame) == 3) && within(frame[1].Duration, 2250) && within(frame[2].Duration, 560) {
+        return &Result{Protocol: "nec", repeat: true}, true
+    }
+    if !within(frame[1].Duration, 4500) {
+        return nil, false
+    }
+    data, ok := decodePulseDistance(frame[2:], 32, 560, 560, 1690)
+    if !ok {
+        return nil, false
+    }
+    addr := data & 0xff
+    naddr := (data >> 8) & 0xff
+    cmd := (data >> 16) & 0xff
+    ncmd := (data >> 24) & 0xff
+    if cmd ^ ncmd != 0xff {
+        return &Result{Protocol: "nec32", Code: data}, true
+    }
+    if addr ^ naddr != 0xff {
+        return &Result{Protocol: "necx", Code: (naddr << 16) | (addr << 8) | cmd}, true
+    }
+    return &Result{Protocol: "nec", Code: (addr << 8) | cmd}, true
+}
+
+// decodeSamsung decodes the 32 bit Samsung protocol
+func decodeSamsung(frame []Sample) (*Result, bool) {
+    if (len(frame) < 2) || !within(frame[0].Duration, 4500) || !within(frame[1].Duration, 4500) {
+        return nil, false
+    }
+    data, ok := decodePulseDistance(frame[2:], 32, 560, 560, 1690)
+    if !ok {
+        return nil, false
+    }
+    addr := data & 0xff
+    cmd := (data >> 16) & 0xff
+    if (addr != (data >> 8) & 0xff) || (cmd ^ ((data >> 24) & 0xff) != 0xff) {
+        return nil, false
+    }
+    return &Result{Protocol: "samsung", Code: (addr << 8) | cmd}, true
+}
@@ -0,0 +1,33 @@
+package lircdev
+
+// rc5Unit is the duration of half an RC5 bit
+const rc5Unit = 889
+
+// decodeRC5 decodes Philips RC5 frames, including the extended command bit
+func decodeRC5(frame []Sample) (*Result, bool) {
+    if !frame[0].Pulse {
+        return nil, false
+    }
+    units, ok := toUnits(frame, rc5Unit, 2)
+    if !ok {
+        return nil, false
+    }
+    // The first half of the start bit is a space, which we never see.
+    // The second half of the last bit might be a space too.
+    levels := append([]bool{false}, units...)
+    if len(levels) % 2 != 0 {
+        levels = append(levels, false)
+    }
+    data, n, ok := manchesterBits(levels, false)
+    if !ok || (n != 14) || (data & 0x2000 == 0) {
+        return nil, false
+    }
+    cmd := data & 0x3f
+    addr := (data >> 6) & 0x1f
+    toggle := data & 0x800 != 0
+    if data & 0x1000 == 0 {
+        // Inverted field bit is the 7th command bit in RC5X
+        cmd |= 0x40
+    }
+    return &Result{Protocol: "rc5", Code: (addr << 8) | cmd, toggle: toggle}, true
+}
@@ -0,0 +1,50 @@
+package lircdev
+
+import "fmt"
+
+// rc6Unit is the duration of half an RC6 bit
+const rc6Unit = 444
+
+// decodeRC6 decodes RC6 mode 0 and mode 6A frames
+func decodeRC6(frame []Sample) (*Result, bool) {
+    if (len(frame) < 3) || !within(frame[0].Duration, 6*rc6Unit) || !within(frame[1].Duration, 2*rc6Unit) {
+        return nil, false
+    }
+    // The trailer bit is twice as long, so up to three units can merge
+    levels, ok := toUnits(frame[2:], rc6Unit, 3)
+    if !ok || (len(levels) < 12) {
+        return nil, false
+    }
+    if len(levels) % 2 != 0 {
+        levels = append(levels, false)
+    }
+    // Start bit and mode bits
+    hdr, _, ok := manchesterBits(levels[0:8], true)
+    if !ok || (hdr & 0x8 == 0) {
+        return nil, false
+    }
+    mode := hdr & 0x7
+    // Trailer bit, which is the toggle bit
+    var toggle bool
+    if levels[8] && levels[9] && !levels[10] && !levels[11] {
+        toggle = true
+    } else if levels[8] || levels[9] || !levels[10] || !levels[11] {
+        return nil, false
+    }
+    data, n, ok := manchesterBits(levels[12:], true)
+    if !ok {
+        return nil, false
+    }
+    if (mode == 0) && (n == 16) {
+        return &Result{Protocol: "rc6", Code: data, toggle: toggle}, true
+    }
+    if (mode != 6) || ((n != 20) && (n != 24) && (n != 32)) {
+        return nil, false
+    }
+    if (n == 32) && (data >> 16 == 0x800f) {
+        // Microsoft MCE remotes have their own toggle bit
+        toggle = data & 0x8000 != 0
+        data &^= 0x8000
+    }
+    return &Result{Protocol: fmt.Sprintf("rc6-6a-%d", n), Code: data, toggle: toggle}, true
+}
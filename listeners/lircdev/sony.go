@@ -0,0 +1,36 @@
+package lircdev
+
+// decodeSony decodes 12, 15 and 20 bit Sony SIRC frames
+func decodeSony(frame []Sample) (*Result, bool) {
+    if (len(frame) < 2) || !within(frame[0].Duration, 2400) || !within(frame[1].Duration, 600) {
+        return nil, false
+    }
+    frame = frame[2:]
+    bits := (len(frame) + 1) / 2
+    if (len(frame) % 2 != 1) || ((bits != 12) && (bits != 15) && (bits != 20)) {
+        return nil, false
+    }
+    var data uint32
+    for i := 0; i < bits; i++ {
+        if within(frame[2*i].Duration, 1200) {
+            data |= 1 << uint(i)
+        } else if !within(frame[2*i].Duration, 600) {
+            return nil, false
+        }
+        if (2*i + 1 < len(frame)) && !within(frame[2*i + 1].Duration, 600) {
+            return nil, false
+        }
+    }
+    // 7 command bits, followed by the device and extended bits
+    cmd := data & 0x7f
+    dev := data >> 7
+    switch bits {
+    case 12:
+        return &Result{Protocol: "sony12", Code: (dev << 8) | cmd}, true
+    case 15:
+        return &Result{Protocol: "sony15", Code: (dev << 8) | cmd}, true
+    }
+    ext := dev >> 5
+    dev = dev & 0x1f
+    return &Result{Protocol: "sony20", Code: (ext << 16) | (dev << 8) | cmd}, true
+}
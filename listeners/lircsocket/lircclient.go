@@ -0,0 +1,214 @@
+package lircsocket
+
+import "net"
+import "fmt"
+import "sync"
+import "time"
+import "bufio"
+import "errors"
+import "strings"
+import "strconv"
+
+// DefaultPort is the port lircd listens on when started with --listen
+const DefaultPort = "8765"
+
+// Reply holds a reply packet sent by lircd in response to a command
+type Reply struct {
+    Command string
+    Success bool
+    Data []string
+}
+
+// Client talks the command side of the lircd protocol
+type Client struct {
+    Network string
+    Address string
+    Timeout time.Duration
+
+    conn net.Conn
+    reader *bufio.Reader
+    mu sync.Mutex
+}
+
+// NewClient creates a new lircd client for a unix socket path or a
+// TCP host:port address
+func NewClient(network, address string) *Client {
+    return &Client{Network: network, Address: address, Timeout: time.Duration(3 * time.Second)}
+}
+
+// Connect opens the connection to lircd
+func (c *Client) Connect() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.conn != nil {
+        return nil
+    }
+    conn, err := net.DialTimeout(c.Network, c.Address, c.Timeout)
+    if err != nil {
+        return err
+    }
+    c.conn = conn
+    c.reader = bufio.NewReader(conn)
+    return nil
+}
+
+// Close closes the connection to lircd
+func (c *Client) Close() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.conn == nil {
+        return nil
+    }
+    err := c.conn.Close()
+    c.conn = nil
+    c.reader = nil
+    return err
+}
+
+// Command sends a command to lircd and waits for the reply packet.
+// Button broadcasts received while waiting are discarded.
+func (c *Client) Command(cmd string) (*Reply, error) {
+    if err := c.Connect(); err != nil {
+        return nil, err
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.conn.SetDeadline(time.Now().Add(c.Timeout))
+    defer c.conn.SetDeadline(time.Time{})
+    if _, err := fmt.Fprintf(c.conn, "%s\n", cmd); err != nil {
+        return nil, err
+    }
+    for {
+        line, err := readLine(c.reader)
+        if err != nil {
+            return nil, err
+        }
+        if line != "BEGIN" {
+            continue
+        }
+        reply, err := readReply(c.reader)
+        if err != nil {
+            return nil, err
+        }
+        if reply.Command != cmd {
+            // SIGHUP notification or a reply to someone else
+            continue
+        }
+        if !reply.Success {
+            return reply, fmt.Errorf("lircd: command '%s' failed: %s", cmd, strings.Join(reply.Data, " "))
+        }
+        return reply, nil
+    }
+}
+
+// Version returns the version of the running lircd
+func (c *Client) Version() (string, error) {
+    reply, err := c.Command("VERSION")
+    if err != nil {
+        return "", err
+    }
+    if len(reply.Data) == 0 {
+        return "", errors.New("lircd: empty VERSION reply")
+    }
+    return reply.Data[0], nil
+}
+
+// List returns the names of all remotes lircd knows
+func (c *Client) List() ([]string, error) {
+    reply, err := c.Command("LIST")
+    if err != nil {
+        return nil, err
+    }
+    return reply.Data, nil
+}
+
+// ListRemote returns the key names lircd knows for a given remote
+func (c *Client) ListRemote(remote string) ([]string, error) {
+    reply, err := c.Command("LIST " + remote)
+    if err != nil {
+        return nil, err
+    }
+    var keys []string
+    for _, d := range reply.Data {
+        // Each line holds '<code> <key name>'
+        f := strings.Fields(d)
+        if len(f) == 0 {
+            continue
+        }
+        keys = append(keys, f[len(f)-1])
+    }
+    return keys, nil
+}
+
+// Keys returns all remotes lircd knows, with their key names
+func (c *Client) Keys() (map[string][]string, error) {
+    remotes, err := c.List()
+    if err != nil {
+        return nil, err
+    }
+    ret := make(map[string][]string, len(remotes))
+    for _, r := range remotes {
+        keys, err := c.ListRemote(r)
+        if err != nil {
+            return nil, err
+        }
+        ret[r] = keys
+    }
+    return ret, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+    str, err := r.ReadString('\n')
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimRight(str, "\r\n"), nil
+}
+
+// readReply reads a reply packet, after the BEGIN line has been read:
+//   BEGIN
+//   <command>
+//   [SUCCESS|ERROR]
+//   [DATA
+//   n
+//   n lines of data]
+//   END
+func readReply(r *bufio.Reader) (*Reply, error) {
+    cmd, err := readLine(r)
+    if err != nil {
+        return nil, err
+    }
+    reply := &Reply{Command: cmd, Success: true}
+    for {
+        line, err := readLine(r)
+        if err != nil {
+            return nil, err
+        }
+        switch line {
+        case "END":
+            return reply, nil
+        case "SUCCESS":
+            reply.Success = true
+        case "ERROR":
+            reply.Success = false
+        case "DATA":
+            nstr, err := readLine(r)
+            if err != nil {
+                return nil, err
+            }
+            n, err := strconv.Atoi(nstr)
+            if err != nil {
+                return nil, fmt.Errorf("lircd: invalid DATA length '%s'", nstr)
+            }
+            for i := 0; i < n; i++ {
+                d, err := readLine(r)
+                if err != nil {
+                    return nil, err
+                }
+                reply.Data = append(reply.Data, d)
+            }
+        default:
+            return nil, fmt.Errorf("lircd: unexpected line in reply packet: '%s'", line)
+        }
+    }
+}
@@ -0,0 +1,54 @@
+package lircsocket
+
+import "net"
+import "bufio"
+import "testing"
+
+// fakeLircd answers the commands a client sends, with a button broadcast
+// and a SIGHUP packet thrown in to make sure they are skipped
+func fakeLircd(conn net.Conn) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    replies := map[string]string{
+        "VERSION": "BEGIN\nVERSION\nSUCCESS\nDATA\n1\n0.9.0\nEND\n",
+        "LIST": "BEGIN\nLIST\nSUCCESS\nDATA\n2\ntv\navr\nEND\n",
+        "LIST tv": "BEGIN\nLIST tv\nSUCCESS\nDATA\n2\n0000000000000001 KEY_POWER\n0000000000000002 KEY_OK\nEND\n",
+        "LIST avr": "BEGIN\nLIST avr\nSUCCESS\nDATA\n1\n0000000000000010 KEY_VOLUMEUP\nEND\n",
+    }
+    for {
+        cmd, err := readLine(r)
+        if err != nil {
+            return
+        }
+        conn.Write([]byte("000000037ff07bef 00 KEY_VOLUMEUP tv\nBEGIN\nSIGHUP\nEND\n"))
+        if reply, ok := replies[cmd]; ok {
+            conn.Write([]byte(reply))
+        } else {
+            conn.Write([]byte("BEGIN\n" + cmd + "\nERROR\nDATA\n1\nunknown remote\nEND\n"))
+        }
+    }
+}
+
+func Test_Client(t *testing.T) {
+    cconn, sconn := net.Pipe()
+    go fakeLircd(sconn)
+    c := NewClient("pipe", "")
+    c.conn = cconn
+    c.reader = bufio.NewReader(cconn)
+    defer c.Close()
+
+    v, err := c.Version()
+    if (err != nil) || (v != "0.9.0") {
+        t.Errorf("expected version 0.9.0, got '%s' (%v)", v, err)
+    }
+    keys, err := c.Keys()
+    if err != nil {
+        t.Fatalf("could not list keys: %s", err.Error())
+    }
+    if (len(keys["tv"]) != 2) || (keys["tv"][1] != "KEY_OK") || (len(keys["avr"]) != 1) {
+        t.Errorf("unexpected key list: %v", keys)
+    }
+    if _, err := c.ListRemote("foo"); err == nil {
+        t.Errorf("expected an error listing an unknown remote")
+    }
+}
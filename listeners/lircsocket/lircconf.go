@@ -0,0 +1,241 @@
+package lircsocket
+
+import "io"
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+import "strconv"
+import "path/filepath"
+
+// Remote is a remote control block from a lircd.conf file
+type Remote struct {
+    Name string
+    // Protocol flags, like "SPACE_ENC" or "RAW_CODES"
+    Flags []string
+    // All other settings, like "bits" or "pre_data", by name
+    Params map[string][]string
+    Codes []*Code
+    RawCodes []*RawCode
+}
+
+// Code is a button of a remote with its codes
+type Code struct {
+    Name string
+    Codes []uint64
+}
+
+// RawCode is a button of a remote with its pulse and space timings
+type RawCode struct {
+    Name string
+    Timings []int
+}
+
+// HasFlag returns true if a protocol flag is set for the remote
+func (r *Remote) HasFlag(flag string) bool {
+    for _, f := range r.Flags {
+        if strings.EqualFold(f, flag) {
+            return true
+        }
+    }
+    return false
+}
+
+// Buttons returns the button names of the remote, in the order of the file
+func (r *Remote) Buttons() []string {
+    var ret []string
+    for _, c := range r.Codes {
+        ret = append(ret, c.Name)
+    }
+    for _, c := range r.RawCodes {
+        ret = append(ret, c.Name)
+    }
+    return ret
+}
+
+// ReadConfig reads the remotes from a lircd.conf file, following include
+// directives
+func ReadConfig(path string) ([]*Remote, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    p := &confParser{path: path, dir: filepath.Dir(path)}
+    return p.parse(f)
+}
+
+// ParseConfig reads the remotes from a lircd.conf file. Include directives
+// are relative to the current directory.
+func ParseConfig(r io.Reader) ([]*Remote, error) {
+    p := &confParser{path: "lircd.conf", dir: "."}
+    return p.parse(r)
+}
+
+type confParser struct {
+    path string
+    dir string
+    line int
+    remotes []*Remote
+    remote *Remote
+    // "remote", "codes" or "raw_codes" while inside a block
+    block string
+    raw *RawCode
+}
+
+func (p *confParser) errorf(format string, a ...interface{}) error {
+    return fmt.Errorf("%s:%d: %s", p.path, p.line, fmt.Sprintf(format, a...))
+}
+
+func (p *confParser) parse(r io.Reader) ([]*Remote, error) {
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        p.line++
+        line := scanner.Text()
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            continue
+        }
+        if err := p.parseLine(fields); err != nil {
+            return nil, err
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if p.remote != nil {
+        return nil, p.errorf("missing end remote")
+    }
+    return p.remotes, nil
+}
+
+func (p *confParser) parseLine(fields []string) error {
+    key := strings.ToLower(fields[0])
+    switch {
+    case (key == "include") && (p.remote == nil):
+        return p.include(fields[1:])
+    case key == "begin":
+        return p.begin(fields[1:])
+    case key == "end":
+        return p.end(fields[1:])
+    case p.remote == nil:
+        return p.errorf("unexpected `%s` outside of a remote block", fields[0])
+    case p.block == "codes":
+        return p.code(fields)
+    case p.block == "raw_codes":
+        return p.rawCode(fields)
+    case key == "name":
+        if len(fields) < 2 {
+            return p.errorf("missing remote name")
+        }
+        p.remote.Name = fields[1]
+    case key == "flags":
+        for _, f := range fields[1:] {
+            for _, flag := range strings.Split(f, "|") {
+                if flag != "" {
+                    p.remote.Flags = append(p.remote.Flags, flag)
+                }
+            }
+        }
+    default:
+        p.remote.Params[key] = fields[1:]
+    }
+    return nil
+}
+
+func (p *confParser) include(args []string) error {
+    if len(args) == 0 {
+        return p.errorf("missing include file")
+    }
+    pattern := strings.Trim(strings.Join(args, " "), `"<>`)
+    if !filepath.IsAbs(pattern) {
+        pattern = filepath.Join(p.dir, pattern)
+    }
+    files, err := filepath.Glob(pattern)
+    if err != nil {
+        return p.errorf("%s", err)
+    }
+    for _, file := range files {
+        remotes, err := ReadConfig(file)
+        if err != nil {
+            return err
+        }
+        p.remotes = append(p.remotes, remotes...)
+    }
+    return nil
+}
+
+func (p *confParser) begin(args []string) error {
+    if len(args) != 1 {
+        return p.errorf("invalid begin")
+    }
+    block := strings.ToLower(args[0])
+    switch {
+    case (block == "remote") && (p.remote == nil):
+        p.remote = &Remote{Params: make(map[string][]string)}
+    case ((block == "codes") || (block == "raw_codes")) && (p.remote != nil) && (p.block == "remote"):
+    default:
+        return p.errorf("unexpected begin %s", args[0])
+    }
+    p.block = block
+    return nil
+}
+
+func (p *confParser) end(args []string) error {
+    if (len(args) != 1) || (strings.ToLower(args[0]) != p.block) {
+        return p.errorf("unexpected end %s", strings.Join(args, " "))
+    }
+    if p.block == "remote" {
+        if p.remote.Name == "" {
+            return p.errorf("remote without a name")
+        }
+        p.remotes = append(p.remotes, p.remote)
+        p.remote = nil
+        p.block = ""
+        return nil
+    }
+    p.raw = nil
+    p.block = "remote"
+    return nil
+}
+
+func (p *confParser) code(fields []string) error {
+    if len(fields) < 2 {
+        return p.errorf("missing code for button `%s`", fields[0])
+    }
+    c := &Code{Name: fields[0]}
+    for _, f := range fields[1:] {
+        v, err := strconv.ParseUint(f, 0, 64)
+        if err != nil {
+            return p.errorf("invalid code `%s` for button `%s`", f, fields[0])
+        }
+        c.Codes = append(c.Codes, v)
+    }
+    p.remote.Codes = append(p.remote.Codes, c)
+    return nil
+}
+
+func (p *confParser) rawCode(fields []string) error {
+    if strings.ToLower(fields[0]) == "name" {
+        if len(fields) < 2 {
+            return p.errorf("missing button name")
+        }
+        p.raw = &RawCode{Name: fields[1]}
+        p.remote.RawCodes = append(p.remote.RawCodes, p.raw)
+        return nil
+    }
+    if p.raw == nil {
+        return p.errorf("timings without a button name")
+    }
+    for _, f := range fields {
+        v, err := strconv.Atoi(f)
+        if err != nil {
+            return p.errorf("invalid timing `%s` for button `%s`", f, p.raw.Name)
+        }
+        p.raw.Timings = append(p.raw.Timings, v)
+    }
+    return nil
+}
@@ -0,0 +1,52 @@
+package lircsocket
+
+import "reflect"
+import "strings"
+import "testing"
+
+func Test_ReadConfig(t *testing.T) {
+    remotes, err := ReadConfig("testdata/lircd.conf")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(remotes) != 2 {
+        t.Fatalf("expected 2 remotes, got %d", len(remotes))
+    }
+
+    raw := remotes[0]
+    if (raw.Name != "aircon") || !raw.HasFlag("raw_codes") || (len(raw.RawCodes) != 2) {
+        t.Errorf("unexpected raw remote: %+v", raw)
+    } else if !reflect.DeepEqual(raw.RawCodes[0].Timings, []int{3380, 1622, 462, 385, 462, 1246, 462, 385}) {
+        t.Errorf("unexpected raw timings: %v", raw.RawCodes[0].Timings)
+    }
+
+    r := remotes[1]
+    if (r.Name != "Samsung_BN59") || !r.HasFlag("SPACE_ENC") || !r.HasFlag("CONST_LENGTH") {
+        t.Errorf("unexpected remote: %+v", r)
+    }
+    if !reflect.DeepEqual(r.Params["pre_data"], []string{"0xE0E0"}) || !reflect.DeepEqual(r.Params["header"], []string{"4500", "4500"}) {
+        t.Errorf("unexpected params: %v", r.Params)
+    }
+    if got := strings.Join(r.Buttons(), ","); got != "KEY_POWER,KEY_VOLUMEUP,KEY_VOLUMEDOWN,ok" {
+        t.Errorf("unexpected buttons: %s", got)
+    }
+    if !reflect.DeepEqual(r.Codes[3].Codes, []uint64{0x16e9, 0x16e8}) {
+        t.Errorf("unexpected codes: %v", r.Codes[3].Codes)
+    }
+}
+
+func Test_ParseConfigErrors(t *testing.T) {
+    tests := map[string]string{
+        "begin remote\n  name x\n": "lircd.conf:2: missing end remote",
+        "name x\n": "lircd.conf:1: unexpected `name` outside of a remote block",
+        "begin remote\n  name x\n  begin codes\n  KEY_OK zz\n": "lircd.conf:4: invalid code `zz` for button `KEY_OK`",
+        "begin remote\n  begin codes\n  end remote\n": "lircd.conf:3: unexpected end remote",
+        "begin remote\nend remote\n": "lircd.conf:2: remote without a name",
+    }
+    for conf, want := range tests {
+        _, err := ParseConfig(strings.NewReader(conf))
+        if (err == nil) || (err.Error() != want) {
+            t.Errorf("expected error '%s', got '%v'", want, err)
+        }
+    }
+}
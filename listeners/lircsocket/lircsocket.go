@@ -1,10 +1,11 @@
 package lircsocket
 
 import "net"
-import "io"
+import "errors"
 import "strings"
 import "bufio"
 import "strconv"
+import "sync"
 import "time"
 
 import "github.com/cnf/go-claw/listeners"
@@ -12,8 +13,11 @@ import "github.com/cnf/go-claw/clog"
 
 type LircSocketListener struct {
     Path string
-    // conn net.Conn
+    Network string
+    conn net.Conn
     reader *bufio.Reader
+    stopped bool
+    mu sync.Mutex
 }
 
 func Register() {
@@ -25,6 +29,14 @@ func Create(params map[string]string) (l listeners.Listener, ok bool) {
     sl := &LircSocketListener{}
     if val, ok := params["path"]; ok {
         sl.Path = val
+        sl.Network = "unix"
+    } else if val, ok := params["address"]; ok {
+        // lircd running on another host, started with --listen
+        if _, _, err := net.SplitHostPort(val); err != nil {
+            val = net.JoinHostPort(val, DefaultPort)
+        }
+        sl.Path = val
+        sl.Network = "tcp"
     } else {
         clog.Warn("Incorrect parameters")
         return nil, false
@@ -32,26 +44,95 @@ func Create(params map[string]string) (l listeners.Listener, ok bool) {
     return sl, true
 }
 
-func (l *LircSocketListener) setup(cs *listeners.CommandStream) bool {
+// KnownKeys asks lircd for all the remotes and key names it knows about
+func (l *LircSocketListener) KnownKeys() (map[string][]string, error) {
+    c := NewClient(l.Network, l.Path)
+    defer c.Close()
+    return c.Keys()
+}
+
+// Time to wait before reconnecting to lircd, doubled after every failed
+// attempt up to reconnectMax
+var reconnectWait = 1000 * time.Millisecond
+var reconnectMax = 30 * time.Second
+
+var errStopped = errors.New("listener stopped")
+
+// connect opens a connection to lircd, unless the listener was stopped
+func (l *LircSocketListener) connect() error {
     clog.Debug("Opening socket: %s", l.Path)
-    c, err := net.Dial("unix", l.Path)
-    // If there is no socket to bind to during setup, we fail.
+    c, err := net.Dial(l.Network, l.Path)
     if err != nil {
+        return err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.stopped {
+        c.Close()
+        return errStopped
+    }
+    l.conn = c
+    l.reader = bufio.NewReader(c)
+    return nil
+}
+
+func (l *LircSocketListener) setup(cs *listeners.CommandStream) bool {
+    err := l.connect()
+    // If there is no socket to bind to during setup, we fail.
+    if (err != nil) && (err != errStopped) {
         clog.Warn("Socket setup failed for %s", l.Path)
         cs.ChErr <- err
-        return false
     }
-    l.reader = bufio.NewReader(c)
-    return true
+    return err == nil
+}
+
+// reconnect closes the connection to lircd, and opens a new one once lircd
+// is back. It returns false when the listener was stopped.
+func (l *LircSocketListener) reconnect() bool {
+    l.mu.Lock()
+    if l.conn != nil {
+        l.conn.Close()
+    }
+    l.mu.Unlock()
+    wait := reconnectWait
+    for {
+        time.Sleep(wait)
+        if l.isStopped() {
+            return false
+        }
+        err := l.connect()
+        if err == nil {
+            clog.Info("Reconnected to %s", l.Path)
+            return true
+        }
+        if err == errStopped {
+            return false
+        }
+        clog.Debug("Could not reconnect to %s: %s", l.Path, err.Error())
+        if wait *= 2; wait > reconnectMax {
+            wait = reconnectMax
+        }
+    }
+}
+
+func (l *LircSocketListener) isStopped() bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.stopped
+}
+
+// StopListener closes the connection to lircd
+func (l *LircSocketListener) StopListener() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.stopped = true
+    if l.conn != nil {
+        return l.conn.Close()
+    }
+    return nil
 }
 
 func (l *LircSocketListener) RunListener(cs *listeners.CommandStream) {
-    // var err error
-    // l.conn, err = net.Dial("unix", l.Path)
-    // if err != nil {
-        // cs.ChErr <- err
-        // return
-    // }
     if (!l.setup(cs)) {
         cs.Fatal = true
         return
@@ -59,23 +140,27 @@ func (l *LircSocketListener) RunListener(cs *listeners.CommandStream) {
     for {
         str, err := l.reader.ReadString('\n')
         if err != nil {
-            if err != io.EOF {
-                // Remote end closed socket
-                clog.Error("Unknown error occured: %s", err.Error())
-            } else {
-                clog.Error("Socket closed by remote host: %s", err.Error())
-                time.Sleep(1000 * time.Millisecond)
-                // var err error
-                // l.conn, err = net.Dial("unix", l.Path)
-                if (!l.setup(cs)) {
-                    time.Sleep(3000 * time.Millisecond)
-                    continue
-                }
+            if l.isStopped() {
+                return
+            }
+            // lircd closed the socket, or the connection to it broke
+            clog.Error("Lost the connection to %s: %s", l.Path, err.Error())
+            if !l.reconnect() {
+                return
+            }
+            continue
+        }
+
+        if strings.TrimSpace(str) == "BEGIN" {
+            // Reply packet, not a button press
+            reply, err := readReply(l.reader)
+            if err != nil {
+                clog.Error("Could not read lircd reply: %s", err.Error())
                 continue
             }
-            // cs.ChErr <- err
-            // return
-            time.Sleep(1000 * time.Millisecond)
+            if reply.Command == "SIGHUP" {
+                clog.Info("lircd reloaded its configuration")
+            }
             continue
         }
 
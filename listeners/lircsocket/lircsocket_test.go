@@ -0,0 +1,55 @@
+package lircsocket
+
+import "net"
+import "time"
+import "testing"
+
+import "github.com/cnf/go-claw/listeners"
+
+func Test_Reconnect(t *testing.T) {
+    defer func(wait time.Duration) { reconnectWait = wait }(reconnectWait)
+    reconnectWait = 10 * time.Millisecond
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    l, ok := Create(map[string]string{"address": ln.Addr().String()})
+    if !ok {
+        t.Fatal("could not create the listener")
+    }
+    sl := l.(*LircSocketListener)
+    cs := listeners.NewCommandStream()
+    done := make(chan bool)
+    go func() {
+        defer close(done)
+        sl.RunListener(cs)
+    }()
+    // Wait for the listener to return before reconnectWait is restored
+    defer func() {
+        sl.StopListener()
+        <- done
+    }()
+
+    for _, key := range []string{"KEY_UP", "KEY_DOWN"} {
+        conn, err := ln.Accept()
+        if err != nil {
+            t.Fatal(err)
+        }
+        conn.Write([]byte("000000037ff07bef 00 " + key + " tv\n"))
+        select {
+        case rc := <- cs.Ch:
+            if rc.Key != key {
+                t.Errorf("expected %s, got %v", key, rc)
+            }
+        case err := <- cs.ChErr:
+            t.Fatalf("listener failed: %s", err.Error())
+        case <- time.After(2 * time.Second):
+            t.Fatalf("timeout waiting for %s", key)
+        }
+        // Reset the connection, which is not an EOF for the listener
+        conn.(*net.TCPConn).SetLinger(0)
+        conn.Close()
+    }
+}
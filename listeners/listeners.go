@@ -1,11 +1,14 @@
 package listeners
 
 import "github.com/cnf/go-claw/clog"
+import "github.com/cnf/go-claw/modes"
 
 type Listener interface {
     RunListener(cs *CommandStream)
 }
 
+// CreateListener creates a listener from its parameters. It only checks and
+// keeps the parameters, devices and sockets are opened by RunListener.
 type CreateListener func(params map[string]string) (l Listener, ok bool)
 
 var list = make(map[string]CreateListener)
@@ -21,3 +24,32 @@ func GetListener(name string, params map[string]string) (l Listener, ok bool) {
     clog.Warn("Listener `%s` does not exist", name)
     return nil, false
 }
+
+// CheckListener returns true if a listener module is registered and
+// accepts the parameters, without running a listener
+func CheckListener(name string, params map[string]string) bool {
+    create, ok := list[name]
+    if !ok {
+        return false
+    }
+    _, ok = create(params)
+    return ok
+}
+
+// KeyLister is implemented by listeners that can report which remotes and
+// key names they know about
+type KeyLister interface {
+    KnownKeys() (map[string][]string, error)
+}
+
+// Stopper is implemented by listeners that can be stopped, so they can be
+// replaced when the config is reloaded
+type Stopper interface {
+    StopListener() error
+}
+
+// ModeWatcher is implemented by listeners that need to know about the
+// configured modes, it is called before the listener is started
+type ModeWatcher interface {
+    SetModes(m *modes.Modes)
+}
@@ -2,10 +2,45 @@ package listeners
 
 import "time"
 
+// KeyEvent tells if a RemoteCommand is a key press, hold or release
+type KeyEvent int
+
+const (
+    // KeyUnknown is used by listeners that only report repeat counts
+    KeyUnknown KeyEvent = iota
+    KeyPress
+    KeyHold
+    KeyRelease
+)
+
+var keyEventNames = [...]string{
+    "unknown",
+    "press",
+    "hold",
+    "release",
+}
+
+// String returns the name of the key event as used in mode bindings
+func (e KeyEvent) String() string {
+    if (e < 0) || (int(e) >= len(keyEventNames)) {
+        return "unknown"
+    }
+    return keyEventNames[e]
+}
+
 type RemoteCommand struct {
     Code    string
     Repeat  int
     Key     string
     Source  string
     Time    time.Time
+    Event   KeyEvent
+    // Name of the listener instance in the config, filled in by the
+    // CommandStream
+    Listener string
+}
+
+// Origin identifies the remote a command came from
+func (rc *RemoteCommand) Origin() string {
+    return rc.Listener + "\x00" + rc.Source
 }
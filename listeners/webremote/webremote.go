@@ -0,0 +1,346 @@
+package webremote
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "strings"
+import "net/http"
+import "html/template"
+
+import "golang.org/x/net/websocket"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/clog"
+
+// WebRemote serves a web page with a button for every key in the active
+// and default mode, and sends the pressed keys to the dispatcher
+type WebRemote struct {
+    Address string
+    Source string
+    // Hosts of other pages allowed to press keys, like "tablet.lan:8000",
+    // besides the web remote itself
+    Origins []string
+
+    modes *modes.Modes
+    cs *listeners.CommandStream
+    ln net.Listener
+    stopped bool
+    // stop is closed when the listener is stopped, conns are the open
+    // websockets
+    stop chan bool
+    conns map[*websocket.Conn]bool
+    mu sync.Mutex
+}
+
+// layout is sent to the browser whenever the active mode changes
+type layout struct {
+    Mode string `json:"mode"`
+    Keys []string `json:"keys"`
+}
+
+// press is sent by the browser when a button is pressed
+type press struct {
+    Key string `json:"key"`
+    Repeat int `json:"repeat"`
+}
+
+// Register registers the webremote listener module
+func Register() {
+    listeners.RegisterListener("webremote", Create)
+}
+
+// Create creates a new web remote, listening on the 'address' parameter
+func Create(params map[string]string) (l listeners.Listener, ok bool) {
+    wr := &WebRemote{Address: ":8080", Source: "webremote", stop: make(chan bool), conns: make(map[*websocket.Conn]bool)}
+    if val, ok := params["address"]; ok {
+        wr.Address = val
+    }
+    if val, ok := params["source"]; ok {
+        wr.Source = val
+    }
+    if val, ok := params["origins"]; ok {
+        wr.Origins = strings.Split(val, ",")
+    }
+    return wr, true
+}
+
+// SetModes gives the web remote access to the configured modes
+func (l *WebRemote) SetModes(m *modes.Modes) {
+    l.modes = m
+}
+
+// RunListener starts the http server
+func (l *WebRemote) RunListener(cs *listeners.CommandStream) {
+    l.cs = cs
+    mux := l.handler()
+    clog.Info("webremote: serving on %s", l.Address)
+    ln, err := net.Listen("tcp", l.Address)
+    if err == nil {
+        l.mu.Lock()
+        l.ln = ln
+        if l.stopped {
+            ln.Close()
+        }
+        l.mu.Unlock()
+        err = http.Serve(ln, mux)
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.stopped {
+        clog.Info("webremote: server on %s stopped", l.Address)
+        return
+    }
+    clog.Warn("webremote: server on %s stopped: %s", l.Address, err.Error())
+    cs.Fatal = true
+    cs.ChErr <- err
+}
+
+// StopListener stops the http server, and closes the open websockets
+func (l *WebRemote) StopListener() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if !l.stopped {
+        close(l.stop)
+    }
+    l.stopped = true
+    for conn := range l.conns {
+        conn.Close()
+    }
+    if l.ln != nil {
+        return l.ln.Close()
+    }
+    return nil
+}
+
+// addConn keeps an open websocket to close when the listener stops, it
+// returns false if the listener stopped already
+func (l *WebRemote) addConn(conn *websocket.Conn) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.stopped {
+        return false
+    }
+    l.conns[conn] = true
+    return true
+}
+
+func (l *WebRemote) removeConn(conn *websocket.Conn) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    delete(l.conns, conn)
+}
+
+// handler returns the handler serving the page and its websocket
+func (l *WebRemote) handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", l.serveIndex)
+    mux.Handle("/sock", websocket.Server{Handler: l.serveWebsocket, Handshake: l.checkOrigin})
+    return mux
+}
+
+// checkOrigin only accepts websockets opened by the page of the web remote,
+// or by a page in Origins, so other web sites can not press keys
+func (l *WebRemote) checkOrigin(config *websocket.Config, r *http.Request) error {
+    origin, err := websocket.Origin(config, r)
+    if err != nil {
+        return err
+    }
+    if origin == nil {
+        return fmt.Errorf("missing origin")
+    }
+    config.Origin = origin
+    if strings.EqualFold(origin.Host, r.Host) {
+        return nil
+    }
+    for _, o := range l.Origins {
+        if strings.EqualFold(strings.TrimSpace(o), origin.Host) {
+            return nil
+        }
+    }
+    clog.Warn("webremote: refused websocket from %s for %s", origin.String(), r.RemoteAddr)
+    return fmt.Errorf("origin %s is not allowed", origin.String())
+}
+
+func (l *WebRemote) serveIndex(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+        http.NotFound(w, r)
+        return
+    }
+    indexpg.Execute(w, nil)
+}
+
+func (l *WebRemote) layout() *layout {
+    if l.modes == nil {
+        return &layout{Mode: "default"}
+    }
+    return &layout{Mode: l.modes.ActiveName(), Keys: l.modes.Keys()}
+}
+
+func (l *WebRemote) serveWebsocket(conn *websocket.Conn) {
+    clog.Debug("webremote: websocket connected: %s", conn.Request().RemoteAddr)
+    defer conn.Close()
+    if !l.addConn(conn) {
+        return
+    }
+    defer l.removeConn(conn)
+
+    var watch chan string
+    if l.modes != nil {
+        watch = l.modes.Watch()
+        defer l.modes.Unwatch(watch)
+    }
+    presses := make(chan *press)
+    done := make(chan bool)
+    defer close(done)
+    go func() {
+        defer close(presses)
+        for {
+            p := new(press)
+            if err := websocket.JSON.Receive(conn, p); err != nil {
+                clog.Debug("webremote: websocket closed: %s", err.Error())
+                return
+            }
+            select {
+            case presses <- p:
+            case <- done:
+                return
+            }
+        }
+    }()
+
+    if err := websocket.JSON.Send(conn, l.layout()); err != nil {
+        return
+    }
+    for {
+        select {
+        case <- l.stop:
+            return
+        case <- watch:
+            if err := websocket.JSON.Send(conn, l.layout()); err != nil {
+                return
+            }
+        case p, ok := <- presses:
+            if !ok {
+                return
+            }
+            if p.Key == "" {
+                continue
+            }
+            select {
+            case l.cs.Ch <- &listeners.RemoteCommand{Code: p.Key, Repeat: p.Repeat, Key: p.Key, Source: l.Source, Time: time.Now()}:
+            case <- l.stop:
+                return
+            }
+        }
+    }
+}
+
+var indexpg = template.Must(template.New("index").Parse(`
+<html>
+    <head>
+        <title>Claw web remote</title>
+        <script>
+var path = window.location.pathname;
+var wsScheme = (window.location.protocol == "https:") ? "wss://" : "ws://";
+var wsURL = wsScheme + window.location.host +  path.substring(0, path.lastIndexOf('/')) + "/sock";
+var ws;
+var keys = [];
+
+// Javascript keycodes for the keys we know about
+var keycodes = {
+    "KEY_UP"         : [ 38 ],
+    "KEY_DOWN"       : [ 40 ],
+    "KEY_LEFT"       : [ 37 ],
+    "KEY_RIGHT"      : [ 39 ],
+    "KEY_OK"         : [ 13 ],
+    "KEY_BACK"       : [  8 ],
+    "KEY_POWER"      : [ 80 ],
+    "KEY_EXIT"       : [ 27 ],
+    "KEY_PLAY"       : [ 32 ],
+    "KEY_VOLUMEUP"   : [ 107, 187, 33 ],
+    "KEY_VOLUMEDOWN" : [ 109, 189, 34 ],
+    "KEY_MUTE"       : [ 77 ],
+    "KEY_0"          : [ 48 ],
+    "KEY_1"          : [ 49 ],
+    "KEY_2"          : [ 50 ],
+    "KEY_3"          : [ 51 ],
+    "KEY_4"          : [ 52 ],
+    "KEY_5"          : [ 53 ],
+    "KEY_6"          : [ 54 ],
+    "KEY_7"          : [ 55 ],
+    "KEY_8"          : [ 56 ],
+    "KEY_9"          : [ 57 ],
+};
+
+function sendKey(key, repeat) {
+    if (ws == null) {
+        return
+    }
+    ws.send(JSON.stringify({key: key, repeat: repeat}))
+    log("Sent key: " + key)
+}
+
+function connectWs() {
+    ws = new WebSocket(wsURL);
+    ws.onopen = function() {
+        log("Connected.")
+    }
+    ws.onmessage = function(evt) {
+        var l = JSON.parse(evt.data)
+        keys = l.keys || []
+        document.getElementById("mode").textContent = l.mode
+        showButtons()
+    }
+    ws.onclose = function() {
+        ws = null
+        keys = []
+        showButtons()
+        log("Connection closed.")
+        setTimeout(connectWs, 3000)
+    }
+}
+
+document.addEventListener("DOMContentLoaded", connectWs)
+document.addEventListener('keydown', function(event) {
+    for (var i = 0; i < keys.length; i++) {
+        var kc = keycodes[keys[i]] || []
+        if (kc.indexOf(event.keyCode) >= 0) {
+            sendKey(keys[i], event.repeat ? 1 : 0)
+            event.preventDefault();
+            return false
+        }
+    }
+    return true
+}, true);
+
+function log(str) {
+    var m = document.getElementById("messages")
+    m.insertBefore(document.createElement("br"), m.firstChild)
+    m.insertBefore(document.createTextNode(str), m.firstChild)
+}
+
+function showButtons() {
+    var b = document.getElementById("buttons")
+    while (b.firstChild) {
+        b.removeChild(b.firstChild)
+    }
+    keys.forEach(function(k) {
+        var btn = document.createElement("input")
+        btn.type = "button"
+        btn.value = k.replace(/^KEY_/, "")
+        btn.onclick = function() { sendKey(k, 0) }
+        b.appendChild(btn)
+    })
+}
+        </script>
+    </head>
+    <body>
+    <h3>Mode: <span id="mode"></span></h3>
+    <span id="buttons"></span>
+    <hr />
+    <span id="messages"></span>
+    </body>
+</html>
+`))
@@ -0,0 +1,116 @@
+package webremote
+
+import "time"
+import "strings"
+import "testing"
+import "net/http"
+import "io/ioutil"
+import "net/http/httptest"
+
+import "golang.org/x/net/websocket"
+
+import "github.com/cnf/go-claw/listeners"
+import "github.com/cnf/go-claw/modes"
+
+func Test_Index(t *testing.T) {
+    l, _ := Create(map[string]string{})
+    srv := httptest.NewServer(l.(*WebRemote).handler())
+    defer srv.Close()
+
+    var tests = []struct {
+        url string
+        status int
+    }{
+        {"/", http.StatusOK},
+        {"/remote.html", http.StatusNotFound},
+    }
+    for _, tc := range tests {
+        resp, err := http.Get(srv.URL + tc.url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        body, _ := ioutil.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != tc.status {
+            t.Errorf("%s: expected status %d, got %d", tc.url, tc.status, resp.StatusCode)
+        }
+        if (tc.status == http.StatusOK) && !strings.Contains(string(body), "Claw web remote") {
+            t.Errorf("%s: unexpected page", tc.url)
+        }
+    }
+}
+
+func Test_Websocket(t *testing.T) {
+    m := &modes.Modes{}
+    m.Setup(map[string]*modes.Mode{"default": &modes.Mode{
+        Keys: map[string]*modes.Binding{"KEY_OK": modes.NewBinding(modes.NewActions("TV::select"))},
+    }})
+    l, _ := Create(map[string]string{"source": "phone", "origins": "tablet.lan:8000"})
+    wr := l.(*WebRemote)
+    wr.SetModes(m)
+    wr.cs = listeners.NewCommandStream()
+    srv := httptest.NewServer(wr.handler())
+    defer srv.Close()
+    host := strings.TrimPrefix(srv.URL, "http://")
+
+    dial := func(origin string) (*websocket.Conn, error) {
+        config, err := websocket.NewConfig("ws://" + host + "/sock", origin)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return websocket.DialConfig(config)
+    }
+
+    // Other web sites can not connect
+    if _, err := dial("http://evil.example.com"); err == nil {
+        t.Errorf("expected a websocket from another origin to be refused")
+    }
+
+    for _, origin := range []string{srv.URL, "http://tablet.lan:8000"} {
+        conn, err := dial(origin)
+        if err != nil {
+            t.Errorf("%s: %s", origin, err)
+            continue
+        }
+        var lay layout
+        if err := websocket.JSON.Receive(conn, &lay); err != nil {
+            t.Fatal(err)
+        }
+        if (lay.Mode != "default") || (strings.Join(lay.Keys, ",") != "KEY_OK") {
+            t.Errorf("%s: unexpected layout %+v", origin, lay)
+        }
+        websocket.JSON.Send(conn, &press{Key: "KEY_OK", Repeat: 2})
+        rc := <- wr.cs.Ch
+        if (rc.Key != "KEY_OK") || (rc.Repeat != 2) || (rc.Source != "phone") {
+            t.Errorf("%s: unexpected command %v", origin, rc)
+        }
+        conn.Close()
+    }
+
+    // Stopping closes the open websockets, also while a key is waiting to be
+    // read
+    conn, err := dial(srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    var lay layout
+    websocket.JSON.Receive(conn, &lay)
+    websocket.JSON.Send(conn, &press{Key: "KEY_OK"})
+    time.Sleep(20 * time.Millisecond)
+    wr.StopListener()
+    conn.SetReadDeadline(time.Now().Add(time.Second))
+    if err := websocket.JSON.Receive(conn, &lay); err == nil {
+        t.Errorf("expected the websocket to be closed")
+    }
+    open := -1
+    for i := 0; (i < 100) && (open != 0); i++ {
+        time.Sleep(10 * time.Millisecond)
+        wr.mu.Lock()
+        open = len(wr.conns)
+        wr.mu.Unlock()
+    }
+    if open != 0 {
+        t.Errorf("expected the websocket handlers to return, %d still open", open)
+    }
+}
@@ -0,0 +1,229 @@
+package modes
+
+import "fmt"
+import "strconv"
+import "encoding/json"
+
+// Actions is a list of actions, run one after the other. An element can
+// also be a list, a parallel block: its elements run at the same time, and
+// the actions after the block wait for all of them. The elements of a
+// parallel block are single actions, or lists of actions run one after the
+// other:
+//
+//   ["claw::mode tv", ["TV::poweron", ["AVR::power on", "AVR::input dvd"]], "claw::sleep 2s", "TV::input hdmi1"]
+//
+// A single action can also be an object, with what to do when it fails:
+//
+//   {"action": "Plex::play", "onerror": {"retry": 2}}
+//
+// or a conditional action, running one of two lists:
+//
+//   {"if": "Plex::state == playing", "then": ["Plex::pause"], "else": ["Plex::select"]}
+//
+// A plain list of actions unmarshals like it always did.
+type Actions []Action
+
+// Action is a single action, like "AVR::volumeup", a parallel block, or a
+// conditional action
+type Action struct {
+    Command string
+    Parallel []Actions
+    // What to do when the action fails, overriding the list
+    OnError *OnError
+    Conditional *Conditional
+}
+
+// Conditional runs Then when its condition holds, and Else otherwise. The
+// conditions are evaluated when the action runs, by the dispatcher.
+type Conditional struct {
+    If string `json:"if"`
+    Then Actions `json:"then,omitempty"`
+    Else Actions `json:"else,omitempty"`
+}
+
+// NewActions returns a list of single actions
+func NewActions(commands ...string) Actions {
+    if commands == nil {
+        return nil
+    }
+    ret := make(Actions, len(commands))
+    for i, c := range commands {
+        ret[i].Command = c
+    }
+    return ret
+}
+
+// UnmarshalJSON accepts a string or an object for a single action, or a
+// list for a parallel block
+func (a *Action) UnmarshalJSON(data []byte) error {
+    if err := json.Unmarshal(data, &a.Command); err == nil {
+        return nil
+    }
+    var single struct {
+        Action *string
+        OnError *OnError
+        If *string
+        Then Actions
+        Else Actions
+    }
+    if err := json.Unmarshal(data, &single); err == nil {
+        if single.If != nil {
+            if (single.Action != nil) || (single.OnError != nil) {
+                return fmt.Errorf("a conditional action can not have an `action` or `onerror`")
+            }
+            a.Conditional = &Conditional{*single.If, single.Then, single.Else}
+            return nil
+        }
+        if single.Action == nil {
+            return fmt.Errorf("an action object needs an `action` or an `if`")
+        }
+        a.Command, a.OnError = *single.Action, single.OnError
+        return nil
+    } else if _, ok := err.(*json.UnmarshalTypeError); !ok {
+        return err
+    }
+    var block []json.RawMessage
+    if err := json.Unmarshal(data, &block); err != nil {
+        return fmt.Errorf("an action must be a string, an object, or a list of actions run in parallel")
+    }
+    a.Parallel = make([]Actions, len(block))
+    for i, raw := range block {
+        if (len(raw) > 0) && (raw[0] == '[') {
+            if err := json.Unmarshal(raw, &a.Parallel[i]); err != nil {
+                return err
+            }
+            continue
+        }
+        a.Parallel[i] = make(Actions, 1)
+        if err := json.Unmarshal(raw, &a.Parallel[i][0]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// MarshalJSON writes actions the way they are read
+func (a Action) MarshalJSON() ([]byte, error) {
+    if a.Conditional != nil {
+        return json.Marshal(a.Conditional)
+    }
+    if a.OnError != nil {
+        return json.Marshal(struct{
+            Action string `json:"action"`
+            OnError *OnError `json:"onerror"`
+        }{a.Command, a.OnError})
+    }
+    if a.Parallel == nil {
+        return json.Marshal(a.Command)
+    }
+    block := make([]interface{}, len(a.Parallel))
+    for i, p := range a.Parallel {
+        if (len(p) == 1) && (p[0].Parallel == nil) {
+            block[i] = p[0]
+        } else {
+            block[i] = p
+        }
+    }
+    return json.Marshal(block)
+}
+
+// Map returns a copy of the actions with every command replaced by the
+// result of fn, which also gets the index path of the command in the
+// config, like "2/0". Fallback and conditional actions are included.
+func (a Actions) Map(fn func(index, command string) string) Actions {
+    return a.mapPath("", mapper{command: fn})
+}
+
+// MapConditions returns a copy of the actions with the condition of every
+// conditional action replaced by the result of fn, which also gets the
+// index path of the condition, like "2/if"
+func (a Actions) MapConditions(fn func(index, condition string) string) Actions {
+    return a.mapPath("", mapper{condition: fn})
+}
+
+// mapper replaces commands or conditions
+type mapper struct {
+    command func(index, command string) string
+    condition func(index, condition string) string
+}
+
+func (a Actions) mapPath(prefix string, m mapper) Actions {
+    if a == nil {
+        return nil
+    }
+    ret := make(Actions, len(a))
+    for i, act := range a {
+        ret[i] = act.mapIndex(prefix + strconv.Itoa(i), m)
+    }
+    return ret
+}
+
+func (a Action) mapIndex(index string, m mapper) Action {
+    if a.Conditional != nil {
+        c := *a.Conditional
+        if m.condition != nil {
+            c.If = m.condition(index + "/if", c.If)
+        }
+        c.Then = c.Then.mapPath(index + "/then/", m)
+        c.Else = c.Else.mapPath(index + "/else/", m)
+        a.Conditional = &c
+        return a
+    }
+    if a.Parallel == nil {
+        if m.command != nil {
+            a.Command = m.command(index, a.Command)
+        }
+        if a.OnError != nil {
+            onerror := *a.OnError
+            onerror.Fallback = onerror.Fallback.mapPath(index + "/onerror/fallback/", m)
+            a.OnError = &onerror
+        }
+        return a
+    }
+    block := make([]Actions, len(a.Parallel))
+    for j, p := range a.Parallel {
+        if (len(p) == 1) && (p[0].Parallel == nil) {
+            // A single action in a block has no list of its own
+            block[j] = Actions{p[0].mapIndex(index + "/" + strconv.Itoa(j), m)}
+        } else {
+            block[j] = p.mapPath(index + "/" + strconv.Itoa(j) + "/", m)
+        }
+    }
+    a.Parallel = block
+    return a
+}
+
+// WithPolicy returns a copy of the actions, where the ones without an
+// OnError of their own get onerror
+func (a Actions) WithPolicy(onerror *OnError) Actions {
+    if (onerror == nil) || (a == nil) {
+        return a
+    }
+    ret := make(Actions, len(a))
+    for i, act := range a {
+        ret[i] = act
+        if act.Conditional != nil {
+            c := *act.Conditional
+            c.Then, c.Else = c.Then.WithPolicy(onerror), c.Else.WithPolicy(onerror)
+            ret[i].Conditional = &c
+        } else if act.Parallel != nil {
+            ret[i].Parallel = make([]Actions, len(act.Parallel))
+            for j, p := range act.Parallel {
+                ret[i].Parallel[j] = p.WithPolicy(onerror)
+            }
+        } else if act.OnError == nil {
+            ret[i].OnError = onerror
+        }
+    }
+    return ret
+}
+
+// Commands returns all commands in the actions, in order
+func (a Actions) Commands() []string {
+    var ret []string
+    a.Map(func(index, command string) string {
+        ret = append(ret, command)
+        return command
+    })
+    return ret
+}
@@ -0,0 +1,136 @@
+package modes
+
+import "fmt"
+import "time"
+import "encoding/json"
+
+// Default gesture thresholds
+const (
+    DefaultLongPress = 800 * time.Millisecond
+    DefaultDoublePress = 400 * time.Millisecond
+)
+
+// Binding holds the actions bound to a key, for each key event. A plain
+// list of actions in the config runs on both press and hold, like every
+// repeat of a key used to.
+//
+// When Long or Double actions are bound, a short press only runs after
+// the key is released, or after the double press time passed.
+type Binding struct {
+    Press Actions
+    Hold Actions
+    Release Actions
+    Long Actions
+    Double Actions
+
+    // Gesture thresholds, overriding the ones of the mode
+    LongPress string
+    DoublePress string
+    // Repeat policy, overriding the one of the mode
+    Repeat *Repeat
+    // Error policy, overriding the one of the mode
+    OnError *OnError
+
+    longtime time.Duration
+    doubletime time.Duration
+    repeat *Repeat
+    onerror *OnError
+}
+
+// NewBinding returns a binding running actions on press and hold, like a
+// plain list of actions in the config
+func NewBinding(actions Actions) *Binding {
+    return &Binding{Press: actions, Hold: actions}
+}
+
+// UnmarshalJSON accepts either a list of actions, or an object with
+// "press", "hold" and "release" action lists
+func (b *Binding) UnmarshalJSON(data []byte) error {
+    var list Actions
+    if err := json.Unmarshal(data, &list); err == nil {
+        b.Press = list
+        b.Hold = list
+        return nil
+    }
+    type binding Binding
+    var tmp binding
+    if err := json.Unmarshal(data, &tmp); err != nil {
+        return err
+    }
+    *b = Binding(tmp)
+    return nil
+}
+
+// Actions returns the actions for a key event: press, hold or release
+func (b *Binding) Actions(event string) Actions {
+    switch event {
+    case "press":
+        return b.Press
+    case "hold":
+        return b.Hold
+    case "release":
+        return b.Release
+    case "long":
+        return b.Long
+    case "double":
+        return b.Double
+    }
+    return nil
+}
+
+// HasGestures returns true if long or double press actions are bound
+func (b *Binding) HasGestures() bool {
+    return (len(b.Long) > 0) || (len(b.Double) > 0)
+}
+
+// LongTime returns how long a key has to be held for a long press
+func (b *Binding) LongTime() time.Duration {
+    return b.longtime
+}
+
+// DoubleTime returns the maximum time between the presses of a double press
+func (b *Binding) DoubleTime() time.Duration {
+    return b.doubletime
+}
+
+// RepeatPolicy returns the repeat policy for the hold actions, or nil if
+// every repeat should run them
+func (b *Binding) RepeatPolicy() *Repeat {
+    return b.repeat
+}
+
+// ErrorPolicy returns what to do when one of the actions fails, or nil to
+// go on with the rest
+func (b *Binding) ErrorPolicy() *OnError {
+    return b.onerror
+}
+
+// setup parses the gesture thresholds and repeat policy, falling back to
+// the mode ones
+func (b *Binding) setup(longtime, doubletime time.Duration, repeat *Repeat, onerror *OnError) error {
+    var err error
+    b.onerror = onerror
+    if b.OnError != nil {
+        b.onerror = b.OnError
+    }
+    b.repeat = repeat
+    if b.Repeat != nil {
+        if err = b.Repeat.setup(); err != nil {
+            return err
+        }
+        b.repeat = b.Repeat
+    }
+    b.longtime = longtime
+    if b.LongPress != "" {
+        if b.longtime, err = time.ParseDuration(b.LongPress); err != nil {
+            return fmt.Errorf("invalid longpress: %s", err)
+        }
+    }
+    b.doubletime = doubletime
+    if b.DoublePress != "" {
+        if b.doubletime, err = time.ParseDuration(b.DoublePress); err != nil {
+            return fmt.Errorf("invalid doublepress: %s", err)
+        }
+    }
+    return nil
+}
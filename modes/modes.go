@@ -1,14 +1,65 @@
 package modes
 
 import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "strings"
 
 import "github.com/cnf/go-claw/clog"
 
 // Mode holds the data for a single mode
 type Mode struct {
-    Keys map[string][]string
-    Entry []string
-    Exit []string
+    Keys map[string]*Binding
+    Entry Actions
+    Exit Actions
+
+    // Default gesture thresholds for the keys in this mode, like "800ms"
+    LongPress string
+    DoublePress string
+
+    // Maximum time between the keys of a key sequence, like "KEY_1 KEY_2"
+    SequenceTimeout string
+    // Collects digit keys into a number, passed to actions as {digits}
+    Digits *Digits
+    // Default repeat policy for the keys in this mode
+    Repeat *Repeat
+    // Default error policy for the actions in this mode
+    OnError *OnError
+    // Replaces a mode of the same name from an earlier config file
+    Override bool
+
+    seqtime time.Duration
+    longtime time.Duration
+    doubletime time.Duration
+}
+
+// Digits configures the numeric entry buffer of a mode
+type Digits struct {
+    Actions Actions
+    // Time after the last digit before the actions run
+    Timeout string
+    // Key that runs the actions right away
+    Enter string
+    // Maximum number of digits, the actions run when it is reached
+    Max int
+
+    timeout time.Duration
+    onerror *OnError
+}
+
+// DefaultSequenceTimeout is the default maximum time between sequence keys
+const DefaultSequenceTimeout = 1500 * time.Millisecond
+
+// TimeoutDuration returns the time after the last digit before the
+// actions run
+func (d *Digits) TimeoutDuration() time.Duration {
+    return d.timeout
+}
+
+// ErrorPolicy returns the error policy of the mode, for the actions
+func (d *Digits) ErrorPolicy() *OnError {
+    return d.onerror
 }
 
 // Modes holds all the modes data
@@ -17,42 +68,310 @@ type Modes struct {
     active *Mode
     def *Mode
     ModeMap map[string]*Mode
+    mu sync.Mutex
+    watchers []chan string
 }
 
 // ActionsFor returns a list of actions for a specific key
-func (m *Modes) ActionsFor(key string) ([]string, error) {
-    var actions []string
+func (m *Modes) ActionsFor(key string) (Actions, error) {
+    return m.ActionsForEvent(key, "press")
+}
+
+// ActionsForEvent returns a list of actions for a key press, hold or release
+func (m *Modes) ActionsForEvent(key, event string) (Actions, error) {
+    binding, err := m.BindingFor(key)
+    if err != nil {
+        return nil, err
+    }
+    return binding.Actions(event), nil
+}
+
+// BindingFor returns the binding of a key in the active or default mode
+func (m *Modes) BindingFor(key string) (*Binding, error) {
+    return m.BindingFrom("", "", key)
+}
+
+// QualifiedKeys returns the names a key from a listener instance and source
+// can be bound to, most specific first: "listener:source/KEY",
+// "source/KEY", "listener:KEY" and "KEY"
+func QualifiedKeys(listener, source, key string) []string {
+    var keys []string
+    if (listener != "") && (source != "") {
+        keys = append(keys, listener + ":" + source + "/" + key)
+    }
+    if source != "" {
+        keys = append(keys, source + "/" + key)
+    }
+    if listener != "" {
+        keys = append(keys, listener + ":" + key)
+    }
+    return append(keys, key)
+}
+
+// BindingFrom returns the binding of a key sent by a source on a listener
+// instance in the active or default mode. Bindings qualified with the
+// listener or source win over the plain key within a mode.
+func (m *Modes) BindingFrom(listener, source, key string) (*Binding, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
     if (m.active == nil) && (m.def == nil) {
         return nil, fmt.Errorf("no modes found")
     }
-    if m.active != nil && m.active.Keys[key] != nil {
-        actions = m.active.Keys[key]
-    } else if m.def.Keys[key] != nil {
-        actions = m.def.Keys[key]
-    } else {
-        return nil, fmt.Errorf("key `%s` not found", key)
+    names := QualifiedKeys(listener, source, key)
+    for _, md := range []*Mode{m.active, m.def} {
+        if md == nil {
+            continue
+        }
+        for _, k := range names {
+            if md.Keys[k] != nil {
+                return md.Keys[k], nil
+            }
+        }
     }
-    return actions, nil
+    return nil, fmt.Errorf("key `%s` not found", key)
+}
+
+// UnqualifiedKey strips the listener and source qualifiers from a key name
+func UnqualifiedKey(key string) string {
+    if i := strings.LastIndex(key, "/"); i >= 0 {
+        return key[i+1:]
+    }
+    if i := strings.Index(key, ":"); i >= 0 {
+        return key[i+1:]
+    }
+    return key
+}
+
+// IsDigitKey returns true for the keys KEY_0 to KEY_9, which go to the
+// numeric entry buffer
+func IsDigitKey(key string) bool {
+    return (len(key) == 5) && strings.HasPrefix(key, "KEY_") && (key[4] >= '0') && (key[4] <= '9')
+}
+
+// PressedKey is a key of a sequence, with the listener instance and source
+// that sent it
+type PressedKey struct {
+    Listener string
+    Source string
+    Key string
+}
+
+// matchKey returns how specific the name of a key in a sequence is for a
+// pressed key, in the order of QualifiedKeys, or -1 if it does not match
+func matchKey(name string, key PressedKey) int {
+    for i, k := range QualifiedKeys(key.Listener, key.Source, key.Key) {
+        if k == name {
+            return i
+        }
+    }
+    return -1
+}
+
+// MatchSequence checks a list of pressed keys against the key sequences of
+// the active and default mode. It returns the binding of the sequence the
+// keys form, if any, and if a longer sequence starts with these keys. Like
+// BindingFrom, sequences of qualified keys win over plain keys within a
+// mode.
+func (m *Modes) MatchSequence(keys []PressedKey) (*Binding, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var binding *Binding
+    longer := false
+    for _, md := range []*Mode{m.active, m.def} {
+        if md == nil {
+            continue
+        }
+        best, bestseq := -1, ""
+        for seq := range md.Keys {
+            names := strings.Fields(seq)
+            if len(names) < len(keys) {
+                continue
+            }
+            score := 0
+            for i := range keys {
+                s := matchKey(names[i], keys[i])
+                if s < 0 {
+                    score = -1
+                    break
+                }
+                score += s
+            }
+            if score < 0 {
+                continue
+            }
+            if len(names) > len(keys) {
+                longer = true
+                continue
+            }
+            if (binding != nil) || (len(keys) < 2) {
+                continue
+            }
+            if (best < 0) || (score < best) || ((score == best) && (seq < bestseq)) {
+                best, bestseq = score, seq
+            }
+        }
+        if (binding == nil) && (best >= 0) {
+            binding = md.Keys[bestseq]
+        }
+    }
+    return binding, longer
+}
+
+// SequenceTimeout returns the maximum time between the keys of a sequence
+func (m *Modes) SequenceTimeout() time.Duration {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if (m.active != nil) && (m.active.seqtime != 0) {
+        return m.active.seqtime
+    }
+    if (m.def != nil) && (m.def.seqtime != 0) {
+        return m.def.seqtime
+    }
+    return DefaultSequenceTimeout
+}
+
+// Digits returns the numeric entry settings of the active or default mode
+func (m *Modes) Digits() *Digits {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if (m.active != nil) && (m.active.Digits != nil) {
+        return m.active.Digits
+    }
+    if m.def != nil {
+        return m.def.Digits
+    }
+    return nil
 }
 
 // SetActive text
-func (m *Modes) SetActive(mode string) ([]string, error) {
-    var actions []string
+func (m *Modes) SetActive(mode string) (Actions, error) {
+    var actions Actions
+    m.mu.Lock()
+    defer m.mu.Unlock()
     if m.ModeMap[mode] == nil {
         return actions, fmt.Errorf("no such mode found: %s", mode)
     }
     if m.active != nil {
-        actions = append(actions, m.active.Exit...)
+        actions = append(actions, m.active.Exit.WithPolicy(m.active.OnError)...)
     }
     m.active = m.ModeMap[mode]
     m.name = mode
-    actions = append(actions, m.active.Entry...)
+    actions = append(actions, m.active.Entry.WithPolicy(m.active.OnError)...)
 
     clog.Debug("Modes: `%s` is now active", mode)
+    for _, w := range m.watchers {
+        // Don't block on slow watchers
+        select {
+        case w <- mode:
+        default:
+        }
+    }
 
     return actions, nil
 }
 
+// ActiveName returns the name of the active mode, "default" until a mode is
+// set
+func (m *Modes) ActiveName() string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.activeName()
+}
+
+func (m *Modes) activeName() string {
+    if m.name == "" {
+        return "default"
+    }
+    return m.name
+}
+
+// Keys returns the sorted list of keys defined in the active and default
+// mode, without listener or source qualifiers
+func (m *Modes) Keys() []string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    seen := make(map[string]bool)
+    var keys []string
+    for _, md := range []*Mode{m.active, m.def} {
+        if md == nil {
+            continue
+        }
+        for k := range md.Keys {
+            // Skip key sequences
+            if strings.Contains(k, " ") {
+                continue
+            }
+            k = UnqualifiedKey(k)
+            if !seen[k] {
+                seen[k] = true
+                keys = append(keys, k)
+            }
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Names returns the sorted names of the modes
+func (m *Modes) Names() []string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    names := make([]string, 0, len(m.ModeMap))
+    for name := range m.ModeMap {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Uses returns true if a key is bound in any mode, on its own, as part of a
+// key sequence or as a digit or enter key of a numeric entry buffer
+func (m *Modes) Uses(key string) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, md := range m.ModeMap {
+        for k := range md.Keys {
+            for _, f := range strings.Fields(k) {
+                if UnqualifiedKey(f) == key {
+                    return true
+                }
+            }
+        }
+        if md.Digits == nil {
+            continue
+        }
+        if key == md.Digits.Enter {
+            return true
+        }
+        if IsDigitKey(key) {
+            return true
+        }
+    }
+    return false
+}
+
+// Watch returns a channel receiving the name of every mode that becomes active
+func (m *Modes) Watch() chan string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    ch := make(chan string, 1)
+    m.watchers = append(m.watchers, ch)
+    return ch
+}
+
+// Unwatch stops sending mode changes to a channel returned by Watch
+func (m *Modes) Unwatch(ch chan string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i, w := range m.watchers {
+        if w == ch {
+            m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+            close(ch)
+            return
+        }
+    }
+}
+
 // Setup sets up a new mode structure
 func (m *Modes) Setup(modelist map[string]*Mode) error {
     m.ModeMap = make(map[string]*Mode)
@@ -71,9 +390,76 @@ func (m *Modes) Setup(modelist map[string]*Mode) error {
     return nil
 }
 
+// Reload replaces all modes with a new set, keeping the active mode if it
+// still exists. Nothing changes if the new modes can not be set up.
+func (m *Modes) Reload(modelist map[string]*Mode) error {
+    tmp := &Modes{}
+    if err := tmp.Setup(modelist); err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.ModeMap = tmp.ModeMap
+    m.def = tmp.def
+    m.active = nil
+    if md, ok := m.ModeMap[m.name]; ok {
+        m.active = md
+    } else if m.name != "" {
+        clog.Warn("Modes: active mode `%s` is gone, using the default mode", m.name)
+        m.name = ""
+    }
+    // Let watchers know the keys may have changed
+    for _, w := range m.watchers {
+        select {
+        case w <- m.activeName():
+        default:
+        }
+    }
+    return nil
+}
+
 // AddMode adds a mode to the list
 func (m *Modes) AddMode(name string, mode *Mode) error {
     clog.Info("Setting up mode: %s", name)
+    var err error
+    mode.longtime, mode.doubletime = DefaultLongPress, DefaultDoublePress
+    if mode.LongPress != "" {
+        if mode.longtime, err = time.ParseDuration(mode.LongPress); err != nil {
+            return fmt.Errorf("mode `%s`: invalid longpress: %s", name, err)
+        }
+    }
+    if mode.DoublePress != "" {
+        if mode.doubletime, err = time.ParseDuration(mode.DoublePress); err != nil {
+            return fmt.Errorf("mode `%s`: invalid doublepress: %s", name, err)
+        }
+    }
+    if mode.SequenceTimeout != "" {
+        if mode.seqtime, err = time.ParseDuration(mode.SequenceTimeout); err != nil {
+            return fmt.Errorf("mode `%s`: invalid sequencetimeout: %s", name, err)
+        }
+    }
+    if mode.Digits != nil {
+        mode.Digits.onerror = mode.OnError
+        mode.Digits.timeout = DefaultSequenceTimeout
+        if mode.Digits.Timeout != "" {
+            if mode.Digits.timeout, err = time.ParseDuration(mode.Digits.Timeout); err != nil {
+                return fmt.Errorf("mode `%s`: invalid digits timeout: %s", name, err)
+            }
+        }
+    }
+    if mode.Repeat != nil {
+        if err = mode.Repeat.setup(); err != nil {
+            return fmt.Errorf("mode `%s`: %s", name, err)
+        }
+    }
+    for k, b := range mode.Keys {
+        if b == nil {
+            continue
+        }
+        if err := b.setup(mode.longtime, mode.doubletime, mode.Repeat, mode.OnError); err != nil {
+            return fmt.Errorf("mode `%s`, key `%s`: %s", name, k, err)
+        }
+    }
     // m.ModeMap[name] = &Mode{Keys: mode.Keys, entry: mode.entry, exit: mode.exit}
     m.ModeMap[name] = mode
     if name == "default" {
@@ -82,6 +468,24 @@ func (m *Modes) AddMode(name string, mode *Mode) error {
     return nil
 }
 
+// Bind binds a key in a mode to a binding, replacing any existing one
+func (m *Modes) Bind(name, key string, b *Binding) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    mode := m.ModeMap[name]
+    if mode == nil {
+        return fmt.Errorf("no such mode found: %s", name)
+    }
+    if err := b.setup(mode.longtime, mode.doubletime, mode.Repeat, mode.OnError); err != nil {
+        return fmt.Errorf("mode `%s`, key `%s`: %s", name, key, err)
+    }
+    if mode.Keys == nil {
+        mode.Keys = make(map[string]*Binding)
+    }
+    mode.Keys[key] = b
+    return nil
+}
+
 // DelMode removes a mode from the list
 func (m *Modes) DelMode(name string) error {
     if name == "default" {
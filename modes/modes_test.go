@@ -0,0 +1,61 @@
+package modes
+
+import "strings"
+import "testing"
+
+func Test_MatchSequence(t *testing.T) {
+    m := &Modes{}
+    err := m.Setup(map[string]*Mode{"default": &Mode{
+        Keys: map[string]*Binding{
+            "KEY_RED KEY_GREEN": NewBinding(NewActions("plain")),
+            "kids/KEY_RED kids/KEY_GREEN": NewBinding(NewActions("kids")),
+            "lirc:KEY_RED KEY_BLUE": NewBinding(NewActions("lirc")),
+            "KEY_1 KEY_2 KEY_3": NewBinding(NewActions("pin")),
+        },
+    }})
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := func(listener, source, key string) PressedKey {
+        return PressedKey{Listener: listener, Source: source, Key: key}
+    }
+    tests := []struct {
+        keys []PressedKey
+        binding string
+        longer bool
+    }{
+        {[]PressedKey{key("lirc", "kids", "KEY_RED")}, "", true},
+        {[]PressedKey{key("lirc", "kids", "KEY_RED"), key("lirc", "kids", "KEY_GREEN")}, "kids", false},
+        {[]PressedKey{key("lirc", "tv", "KEY_RED"), key("lirc", "tv", "KEY_GREEN")}, "plain", false},
+        {[]PressedKey{key("lirc", "tv", "KEY_RED"), key("lirc", "tv", "KEY_BLUE")}, "lirc", false},
+        {[]PressedKey{key("evdev", "tv", "KEY_RED"), key("evdev", "tv", "KEY_BLUE")}, "", false},
+        {[]PressedKey{key("", "", "KEY_1"), key("", "", "KEY_2")}, "", true},
+        {[]PressedKey{key("", "", "KEY_GREEN")}, "", false},
+    }
+    for _, test := range tests {
+        b, longer := m.MatchSequence(test.keys)
+        got := ""
+        if b != nil {
+            got = strings.Join(b.Press.Commands(), ",")
+        }
+        if (got != test.binding) || (longer != test.longer) {
+            t.Errorf("%v: expected `%s` %t, got `%s` %t", test.keys, test.binding, test.longer, got, longer)
+        }
+    }
+}
+
+func Test_Names(t *testing.T) {
+    m := &Modes{}
+    if err := m.Setup(map[string]*Mode{"default": {}, "tv": {}, "radio": {}}); err != nil {
+        t.Fatal(err)
+    }
+    done := make(chan bool)
+    go func() {
+        defer close(done)
+        m.Reload(map[string]*Mode{"default": {}, "tv": {}, "radio": {}})
+    }()
+    if names := strings.Join(m.Names(), ","); names != "default,radio,tv" {
+        t.Errorf("expected the sorted mode names, got %s", names)
+    }
+    <- done
+}
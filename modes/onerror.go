@@ -0,0 +1,73 @@
+package modes
+
+import "fmt"
+import "time"
+import "encoding/json"
+
+// DefaultBackoff is the time before the first retry of a failed action
+const DefaultBackoff = 500 * time.Millisecond
+
+// MaxBackoff is the longest time waited before a retry
+const MaxBackoff = time.Minute
+
+// OnError configures what happens when an action fails. It can be set for
+// a mode, a key, or a single action:
+//
+//   {"action": "Plex::play", "onerror": {"retry": 2, "backoff": "1s", "fallback": ["Plex::wake", "claw::sleep 5s", "Plex::play"]}}
+//
+// Without one, the rest of the list runs after a failed action. A
+// claw::wait that timed out always stops the rest, unless its fallback
+// recovers.
+type OnError struct {
+    // Stop the rest of the list, instead of continuing with the next action
+    Abort bool `json:"abort,omitempty"`
+    // Times a failed action is tried again, waiting Backoff before the
+    // first retry, and twice as long before every next one up to
+    // MaxBackoff
+    Retry int `json:"retry,omitempty"`
+    Backoff string `json:"backoff,omitempty"`
+    // Actions run when an action still fails after its retries, when none
+    // of them fail the list goes on like the action did not
+    Fallback Actions `json:"fallback,omitempty"`
+
+    backoff time.Duration
+}
+
+// UnmarshalJSON checks the retry count and backoff
+func (o *OnError) UnmarshalJSON(data []byte) error {
+    type onError OnError
+    var tmp onError
+    if err := json.Unmarshal(data, &tmp); err != nil {
+        return err
+    }
+    *o = OnError(tmp)
+    if o.Retry < 0 {
+        return fmt.Errorf("invalid onerror retry: %d", o.Retry)
+    }
+    if o.Backoff != "" {
+        d, err := time.ParseDuration(o.Backoff)
+        if err != nil {
+            return fmt.Errorf("invalid onerror backoff: %s", err)
+        }
+        o.backoff = d
+    }
+    return nil
+}
+
+// Delay returns the time to wait before a retry, starting at 1
+func (o *OnError) Delay(retry int) time.Duration {
+    d := o.backoff
+    if (d == 0) && (o.Backoff != "") {
+        d, _ = time.ParseDuration(o.Backoff)
+    }
+    if d <= 0 {
+        d = DefaultBackoff
+    }
+    for i := 1; (i < retry) && (d < MaxBackoff); i++ {
+        d *= 2
+    }
+    if d > MaxBackoff {
+        return MaxBackoff
+    }
+    return d
+}
@@ -0,0 +1,16 @@
+package modes
+
+import "time"
+import "testing"
+
+func Test_Delay(t *testing.T) {
+    o := &OnError{Backoff: "1s"}
+    for retry, expect := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 7: MaxBackoff, 100: MaxBackoff} {
+        if d := o.Delay(retry); d != expect {
+            t.Errorf("retry %d: expected %s, got %s", retry, expect, d)
+        }
+    }
+    if d := (&OnError{}).Delay(1); d != DefaultBackoff {
+        t.Errorf("expected the default backoff, got %s", d)
+    }
+}
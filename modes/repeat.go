@@ -0,0 +1,80 @@
+package modes
+
+import "fmt"
+import "sort"
+import "time"
+
+// Repeat configures what happens while a key is held
+type Repeat struct {
+    // Ignore all repeats
+    Ignore bool
+    // Only act on every Nth repeat
+    Every int
+    // Time the key has to be held before it starts repeating, like "500ms"
+    Delay string
+    // Steps taken per repeat, the longer the key is held
+    Accel []AccelStep
+
+    delay time.Duration
+}
+
+// AccelStep runs the hold actions Times times per repeat, once the key has
+// been held for After
+type AccelStep struct {
+    After string
+    Times int
+
+    after time.Duration
+}
+
+func (r *Repeat) setup() error {
+    var err error
+    if r.Delay != "" {
+        if r.delay, err = time.ParseDuration(r.Delay); err != nil {
+            return fmt.Errorf("invalid repeat delay: %s", err)
+        }
+    }
+    if r.Every < 0 {
+        return fmt.Errorf("invalid repeat every: %d", r.Every)
+    }
+    for i := range r.Accel {
+        if r.Accel[i].after, err = time.ParseDuration(r.Accel[i].After); err != nil {
+            return fmt.Errorf("invalid repeat accel after: %s", err)
+        }
+        if r.Accel[i].Times <= 0 {
+            return fmt.Errorf("invalid repeat accel times: %d", r.Accel[i].Times)
+        }
+    }
+    // Steps takes the last step the key has been held long enough for
+    sort.SliceStable(r.Accel, func(i, j int) bool {
+        return r.Accel[i].after < r.Accel[j].after
+    })
+    for i := 1; i < len(r.Accel); i++ {
+        if r.Accel[i].after == r.Accel[i-1].after {
+            return fmt.Errorf("duplicate repeat accel after: %s", r.Accel[i].After)
+        }
+    }
+    return nil
+}
+
+// Steps returns how many steps the hold actions should take for a repeat,
+// given the repeat count and how long the key has been held. Zero means
+// the repeat should be skipped.
+func (r *Repeat) Steps(repeat int, held time.Duration) int {
+    if r == nil {
+        return 1
+    }
+    if r.Ignore || (held < r.delay) {
+        return 0
+    }
+    if (r.Every > 1) && (repeat % r.Every != 0) {
+        return 0
+    }
+    steps := 1
+    for _, a := range r.Accel {
+        if held >= a.after {
+            steps = a.Times
+        }
+    }
+    return steps
+}
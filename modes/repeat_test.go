@@ -0,0 +1,84 @@
+package modes
+
+import "time"
+import "testing"
+import "encoding/json"
+
+func Test_RepeatSteps(t *testing.T) {
+    var r Repeat
+    err := json.Unmarshal([]byte(`{
+        "delay": "300ms",
+        "every": 2,
+        "accel": [{"after": "1s", "times": 2}, {"after": "2s", "times": 5}]
+    }`), &r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = r.setup(); err != nil {
+        t.Fatal(err)
+    }
+    ms := time.Millisecond
+    tests := []struct {
+        repeat int
+        held time.Duration
+        steps int
+    }{
+        {2, 100 * ms, 0},   // before the delay
+        {2, 300 * ms, 1},
+        {3, 500 * ms, 0},   // not every second repeat
+        {4, 1000 * ms, 2},
+        {6, 1500 * ms, 2},
+        {8, 2500 * ms, 5},
+    }
+    for _, test := range tests {
+        if steps := r.Steps(test.repeat, test.held); steps != test.steps {
+            t.Errorf("repeat %d held %s: expected %d steps, got %d", test.repeat, test.held, test.steps, steps)
+        }
+    }
+
+    // Accel steps apply in the order of their times, not of the config
+    r = Repeat{Accel: []AccelStep{{After: "3s", Times: 4}, {After: "1s", Times: 2}}}
+    if err = r.setup(); err != nil {
+        t.Fatal(err)
+    }
+    tests = []struct {
+        repeat int
+        held time.Duration
+        steps int
+    }{
+        {1, 500 * ms, 1},
+        {2, 1500 * ms, 2},
+        {3, 5000 * ms, 4},
+    }
+    for _, test := range tests {
+        if steps := r.Steps(test.repeat, test.held); steps != test.steps {
+            t.Errorf("unsorted accel: repeat %d held %s: expected %d steps, got %d", test.repeat, test.held, test.steps, steps)
+        }
+    }
+
+    if steps := (&Repeat{Ignore: true}).Steps(1, time.Second); steps != 0 {
+        t.Errorf("expected ignored repeats to be skipped, got %d steps", steps)
+    }
+    var none *Repeat
+    if steps := none.Steps(5, 0); steps != 1 {
+        t.Errorf("expected 1 step without a repeat policy, got %d", steps)
+    }
+
+    bad := []string{
+        `{"delay": "soon"}`,
+        `{"accel": [{"after": "1s", "times": 0}]}`,
+        `{"accel": [{"after": "1s", "times": -2}]}`,
+        `{"accel": [{"after": "later", "times": 2}]}`,
+        `{"accel": [{"after": "1s", "times": 2}, {"after": "1000ms", "times": 3}]}`,
+    }
+    for _, data := range bad {
+        var r Repeat
+        err := json.Unmarshal([]byte(data), &r)
+        if err == nil {
+            err = r.setup()
+        }
+        if err == nil {
+            t.Errorf("%s: expected an error", data)
+        }
+    }
+}
@@ -1,7 +1,17 @@
 package main
 
 import "github.com/cnf/go-claw/listeners/lircsocket"
+import "github.com/cnf/go-claw/listeners/evdev"
+import "github.com/cnf/go-claw/listeners/lircdev"
+import "github.com/cnf/go-claw/listeners/httplistener"
+import "github.com/cnf/go-claw/listeners/webremote"
+import "github.com/cnf/go-claw/listeners/cec"
 
 func registerAllListeners() {
     lircsocket.Register()
+    evdev.Register()
+    lircdev.Register()
+    httplistener.Register()
+    webremote.Register()
+    cec.Register()
 }
@@ -4,10 +4,12 @@ import "github.com/cnf/go-claw/targets/denon"
 import "github.com/cnf/go-claw/targets/plex"
 import "github.com/cnf/go-claw/targets/linux"
 import "github.com/cnf/go-claw/targets/onkyo"
+import "github.com/cnf/go-claw/targets/cec"
 
 func registerAllTargets() {
     denon.Register()
     plex.Register()
     linux.Register()
     onkyo.Register()
+    cec.Register()
 }
@@ -0,0 +1,363 @@
+package targets
+
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+
+import "github.com/cnf/go-claw/clog"
+import "github.com/cnf/go-claw/modes"
+
+// StateReader is implemented by targets that can report their state, like
+// "power" being "on", for claw::wait
+type StateReader interface {
+    State(name string) (string, error)
+}
+
+// waitError is returned by a claw::wait that timed out, it stops the list
+// of actions it is in unless the fallback of its error policy recovers
+type waitError struct {
+    msg string
+}
+
+func (e *waitError) Error() string {
+    return e.msg
+}
+
+// abortOnError runs fallback actions, they stop at the first one failing
+var abortOnError = &modes.OnError{Abort: true}
+
+// Conditions evaluates the conditions of conditional actions
+type Conditions interface {
+    Eval(condition string) (bool, error)
+}
+
+// SetConditions sets the conditions used by actions run without their own,
+// like the entry and exit actions of modes
+func (t *TargetManager) SetConditions(c Conditions) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.conditions = c
+}
+
+// RunActions runs a list of actions in the background. Commands for
+// different targets do not wait for each other, while the commands for a
+// target run in order, also across lists: a list takes its turn on the
+// targets it uses when RunActions is called, a list setting a mode on all
+// targets until the mode is set. Claw commands, conditional actions,
+// parallel blocks and actions with an error policy wait for the actions
+// before them, and the actions after them wait for them.
+// Conditional actions are evaluated with cond, or the conditions set when
+// it is nil. A failed action is handled by its error policy, the results
+// are logged in a single report on what.
+func (t *TargetManager) RunActions(what string, actions modes.Actions, cond Conditions) {
+    t.RunBatches(what, []modes.Actions{actions}, cond)
+}
+
+// RunBatches runs lists of actions in the background like RunActions, each
+// list on its own, and logs the results of all of them in a single report on
+// what when they are done
+func (t *TargetManager) RunBatches(what string, batches []modes.Actions, cond Conditions) {
+    b := t.newBatch(what, batches, cond)
+    if b == nil {
+        return
+    }
+    go func() {
+        b.run().log()
+    }()
+}
+
+// batch holds the runs of several lists of actions sharing a report
+type batch struct {
+    runs []*actionRun
+    lists []modes.Actions
+    report *Report
+}
+
+// newBatch creates the runs of lists of actions, taking their turns in
+// order. Lists whose macros can not be expanded are left out, it returns nil
+// when nothing is left to run.
+func (t *TargetManager) newBatch(what string, batches []modes.Actions, cond Conditions) *batch {
+    b := &batch{report: &Report{What: what, Start: time.Now()}}
+    for _, actions := range batches {
+        if len(actions) == 0 {
+            continue
+        }
+        expanded, err := t.ExpandMacros(actions)
+        if err != nil {
+            clog.Warn("Actions: %s: %v not run: %s", what, actions.Commands(), err.Error())
+            continue
+        }
+        r := t.newRun(what, expanded, cond)
+        r.report = b.report
+        b.runs = append(b.runs, r)
+        b.lists = append(b.lists, expanded)
+    }
+    if len(b.runs) == 0 {
+        return nil
+    }
+    return b
+}
+
+// run runs the lists of the batch at the same time, and returns their report
+func (b *batch) run() *Report {
+    var wg sync.WaitGroup
+    for i := range b.runs {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            b.runs[i].run(b.lists[i])
+        }(i)
+    }
+    wg.Wait()
+    return b.report
+}
+
+// actionRun holds what a list of actions run needs, modes holds the modes
+// whose entry actions are running
+type actionRun struct {
+    t *TargetManager
+    cond Conditions
+    report *Report
+    turns *turns
+    modes []string
+}
+
+// newRun creates a run of actions, taking its turn on their targets
+func (t *TargetManager) newRun(what string, actions modes.Actions, cond Conditions) *actionRun {
+    if cond == nil {
+        t.mu.RLock()
+        cond = t.conditions
+        t.mu.RUnlock()
+    }
+    return &actionRun{t, cond, &Report{What: what, Start: time.Now()}, t.takeTurns(actions), nil}
+}
+
+// run runs actions right away and returns their report
+func (t *TargetManager) run(what string, actions modes.Actions, cond Conditions) *Report {
+    return t.newRun(what, actions, cond).run(actions)
+}
+
+// run runs the actions of the run, and returns their report
+func (r *actionRun) run(actions modes.Actions) *Report {
+    err := r.sequence(actions, true)
+    r.turns.releaseAll()
+    r.report.finish(err != nil)
+    return r.report
+}
+
+// pending is a command queued without waiting for its result
+type pending struct {
+    command string
+    result <-chan error
+}
+
+// sequence runs actions one after the other, see RunActions. With release
+// set the targets are released once no action after it uses them. It
+// returns an error when a failed action stopped the rest.
+func (r *actionRun) sequence(actions modes.Actions, release bool) error {
+    var queued []pending
+    for _, a := range actions {
+        var err error
+        if async(a) {
+            queued = append(queued, pending{a.Command, r.queue(a.Command)})
+        } else {
+            r.wait(queued)
+            queued = nil
+            switch {
+            case a.Conditional != nil:
+                err = r.conditional(a.Conditional)
+            case a.Parallel != nil:
+                err = r.parallel(a.Parallel)
+            default:
+                err = r.action(a)
+            }
+        }
+        if release {
+            r.turns.release(r.t.targetUses(modes.Actions{a}))
+        }
+        if err != nil {
+            r.wait(queued)
+            return err
+        }
+    }
+    r.wait(queued)
+    return nil
+}
+
+// async returns true for the actions that do not wait for the ones before
+// them: commands for targets without an error policy
+func async(a modes.Action) bool {
+    if (a.Conditional != nil) || (a.Parallel != nil) {
+        return false
+    }
+    if strings.HasPrefix(strings.ToLower(a.Command), "claw::") {
+        return false
+    }
+    o := a.OnError
+    return (o == nil) || (!o.Abort && (o.Retry == 0) && (len(o.Fallback) == 0))
+}
+
+// queue queues a command once the run has its turn on the target
+func (r *actionRun) queue(cmdstring string) <-chan error {
+    r.turns.wait(strings.ToLower(strings.SplitN(cmdstring, "::", 2)[0]))
+    return r.t.Queue(cmdstring)
+}
+
+// wait adds the results of queued commands to the report
+func (r *actionRun) wait(queued []pending) {
+    for _, p := range queued {
+        err := <- p.result
+        if err != nil {
+            clog.Debug("Command '%s' failed: %s", p.command, err.Error())
+        }
+        r.report.add(Result{Command: p.command, Err: err, Tries: 1})
+    }
+}
+
+// parallel runs the lists of a parallel block at the same time, and waits
+// for all of them
+func (r *actionRun) parallel(block []modes.Actions) error {
+    var wg sync.WaitGroup
+    errs := make([]error, len(block))
+    for i := range block {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            errs[i] = r.sequence(block[i], false)
+        }(i)
+    }
+    wg.Wait()
+    for _, err := range errs {
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// conditional runs the then or else actions of a conditional action. When
+// the condition can not be evaluated neither run.
+func (r *actionRun) conditional(c *modes.Conditional) error {
+    if r.cond == nil {
+        r.report.add(Result{Command: "if " + c.If, Err: fmt.Errorf("conditions are not available"), Tries: 1})
+        return nil
+    }
+    ok, err := r.cond.Eval(c.If)
+    if err != nil {
+        r.report.add(Result{Command: "if " + c.If, Err: err, Tries: 1})
+        return nil
+    }
+    clog.Debug("Condition '%s' is %t", c.If, ok)
+    if ok {
+        return r.sequence(c.Then, false)
+    }
+    return r.sequence(c.Else, false)
+}
+
+// action runs a single action according to its error policy, it returns an
+// error when the rest of the list should not run
+func (r *actionRun) action(a modes.Action) error {
+    onerror := a.OnError
+    if onerror == nil {
+        onerror = &modes.OnError{}
+    }
+    res := Result{Command: a.Command, Tries: 1}
+    err := r.command(a.Command)
+    for (err != nil) && (res.Tries <= onerror.Retry) {
+        time.Sleep(onerror.Delay(res.Tries))
+        res.Tries++
+        err = r.command(a.Command)
+    }
+    res.Err = err
+    res.Fallback = (err != nil) && (len(onerror.Fallback) > 0)
+    i := r.report.add(res)
+    if err == nil {
+        return nil
+    }
+    if res.Fallback {
+        if r.sequence(onerror.Fallback.WithPolicy(abortOnError), false) == nil {
+            r.report.recovered(i, true)
+            return nil
+        }
+    }
+    if _, ok := err.(*waitError); ok {
+        return err
+    }
+    if onerror.Abort {
+        return err
+    }
+    return nil
+}
+
+// command runs a command, a claw::mode runs in this run
+func (r *actionRun) command(cmdstring string) error {
+    tgtname, cmd, args, err := r.t.parseCommand(cmdstring)
+    if (err == nil) && (tgtname == "claw") && (cmd == "mode") {
+        return r.setMode(args[0])
+    }
+    if err == nil {
+        r.turns.wait(tgtname)
+    }
+    return r.t.runCommand(cmdstring)
+}
+
+// setMode activates a mode, and runs the exit actions of the mode before it
+// and its entry actions as part of this run. Entry actions setting a mode
+// that is still being entered are refused.
+func (r *actionRun) setMode(name string) error {
+    for _, m := range r.modes {
+        if m == name {
+            return fmt.Errorf("aborted: attempting to recursively set mode '%s' while still setting it", name)
+        }
+    }
+    clog.Debug("Setting mode to: '%s'", name)
+    actions, err := r.t.modes.SetActive(name)
+    if err != nil {
+        return err
+    }
+    expanded, err := r.t.ExpandMacros(actions)
+    if err != nil {
+        return err
+    }
+    sub := *r
+    sub.modes = append(append([]string(nil), r.modes...), name)
+    // The results of the actions are in the report
+    sub.sequence(expanded, false)
+    return nil
+}
+
+// runCommand runs a command on the worker of its target
+func (t *TargetManager) runCommand(cmdstring string) error {
+    queued := time.Now()
+    err := <- t.Queue(cmdstring)
+    if err != nil {
+        clog.Debug("Command '%s' failed: %s", cmdstring, err.Error())
+    } else {
+        clog.Debug("Command '%s' done after %s", cmdstring, time.Since(queued).String())
+    }
+    return err
+}
+
+// State asks a target for its state, after the commands queued for it
+// before
+func (t *TargetManager) State(tgtname, name string) (string, error) {
+    tgtname = strings.ToLower(tgtname)
+    t.mu.RLock()
+    tgt, ok := t.targets[tgtname]
+    t.mu.RUnlock()
+    if !ok {
+        return "", fmt.Errorf("unknown target '%s'", tgtname)
+    }
+    sr, ok := tgt.(StateReader)
+    if !ok {
+        return "", fmt.Errorf("target '%s' can not report its state", tgtname)
+    }
+    var value string
+    err := <- t.queueFunc(tgtname, func() error {
+        var err error
+        value, err = sr.State(name)
+        return err
+    }, tgtname + "::" + name + " state")
+    return value, err
+}
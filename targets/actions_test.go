@@ -0,0 +1,310 @@
+package targets
+
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "strings"
+import "testing"
+import "encoding/json"
+
+import "github.com/cnf/go-claw/modes"
+
+// actionTarget logs its commands in a log shared by all targets, and
+// reports "power" as "on" after its third state query. Commands in
+// actionLog.fails fail that many times, "broken" always fails.
+type actionTarget struct {
+    name string
+    queries int
+}
+
+var actionLog struct {
+    sync.Mutex
+    sent []string
+    fails map[string]int
+}
+
+func (a *actionTarget) SendCommand(cmd string, args ...string) error {
+    if cmd == "slow" {
+        time.Sleep(20 * time.Millisecond)
+    }
+    actionLog.Lock()
+    defer actionLog.Unlock()
+    name := a.name + "::" + cmd
+    actionLog.sent = append(actionLog.sent, name)
+    if (cmd == "broken") || (actionLog.fails[name] > 0) {
+        actionLog.fails[name]--
+        return fmt.Errorf("%s failed", name)
+    }
+    return nil
+}
+
+func (a *actionTarget) State(name string) (string, error) {
+    a.queries++
+    if a.queries < 3 {
+        return "off", nil
+    }
+    return "on", nil
+}
+
+func (a *actionTarget) Stop() error {
+    return nil
+}
+
+func (a *actionTarget) Commands() map[string]*Command {
+    return nil
+}
+
+func sentActions() []string {
+    actionLog.Lock()
+    defer actionLog.Unlock()
+    ret := actionLog.sent
+    actionLog.sent = nil
+    return ret
+}
+
+func init() {
+    RegisterTarget("actiontest", func(name string, params map[string]string) (Target, error) {
+        return &actionTarget{name: name}, nil
+    })
+}
+
+func Test_Actions(t *testing.T) {
+    config := `["tv::a",["tv::slow",["avr::b","avr::c"]],"tv::d"]`
+    var actions modes.Actions
+    if err := json.Unmarshal([]byte(config), &actions); err != nil {
+        t.Fatal(err)
+    }
+    var indexes []string
+    actions.Map(func(index, command string) string {
+        indexes = append(indexes, index + "=" + command)
+        return command
+    })
+    if got := strings.Join(indexes, " "); got != "0=tv::a 1/0=tv::slow 1/1/0=avr::b 1/1/1=avr::c 2=tv::d" {
+        t.Errorf("wrong index paths: %s", got)
+    }
+    if data, err := json.Marshal(actions); (err != nil) || (string(data) != config) {
+        t.Errorf("expected %s, got %s (%v)", config, data, err)
+    }
+
+    tm := NewTargetManager(&modes.Modes{})
+    defer tm.Stop()
+    tm.Add("actiontest", "tv", nil)
+    tm.Add("actiontest", "avr", nil)
+
+    // The parallel block runs at the same time, tv::d waits for all of it
+    tm.run("test", actions, nil)
+    if got := strings.Join(sentActions(), ","); got != "tv::a,avr::b,avr::c,tv::slow,tv::d" {
+        t.Errorf("wrong order of actions: %s", got)
+    }
+
+    start := time.Now()
+    tm.run("test", modes.NewActions("claw::sleep 50ms", "tv::a"), nil)
+    if elapsed := time.Since(start); elapsed < 50 * time.Millisecond {
+        t.Errorf("claw::sleep returned after %s", elapsed)
+    }
+
+    defer func(poll time.Duration) { waitPoll = poll }(waitPoll)
+    waitPoll = 10 * time.Millisecond
+    if r := tm.run("test", modes.NewActions("claw::wait avr::power on", "avr::after"), nil); r.Failed() != 0 {
+        t.Errorf("unexpected error waiting for avr: %s", r)
+    }
+    // A wait that times out stops the rest of its list
+    if r := tm.run("test", modes.NewActions("claw::wait tv::power standby 50ms", "tv::after"), nil); !r.Aborted {
+        t.Errorf("expected claw::wait to time out")
+    }
+    // Also when the list goes on after other failed actions
+    if r := tm.run("test", modes.NewActions("claw::wait tv::power standby 50ms", "tv::after").WithPolicy(&modes.OnError{}), nil); !r.Aborted {
+        t.Errorf("expected claw::wait to stop a list without abort")
+    }
+    if got := strings.Join(sentActions(), ","); got != "tv::a,avr::after" {
+        t.Errorf("wrong actions after waiting: %s", got)
+    }
+    if err := tm.Check("claw::sleep soon"); err == nil {
+        t.Errorf("expected an error for an invalid duration")
+    }
+
+    // RunActions does not wait for the actions
+    start = time.Now()
+    tm.RunActions("test", modes.NewActions("claw::sleep 300ms", "tv::late"), nil)
+    if elapsed := time.Since(start); elapsed >= 300 * time.Millisecond {
+        t.Errorf("RunActions returned after %s", elapsed)
+    }
+    time.Sleep(400 * time.Millisecond)
+    if got := strings.Join(sentActions(), ","); got != "tv::late" {
+        t.Errorf("expected the actions to run in the background, got %s", got)
+    }
+}
+
+func Test_ErrorPolicies(t *testing.T) {
+    tm := NewTargetManager(&modes.Modes{})
+    defer tm.Stop()
+    tm.Add("actiontest", "tv", nil)
+    tm.Add("actiontest", "plex", nil)
+    actionLog.fails = map[string]int{"plex::play": 3}
+    defer func() { actionLog.fails = nil }()
+
+    var actions modes.Actions
+    err := json.Unmarshal([]byte(`[
+        "tv::on",
+        {"action": "plex::play", "onerror": {"retry": 1, "backoff": "10ms", "fallback": ["plex::wake", "plex::play"]}},
+        "tv::broken",
+        "tv::after"
+    ]`), &actions)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Without a policy, the list goes on after a failed action
+    r := tm.run("press of key `KEY_PLAY`", actions, nil)
+    if got := strings.Join(sentActions(), ","); got != "tv::on,plex::play,plex::play,plex::wake,plex::play,tv::broken,tv::after" {
+        t.Errorf("wrong actions run: %s", got)
+    }
+    expect := "press of key `KEY_PLAY`: 6 actions, 3 failed, in "
+    if (r.Failed() != 3) || r.Aborted || !strings.HasPrefix(r.String(), expect) {
+        t.Errorf("expected a report starting with `%s`, got `%s`", expect, r)
+    }
+    if res := r.Results[1]; (res.Tries != 2) || !res.Fallback || res.Recovered {
+        t.Errorf("wrong result for plex::play: %+v", res)
+    }
+    if res := r.Results[3]; (res.Command != "plex::play") || (res.Err == nil) {
+        t.Errorf("wrong result for the fallback: %+v", res)
+    }
+
+    // The retry and fallback recover, and abort stops at tv::broken
+    actionLog.fails = map[string]int{"plex::play": 2}
+    r = tm.run("press of key `KEY_PLAY`", actions.WithPolicy(&modes.OnError{Abort: true}), nil)
+    if got := strings.Join(sentActions(), ","); got != "tv::on,plex::play,plex::play,plex::wake,plex::play,tv::broken" {
+        t.Errorf("wrong actions run: %s", got)
+    }
+    if (r.Failed() != 1) || !r.Aborted || !r.Results[1].Recovered {
+        t.Errorf("wrong report: %s", r)
+    }
+    if !strings.Contains(r.String(), "`plex::play` 2 tries, failed: plex::play failed, recovered by its fallback") {
+        t.Errorf("wrong report: %s", r)
+    }
+
+    // The report has the commands queued before the action aborting
+    power := modes.NewActions("plex::on", "tv::on", "tv::broken", "tv::after")
+    power[2].OnError = &modes.OnError{Abort: true}
+    r = tm.run("press of key `KEY_POWER`", power, nil)
+    sentActions()
+    if (len(r.Results) != 3) || (r.Results[0].Command != "plex::on") || (r.Results[1].Command != "tv::on") {
+        t.Errorf("wrong report: %s", r)
+    }
+
+    // Lists run in a batch share a report, an abort only stops its own list
+    b := tm.newBatch("press of key `KEY_OK`", []modes.Actions{power, modes.NewActions("plex::after")}, nil)
+    r = b.run()
+    sentActions()
+    var cmds []string
+    for _, res := range r.Results {
+        cmds = append(cmds, res.Command)
+    }
+    sort.Strings(cmds)
+    if got := strings.Join(cmds, ","); (got != "plex::after,plex::on,tv::broken,tv::on") || !r.Aborted || (r.Failed() != 1) {
+        t.Errorf("wrong report for a batch: %s (%s)", r, got)
+    }
+    if tm.newBatch("nothing", []modes.Actions{nil, modes.NewActions("macro::missing")}, nil) != nil {
+        t.Errorf("expected an empty batch")
+    }
+
+    var bad modes.Actions
+    if err := json.Unmarshal([]byte(`[{"action": "tv::on", "onerror": {"backoff": "soon"}}]`), &bad); err == nil {
+        t.Errorf("expected an error for an invalid backoff")
+    }
+    if data, err := json.Marshal(actions[1:2]); (err != nil) || !strings.Contains(string(data), `"fallback":["plex::wake","plex::play"]`) {
+        t.Errorf("wrong json for an action with a policy: %s (%v)", data, err)
+    }
+}
+
+// fixedConditions holds the results of conditions
+type fixedConditions map[string]bool
+
+func (f fixedConditions) Eval(condition string) (bool, error) {
+    ok, found := f[condition]
+    if !found {
+        return false, fmt.Errorf("unknown condition")
+    }
+    return ok, nil
+}
+
+func Test_Conditional(t *testing.T) {
+    tm := NewTargetManager(&modes.Modes{})
+    defer tm.Stop()
+    tm.Add("actiontest", "avr", nil)
+    tm.Add("actiontest", "plex", nil)
+    tm.SetMacros(map[string]modes.Actions{
+        "on": modes.Actions{{Conditional: &modes.Conditional{If: "{1}::power == off", Then: modes.NewActions("{1}::poweron")}}},
+    })
+
+    var actions modes.Actions
+    err := json.Unmarshal([]byte(`[
+        "macro::on avr",
+        {"if": "plex::state == playing", "then": ["plex::pause"], "else": ["plex::select"]},
+        {"if": "nope", "then": ["plex::never"]},
+        "avr::after"
+    ]`), &actions)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expanded, err := tm.ExpandMacros(actions)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if c := expanded[0].Conditional; (c == nil) || (c.If != "avr::power == off") || (c.Then[0].Command != "avr::poweron") {
+        t.Errorf("wrong macro expansion: %+v", expanded[0])
+    }
+
+    cond := fixedConditions{"avr::power == off": true, "plex::state == playing": false}
+    r := tm.run("test", expanded, cond)
+    if got := strings.Join(sentActions(), ","); got != "avr::poweron,plex::select,avr::after" {
+        t.Errorf("wrong actions run: %s", got)
+    }
+    if (r.Failed() != 1) || (r.Results[2].Command != "if nope") {
+        t.Errorf("expected a failed condition in the report, got %s", r)
+    }
+
+    // Without conditions, the ones set are used
+    tm.SetConditions(fixedConditions{"plex::state == playing": true})
+    tm.run("test", actions[1:2], nil)
+    if got := strings.Join(sentActions(), ","); got != "plex::pause" {
+        t.Errorf("wrong actions run: %s", got)
+    }
+
+    if data, err := json.Marshal(actions[1:2]); (err != nil) || (string(data) != `[{"if":"plex::state == playing","then":["plex::pause"],"else":["plex::select"]}]`) {
+        t.Errorf("wrong json for a conditional action: %s (%v)", data, err)
+    }
+}
+
+func Test_ModeActions(t *testing.T) {
+    m := &modes.Modes{ModeMap: map[string]*modes.Mode{
+        "tv": &modes.Mode{Entry: modes.NewActions("avr::tv", "claw::mode music"), Exit: modes.NewActions("avr::off")},
+        "music": &modes.Mode{Entry: modes.NewActions("claw::mode tv", "avr::music")},
+    }}
+    tm := NewTargetManager(m)
+    defer tm.Stop()
+    tm.Add("actiontest", "avr", nil)
+
+    // Entering tv enters music, which may not switch back to tv
+    r := tm.run("test", modes.NewActions("claw::mode tv", "avr::after"), nil)
+    if got := strings.Join(sentActions(), ","); got != "avr::tv,avr::off,avr::music,avr::after" {
+        t.Errorf("wrong actions run: %s", got)
+    }
+    if (r.Failed() != 1) || (r.Results[2].Command != "claw::mode tv") || (m.ActiveName() != "music") {
+        t.Errorf("expected the recursive mode switch to fail, got %s", r)
+    }
+
+    // Mode switches of separate runs do not interfere
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := tm.RunCommand("claw::mode music"); err != nil {
+                t.Errorf("unexpected error: %s", err.Error())
+            }
+        }()
+    }
+    wg.Wait()
+    sentActions()
+}
@@ -0,0 +1,89 @@
+package cec
+
+import "fmt"
+import "strconv"
+
+import "github.com/cnf/go-claw/clog"
+import "github.com/cnf/go-claw/targets"
+import "github.com/cnf/go-claw/tools"
+
+// Cec controls devices over HDMI-CEC through cec-client
+type Cec struct {
+    name string
+    device int
+    client *tools.CecClient
+}
+
+// Register registers the cec module in the target manager
+func Register() {
+    targets.RegisterTarget("cec", Create)
+    targets.RegisterCommands("cec", func(params map[string]string) map[string]*targets.Command {
+        return (&Cec{}).Commands()
+    })
+}
+
+// Create creates a new cec target. The optional 'device' parameter is the
+// logical address of the device to control, 0 (the TV) by default.
+func Create(name string, params map[string]string) (targets.Target, error) {
+    c := &Cec{name: name}
+    if val, ok := params["device"]; ok {
+        dev, err := strconv.Atoi(val)
+        if (err != nil) || (dev < 0) || (dev > 15) {
+            return nil, fmt.Errorf("cec: invalid 'device' parameter '%s', expected 0 to 15", val)
+        }
+        c.device = dev
+    }
+    command := "cec-client"
+    if val, ok := params["command"]; ok {
+        command = val
+    }
+    c.client = tools.GetCecClient(command, params["port"])
+    if err := c.client.Start(); err != nil {
+        clog.Warn("cec: could not start %s: %s", command, err.Error())
+    }
+    return c, nil
+}
+
+// Commands returns the list of accepted cec commands
+func (c *Cec) Commands() map[string]*targets.Command {
+    return map[string]*targets.Command{
+        "poweron": targets.NewCommand("Powers on the device",
+                targets.NewParameter("device", "logical address of the device").SetRange(0, 15).SetOptional(),
+                ),
+        "standby": targets.NewCommand("Puts the device in standby",
+                targets.NewParameter("device", "logical address of the device").SetRange(0, 15).SetOptional(),
+                ),
+        "activesource": targets.NewCommand("Makes claw the active source"),
+        "volumeup": targets.NewCommand("Turns up the volume"),
+        "volumedown": targets.NewCommand("Turns down the volume"),
+        "mute": targets.NewCommand("Toggles mute"),
+    }
+}
+
+// Stop stops the cec target
+func (c *Cec) Stop() error {
+    return nil
+}
+
+// SendCommand sends a command to cec-client
+func (c *Cec) SendCommand(cmd string, args ...string) error {
+    dev := strconv.Itoa(c.device)
+    if len(args) > 0 {
+        dev = args[0]
+    }
+    switch cmd {
+    case "poweron":
+        return c.client.Send("on " + dev)
+    case "standby":
+        return c.client.Send("standby " + dev)
+    case "activesource":
+        return c.client.Send("as")
+    case "volumeup":
+        return c.client.Send("volup")
+    case "volumedown":
+        return c.client.Send("voldown")
+    case "mute":
+        return c.client.Send("mute")
+    }
+    return fmt.Errorf("command `%s` not found for module cec", cmd)
+}
@@ -0,0 +1,52 @@
+package cec
+
+import "time"
+import "testing"
+
+import "github.com/cnf/go-claw/tools"
+
+func Test_Target(t *testing.T) {
+    tgt, err := Create("tv", map[string]string{"command": "testdata/cec-client", "device": "4"})
+    if err != nil {
+        t.Fatalf("could not create cec target: %s", err.Error())
+    }
+    c := tgt.(*Cec)
+    c.client = tools.NewCecClient("testdata/cec-client", "")
+    lines, err := c.client.Lines()
+    if err != nil {
+        t.Fatalf("could not start fake cec-client: %s", err.Error())
+    }
+
+    var tests = []struct {
+        cmd string
+        args []string
+        expect string
+    }{
+        {"poweron", nil, "command: on 4"},
+        {"standby", []string{"0"}, "command: standby 0"},
+        {"activesource", nil, "command: as"},
+        {"volumeup", nil, "command: volup"},
+        {"mute", nil, "command: mute"},
+    }
+    for _, tc := range tests {
+        if err := c.SendCommand(tc.cmd, tc.args...); err != nil {
+            t.Errorf("%s: %s", tc.cmd, err.Error())
+            continue
+        }
+    WaitLoop:
+        for {
+            select {
+            case l := <- lines:
+                if l == tc.expect {
+                    break WaitLoop
+                }
+            case <- time.After(2 * time.Second):
+                t.Errorf("%s: timeout waiting for '%s'", tc.cmd, tc.expect)
+                break WaitLoop
+            }
+        }
+    }
+    if err := c.SendCommand("foo"); err == nil {
+        t.Errorf("expected an error for an unknown command")
+    }
+}
@@ -1,14 +1,14 @@
 package targets
 
 import "fmt"
+import "time"
 import "strings"
 
 import "github.com/cnf/go-claw/clog"
-//import "github.com/cnf/go-claw/modes"
+import "github.com/cnf/go-claw/modes"
 
 type clawTarget struct {
     targetmanager *TargetManager
-    modeactive string
 }
 
 // RegisterTarget("modes", createModes)
@@ -20,15 +20,21 @@ func (t *clawTarget) Commands() map[string]*Command {
 
 
     // Add the mode command
-    modelist := make([]string, len(t.targetmanager.modes.ModeMap))
-    i := 0
-    for m := range t.targetmanager.modes.ModeMap {
-        modelist[i] = m
-        i++
-    }
+    modelist := t.targetmanager.modes.Names()
     cmds["mode"] = NewCommand("Selects a mode", 
                        NewParameter("mode", "the mode to select").SetList(strings.Join(modelist, "|")),
                    )
+    cmds["learn"] = NewCommand("Switches learning mode on or off, or toggles it",
+                        NewParameter("state", "the learning state").SetList("on", "off", "toggle").SetOptional(),
+                    )
+    cmds["sleep"] = NewCommand("Waits before the next action",
+                        NewParameter("duration", "how long to wait, like 2s or 500ms").SetCustom("duration", validateDuration),
+                    )
+    cmds["wait"] = NewCommand("Waits until a target reports a state, like AVR::power on",
+                       NewParameter("state", "the target and state to poll").SetRegex(`^[^:\s]+::\S+$`),
+                       NewParameter("value", "the value to wait for").SetString(),
+                       NewParameter("timeout", "how long to wait at most, 10s by default").SetCustom("duration", validateDuration).SetOptional(),
+                   )
     // Add other internal modes
     return cmds
 }
@@ -37,45 +43,87 @@ func (t *clawTarget) Stop() error {
     return nil
 }
 
-func (t *clawTarget) setMode(cmd string, args ...string) error {
-    newmode := args[0]
+// setMode runs a mode switch that is not part of a list of actions, like
+// one run with RunCommand
+func (t *clawTarget) setMode(args ...string) error {
+    r := t.targetmanager.run("mode `" + args[0] + "`", modes.NewActions("claw::mode " + args[0]), nil)
+    r.log()
+    // The mode switch is added to the report after its actions
+    return r.Results[len(r.Results) - 1].Err
+}
 
-    if (t.modeactive != "") {
-        return fmt.Errorf("aborted: attempting to recursively set mode '%s' while still setting mode '%s'", cmd, t.modeactive)
-    }
-    t.modeactive = newmode
-    defer func() { t.modeactive = "" }()
+// waitPoll is the time between two state queries of claw::wait
+var waitPoll = 500 * time.Millisecond
 
-    clog.Debug("Setting mode to: '%s'", newmode)
-    str, err := t.targetmanager.modes.SetActive(newmode)
-    if err != nil {
-        return err
+// wait polls the state of a target until it has the expected value
+func (t *clawTarget) wait(args ...string) error {
+    timeout := 10 * time.Second
+    if len(args) > 2 {
+        timeout, _ = time.ParseDuration(args[2])
     }
-    var ret error
-    ret = nil
-    for i := 0; i < len(str); i++ {
-        err := t.targetmanager.RunCommand(str[i])
+    s := strings.SplitN(args[0], "::", 2)
+    deadline := time.Now().Add(timeout)
+    for {
+        value, err := t.targetmanager.State(s[0], s[1])
         if err != nil {
-            clog.Error("Command error while switching to mode '%s': %s", newmode, err.Error())
-            // Return last error?
-            ret = err
+            clog.Debug("claw::wait: %s", err.Error())
+        } else if strings.EqualFold(value, args[1]) {
+            return nil
         }
+        if time.Now().Add(waitPoll).After(deadline) {
+            return &waitError{fmt.Sprintf("'%s' was not '%s' after %s", args[0], args[1], timeout.String())}
+        }
+        time.Sleep(waitPoll)
+    }
+}
+
+// State returns the active mode for "mode"
+func (t *clawTarget) State(name string) (string, error) {
+    if name != "mode" {
+        return "", fmt.Errorf("unknown state '%s'", name)
     }
+    return t.targetmanager.modes.ActiveName(), nil
+}
+
+func validateDuration(value, validation string) (string, error) {
+    if _, err := time.ParseDuration(value); err != nil {
+        return "", fmt.Errorf("'%s' is not a duration, like 2s or 500ms", value)
+    }
+    return value, nil
+}
 
-    return ret
+func (t *clawTarget) learn(args ...string) error {
+    l := t.targetmanager.learner
+    if l == nil {
+        return fmt.Errorf("learning mode is not available")
+    }
+    on := !l.Learning()
+    if (len(args) > 0) && (args[0] != "toggle") {
+        on = (args[0] == "on")
+    }
+    l.SetLearning(on)
+    return nil
 }
 
 func (t *clawTarget) SendCommand(cmd string, args ...string) error {
     switch(cmd) {
     case "mode":
-        return t.setMode(cmd, args...)
+        return t.setMode(args...)
+    case "learn":
+        return t.learn(args...)
+    case "sleep":
+        d, _ := time.ParseDuration(args[0])
+        time.Sleep(d)
+        return nil
+    case "wait":
+        return t.wait(args...)
     default:
         return fmt.Errorf("clawtarget does not have a command %s", cmd)
     }
 }
 
 func createClawTarget(name string, params map[string]string) (Target, error) {
-    ret := &clawTarget{targetmanager: nil, modeactive: ""}
+    ret := &clawTarget{targetmanager: nil}
     return ret, nil
 }
 
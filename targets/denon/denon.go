@@ -4,6 +4,7 @@ import "net"
 import "fmt"
 import "time"
 import "errors"
+import "strconv"
 import "strings"
 
 import "github.com/cnf/go-claw/clog"
@@ -19,6 +20,10 @@ type Denon struct {
 
 func Register() {
     targets.RegisterTarget("denon", Create)
+    targets.RegisterCommands("denon", func(params map[string]string) map[string]*targets.Command {
+        return commandList(AVRX2000)
+    })
+    targets.RegisterStates("denon", "power", "mute", "volume")
 }
 
 func Create(name string, params map[string]string) (targets.Target, error) {
@@ -43,7 +48,7 @@ func setup(name string, host string, port int) *Denon {
 }
 
 func (d *Denon) Commands() map[string]*targets.Command {
-    return nil
+    return commandList(d.commands)
 }
 func (d *Denon) Stop() error {
     return nil
@@ -65,6 +70,46 @@ func (d *Denon) SendCommand(cmd string, args ...string) error {
     return fmt.Errorf("command `%s` not found for module denon", cmd)
 }
 
+// State returns "on" or "off" for "power" and "mute", and the volume in
+// percent for "volume"
+func (d *Denon) State(name string) (string, error) {
+    query := map[string]string{"power": "PW", "mute": "MU", "volume": "MV"}[name]
+    if query == "" {
+        return "", fmt.Errorf("unknown state '%s'", name)
+    }
+    rv, err := d.socketSend(query + "?")
+    if err != nil {
+        return "", err
+    }
+    // Only the first line answers the query
+    rv = strings.TrimSpace(strings.SplitN(rv, "\r", 2)[0])
+    if !strings.HasPrefix(rv, query) {
+        return "", fmt.Errorf("unexpected response '%s' to %s?", rv, query)
+    }
+    value := rv[len(query):]
+    switch name {
+    case "power":
+        if value == "ON" {
+            return "on", nil
+        }
+        return "off", nil
+    case "mute":
+        return strings.ToLower(value), nil
+    }
+    // Half steps are sent as a third digit, like MV455
+    if len(value) > 2 {
+        value = value[:2]
+    }
+    vol, err := strconv.Atoi(value)
+    if err != nil {
+        return "", fmt.Errorf("unexpected response '%s' to %s?", rv, query)
+    }
+    if vc, ok := d.commands["volume"].(VolumeCommand); ok {
+        vol = rangePercentage(vol, vc.Min, vc.Max)
+    }
+    return strconv.Itoa(vol), nil
+}
+
 func (d *Denon) Capabilities() []string {
     return []string{}
 }
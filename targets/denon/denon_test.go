@@ -0,0 +1,38 @@
+package denon
+
+import "net"
+import "bufio"
+import "strings"
+import "testing"
+
+func Test_State(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    replies := map[string]string{"PW?": "PWON\rZMON\r", "MU?": "MUOFF\r", "MV?": "MV495\rMVMAX 98\r"}
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            query, _ := bufio.NewReader(conn).ReadString('\r')
+            conn.Write([]byte(replies[strings.TrimSpace(query)]))
+            conn.Close()
+        }
+    }()
+
+    d := setup("avr", "127.0.0.1", ln.Addr().(*net.TCPAddr).Port)
+    d.commands = AVRX2000
+    tests := map[string]string{"power": "on", "mute": "off", "volume": "50"}
+    for name, expect := range tests {
+        if got, err := d.State(name); (err != nil) || (got != expect) {
+            t.Errorf("%s: expected '%s', got '%s' (%v)", name, expect, got, err)
+        }
+    }
+    if _, err := d.State("input"); err == nil {
+        t.Errorf("expected an error for an unknown state")
+    }
+}
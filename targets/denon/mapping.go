@@ -4,6 +4,8 @@ import "fmt"
 import "strconv"
 import "errors"
 
+import "github.com/cnf/go-claw/targets"
+
 // commanders[cmd].Command(cmd, args...)
 
 type Commander interface {
@@ -62,3 +64,34 @@ func percentageOfRange(pct int, min int, max int) int {
     // Return a percentage of a range
     return int(float32(pct*max + 100*min - pct*min) / 100)
 }
+
+// rangePercentage returns the percentage of a range a value is at, the
+// reverse of percentageOfRange
+func rangePercentage(val int, min int, max int) int {
+    if max <= min {
+        return 0
+    }
+    return int(float32((val - min) * 100) / float32(max - min) + 0.5)
+}
+
+// commandList returns the commands of a mapping, with the mute toggle
+func commandList(mapping map[string]Commander) map[string]*targets.Command {
+    cmds := map[string]*targets.Command{
+        "mute": targets.NewCommand("Toggles mute"),
+    }
+    for name, c := range mapping {
+        switch c := c.(type) {
+        case PlainCommand:
+            cmds[name] = targets.NewCommand(fmt.Sprintf("Sends %s", c.Send))
+        case RangeCommand:
+            cmds[name] = targets.NewCommand(fmt.Sprintf("Sends %s", c.Send),
+                targets.NewParameter("value", "the value to send").SetRange(c.Min, c.Max),
+            )
+        case VolumeCommand:
+            cmds[name] = targets.NewCommand("Sets the volume",
+                targets.NewParameter("volume", "the volume in percent").SetRange(0, 100),
+            )
+        }
+    }
+    return cmds
+}
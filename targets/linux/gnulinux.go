@@ -13,6 +13,9 @@ type Linux struct {
 
 func Register() {
     targets.RegisterTarget("linux", Create)
+    targets.RegisterCommands("linux", func(params map[string]string) map[string]*targets.Command {
+        return (&Linux{}).Commands()
+    })
 }
 
 func Create(name string, params map[string]string) (targets.Target, error) {
@@ -24,7 +27,9 @@ func Create(name string, params map[string]string) (targets.Target, error) {
 }
 
 func (d *Linux) Commands() map[string]*targets.Command {
-    return nil
+    return map[string]*targets.Command{
+        "poweron": targets.NewCommand("Wakes the machine with wake on lan"),
+    }
 }
 func (d *Linux) Stop() error {
     return nil
@@ -0,0 +1,187 @@
+package targets
+
+import "fmt"
+import "regexp"
+import "strconv"
+import "strings"
+
+import "github.com/cnf/go-claw/modes"
+
+// Macros are named lists of actions from the config, run by the actions
+// "macro::name" or "claw::macro name". Arguments after the name replace
+// {1} to {9} in the actions of the macro:
+//
+//   "macros": {"watch": ["TV::poweron", "AVR::power on", "AVR::input {1}"]}
+//   "KEY_RED": ["macro::watch dvd"]
+//
+// Macro calls are expanded before any of the actions run.
+
+var macroArg = regexp.MustCompile(`\{[1-9]\}`)
+
+// SetMacros replaces the macros
+func (t *TargetManager) SetMacros(macros map[string]modes.Actions) {
+    lower := make(map[string]modes.Actions, len(macros))
+    for name, actions := range macros {
+        lower[strings.ToLower(name)] = actions
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.macros = lower
+}
+
+// macroCall returns the name and arguments of a macro call
+func macroCall(cmdstring string) (string, []string, bool) {
+    var call string
+    lower := strings.ToLower(cmdstring)
+    switch {
+    case strings.HasPrefix(lower, "macro::"):
+        call = cmdstring[7:]
+    case strings.HasPrefix(lower + " ", "claw::macro "):
+        call = cmdstring[11:]
+    default:
+        return "", nil, false
+    }
+    args := splitQuoted(call)
+    if len(args) == 0 {
+        return "", nil, true
+    }
+    return strings.ToLower(args[0]), args[1:], true
+}
+
+// ExpandMacros returns actions with every macro call replaced by the
+// actions of the macro
+func (t *TargetManager) ExpandMacros(actions modes.Actions) (modes.Actions, error) {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
+    return t.expand(actions, nil)
+}
+
+// expand expands the macro calls in actions, stack holds the names of the
+// macros being expanded to find loops
+func (t *TargetManager) expand(actions modes.Actions, stack []string) (modes.Actions, error) {
+    var ret modes.Actions
+    for _, a := range actions {
+        if a.Conditional != nil {
+            c := *a.Conditional
+            var err error
+            if c.Then, err = t.expand(c.Then, stack); err != nil {
+                return nil, err
+            }
+            if c.Else, err = t.expand(c.Else, stack); err != nil {
+                return nil, err
+            }
+            ret = append(ret, modes.Action{Conditional: &c})
+            continue
+        }
+        if a.Parallel != nil {
+            block := make([]modes.Actions, len(a.Parallel))
+            for i, p := range a.Parallel {
+                var err error
+                if block[i], err = t.expand(p, stack); err != nil {
+                    return nil, err
+                }
+            }
+            ret = append(ret, modes.Action{Parallel: block})
+            continue
+        }
+        if (a.OnError != nil) && (len(a.OnError.Fallback) > 0) {
+            onerror := *a.OnError
+            var err error
+            if onerror.Fallback, err = t.expand(onerror.Fallback, stack); err != nil {
+                return nil, err
+            }
+            a.OnError = &onerror
+        }
+        name, args, ok := macroCall(a.Command)
+        if !ok {
+            ret = append(ret, a)
+            continue
+        }
+        body, err := t.macro(name, args, stack)
+        if err != nil {
+            return nil, err
+        }
+        if body, err = t.expand(body, append(stack, name)); err != nil {
+            return nil, err
+        }
+        // The error policy of a call is the one of the actions of the macro
+        ret = append(ret, body.WithPolicy(a.OnError)...)
+    }
+    return ret, nil
+}
+
+// macro returns the actions of a macro with the arguments filled in
+func (t *TargetManager) macro(name string, args []string, stack []string) (modes.Actions, error) {
+    if name == "" {
+        return nil, fmt.Errorf("missing macro name")
+    }
+    body, ok := t.macros[name]
+    if !ok {
+        return nil, fmt.Errorf("unknown macro '%s'", name)
+    }
+    for _, s := range stack {
+        if s == name {
+            return nil, fmt.Errorf("macro '%s' calls itself: %s", name, strings.Join(append(stack, name), " -> "))
+        }
+    }
+    var count int
+    countArgs := func(index, s string) string {
+        for _, arg := range macroArg.FindAllString(s, -1) {
+            if n := int(arg[1] - '0'); n > count {
+                count = n
+            }
+        }
+        return s
+    }
+    body.Map(countArgs)
+    body.MapConditions(countArgs)
+    if len(args) != count {
+        return nil, fmt.Errorf("macro '%s' takes %d arguments, got %d", name, count, len(args))
+    }
+    replace := make([]string, 0, 2 * len(args))
+    for i, arg := range args {
+        replace = append(replace, "{" + strconv.Itoa(i + 1) + "}", arg)
+    }
+    r := strings.NewReplacer(replace...)
+    replaceArgs := func(index, s string) string {
+        return r.Replace(s)
+    }
+    return body.Map(replaceArgs).MapConditions(replaceArgs), nil
+}
+
+// checkMacroCall checks a macro call and the actions it expands to
+func (t *TargetManager) checkMacroCall(cmdstring string) error {
+    t.mu.RLock()
+    actions, err := t.expand(modes.NewActions(cmdstring), nil)
+    t.mu.RUnlock()
+    if err != nil {
+        return err
+    }
+    for _, c := range actions.Commands() {
+        if err := t.check(c, false); err != nil {
+            return fmt.Errorf("`%s`: %s", c, err.Error())
+        }
+    }
+    return nil
+}
+
+// CheckMacro checks an action of a macro, like Check. Parameters taking
+// arguments of the macro are not checked.
+func (t *TargetManager) CheckMacro(name, cmdstring string) error {
+    name = strings.ToLower(name)
+    t.mu.RLock()
+    actions, err := t.expand(modes.NewActions(cmdstring), []string{name})
+    t.mu.RUnlock()
+    if err != nil {
+        return err
+    }
+    for _, c := range actions.Commands() {
+        if err := t.check(c, true); err != nil {
+            if c == cmdstring {
+                return err
+            }
+            return fmt.Errorf("`%s`: %s", c, err.Error())
+        }
+    }
+    return nil
+}
@@ -0,0 +1,44 @@
+package targets
+
+import "strings"
+import "testing"
+
+import "github.com/cnf/go-claw/modes"
+
+func Test_Macros(t *testing.T) {
+    tm := NewTargetManager(&modes.Modes{})
+    defer tm.Stop()
+    tm.SetMacros(map[string]modes.Actions{
+        "Watch": modes.Actions{
+            {Parallel: []modes.Actions{modes.NewActions("tv::on"), modes.NewActions("macro::input {1}")}},
+            {Command: "tv::play \"{2}\""},
+        },
+        "input": modes.NewActions("avr::power on", "avr::input {1}"),
+        "loop": modes.NewActions("macro::loop2"),
+        "loop2": modes.NewActions("claw::macro loop"),
+    })
+
+    actions, err := tm.ExpandMacros(modes.NewActions("tv::a", "macro::watch dvd \"the movie\"", "claw::macro input tv"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect := "tv::a,tv::on,avr::power on,avr::input dvd,tv::play \"the movie\",avr::power on,avr::input tv"
+    if got := strings.Join(actions.Commands(), ","); got != expect {
+        t.Errorf("expected %s, got %s", expect, got)
+    }
+    if len(actions[1].Parallel[1]) != 2 {
+        t.Errorf("expected the macro in the parallel block to run in sequence, got %v", actions[1].Parallel)
+    }
+
+    errors := map[string]string{
+        "macro::nope": "unknown macro 'nope'",
+        "macro::input": "macro 'input' takes 1 arguments, got 0",
+        "macro::loop": "macro 'loop' calls itself: loop -> loop2 -> loop",
+        "claw::macro": "missing macro name",
+    }
+    for call, expect := range errors {
+        if _, err := tm.ExpandMacros(modes.NewActions(call)); (err == nil) || (err.Error() != expect) {
+            t.Errorf("%s: expected error `%s`, got %v", call, expect, err)
+        }
+    }
+}
@@ -52,6 +52,10 @@ type rxCommand struct {
 // Register registers the Onkyo Module in the target manager
 func Register() {
     targets.RegisterTarget("onkyo", createOnkyoReceiver)
+    targets.RegisterCommands("onkyo", func(params map[string]string) map[string]*targets.Command {
+        return (&OnkyoReceiver{}).Commands()
+    })
+    targets.RegisterStates("onkyo", "power", "mute", "volume")
     //targets.RegisterAutoDetect(OnkyoAutoDetect)
 }
 
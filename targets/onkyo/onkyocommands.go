@@ -76,6 +76,34 @@ func (o *OnkyoReceiver) Power(state string) (string, error) {
     return rv, err
 }
 
+// State queries the receiver for "power", "mute" or "volume", for
+// claw::wait. Power and mute are "on" or "off", the volume is a number.
+func (o *OnkyoReceiver) State(name string) (string, error) {
+    query := map[string]string{"power": "PWR", "mute": "AMT", "volume": "MVL"}[name]
+    if query == "" {
+        return "", fmt.Errorf("unknown state '%s'", name)
+    }
+    rv, err := o.sendCmd(query + "QSTN", -1)
+    if err != nil {
+        return "", err
+    }
+    if (len(rv) < 5) || (rv[:3] != query) {
+        return "", fmt.Errorf("unexpected response '%s' to %sQSTN", rv, query)
+    }
+    value := rv[3:]
+    if name == "volume" {
+        v, err := strconv.ParseInt(value, 16, 0)
+        if err != nil {
+            return "", fmt.Errorf("unexpected response '%s' to %sQSTN", rv, query)
+        }
+        return strconv.FormatInt(v, 10), nil
+    }
+    if value == "01" {
+        return "on", nil
+    }
+    return "off", nil
+}
+
 // SetInput sets the input to the specified value. The suported inputs are type-specific
 func (o *OnkyoReceiver) SetInput(input string) error {
     _, err := o.sendCmd(fmt.Sprintf("SLI%s", input), 0)
@@ -2,6 +2,8 @@ package plex
 
 import "path"
 
+import "github.com/cnf/go-claw/targets"
+
 //
 type commander interface {
     command(args ...string) (string, error)
@@ -15,3 +17,22 @@ func (p plainCommand) command(args ...string) (string, error) {
     // validate path?
     return path.Clean("/" + p.Path), nil
 }
+
+// commandList returns the commands of a mapping, with the commands that
+// depend on the play state
+func commandList(mapping map[string]commander) map[string]*targets.Command {
+    cmds := map[string]*targets.Command{
+        "poweron": targets.NewCommand("Wakes the player"),
+        "smartup": targets.NewCommand("Moves up, or skips to the next item while playing"),
+        "smartdown": targets.NewCommand("Moves down, or skips to the previous item while playing"),
+        "smartleft": targets.NewCommand("Moves left, or steps back while playing"),
+        "smartright": targets.NewCommand("Moves right, or steps forward while playing"),
+        "smartselect": targets.NewCommand("Selects, or plays while playing"),
+    }
+    for name, c := range mapping {
+        if p, ok := c.(plainCommand); ok {
+            cmds[name] = targets.NewCommand("Requests " + p.Path)
+        }
+    }
+    return cmds
+}
@@ -48,6 +48,10 @@ type Plex struct {
 // Register this package in the target list
 func Register() {
     targets.RegisterTarget("plex", Create)
+    targets.RegisterCommands("plex", func(params map[string]string) map[string]*targets.Command {
+        return commandList(pht)
+    })
+    targets.RegisterStates("plex", "location", "state")
 }
 
 // Create a new instance of this target
@@ -70,7 +74,7 @@ func Create(name string, params map[string]string) (targets.Target, error) {
 }
 
 func (d *Plex) Commands() map[string]*targets.Command {
-    return nil
+    return commandList(d.commands)
 }
 func (d *Plex) Stop() error {
     return nil
@@ -221,25 +225,39 @@ func (p *Plex) getLocation() string {
 }
 
 func (p *Plex) isNav() bool {
+    return p.playState() != "playing"
+}
+
+// playState returns the state of the timeline shown full screen, like
+// "playing" or "paused", or "stopped" while navigating
+func (p *Plex) playState() string {
     loc := p.getLocation()
     p.tlmu.Lock()
     tls := p.timelines
     p.tlmu.Unlock()
-    if loc == "navigation" {
-        return true
-    }
     // navigation,fullScreenVideo,fullScreenPhoto,fullScreenMusic
-
-    if (loc == "fullScreenVideo") && (tls["video"].State == "playing") {
-        return false
-    }
-    if (loc == "fullScreenPhoto") && (tls["photo"].State == "playing") {
-        return false
+    timeline := map[string]string{
+        "fullScreenVideo": "video",
+        "fullScreenPhoto": "photo",
+        "fullScreenMusic": "music",
+    }[loc]
+    if (timeline == "") || (tls[timeline].State == "") {
+        return "stopped"
     }
-    if (loc == "fullScreenMusic") && (tls["music"].State == "playing") {
-        return false
+    return tls[timeline].State
+}
+
+// State returns the "location", like "navigation" or "fullScreenVideo",
+// or the play "state": "playing", "paused" or "stopped", for conditions
+// and claw::wait
+func (p *Plex) State(name string) (string, error) {
+    switch name {
+    case "location":
+        return p.getLocation(), nil
+    case "state":
+        return p.playState(), nil
     }
-    return true
+    return "", fmt.Errorf("unknown state '%s'", name)
 }
 
 func (p *Plex) powerOn() error {
@@ -0,0 +1,235 @@
+package targets
+
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+
+import "github.com/cnf/go-claw/clog"
+import "github.com/cnf/go-claw/modes"
+
+// Every target runs its commands in order on a worker of its own, so a
+// slow or unreachable device only delays its own commands.
+
+// QueueLength is the number of commands that can wait for a target, more
+// commands are dropped
+const QueueLength = 32
+
+// stopWait is how long stopping a queue waits for the commands still
+// waiting, the ones left after it are dropped
+var stopWait = 2 * time.Second
+
+type queuedCommand struct {
+    run func() error
+    result chan error
+}
+
+// queue holds the commands waiting for a target, done is closed when its
+// worker stopped, and stop when the commands left should be dropped
+type queue struct {
+    commands chan queuedCommand
+    done chan bool
+    stop chan bool
+}
+
+// Queue queues a command for the worker of its target, and returns a
+// channel receiving its result. Commands for the claw target run right
+// away, as they change how the following actions and keys are handled.
+func (t *TargetManager) Queue(cmdstring string) <-chan error {
+    result := make(chan error, 1)
+    tgtname, _, _, err := t.parseCommand(cmdstring)
+    if err != nil {
+        result <- err
+        return result
+    }
+    return t.queueFunc(tgtname, func() error {
+        return t.RunCommand(cmdstring)
+    }, cmdstring)
+}
+
+// queueFunc queues fn for the worker of a target, what describes it when it
+// is dropped
+func (t *TargetManager) queueFunc(tgtname string, fn func() error, what string) <-chan error {
+    result := make(chan error, 1)
+    if tgtname == "claw" {
+        result <- fn()
+        return result
+    }
+    t.qmu.Lock()
+    defer t.qmu.Unlock()
+    if t.queues == nil {
+        t.queues = make(map[string]*queue)
+    }
+    q, ok := t.queues[tgtname]
+    if !ok {
+        q = &queue{make(chan queuedCommand, QueueLength), make(chan bool), make(chan bool)}
+        t.queues[tgtname] = q
+        go t.worker(q)
+    }
+    select {
+    case q.commands <- queuedCommand{fn, result}:
+    default:
+        result <- fmt.Errorf("too many commands waiting for target '%s', dropped '%s'", tgtname, what)
+    }
+    return result
+}
+
+// worker runs the commands of a queue until it is closed, or drops them
+// once it is stopped
+func (t *TargetManager) worker(q *queue) {
+    defer close(q.done)
+    for c := range q.commands {
+        select {
+        case <- q.stop:
+            c.result <- fmt.Errorf("target stopped, command dropped")
+        default:
+            c.result <- c.run()
+        }
+    }
+}
+
+// stopQueue closes the queue of a target, and waits for its worker to run
+// the commands still waiting
+func (t *TargetManager) stopQueue(tgtname string) {
+    t.qmu.Lock()
+    q, ok := t.queues[tgtname]
+    if ok {
+        close(q.commands)
+        delete(t.queues, tgtname)
+    }
+    t.qmu.Unlock()
+    if ok {
+        waitQueues([]*queue{q})
+    }
+}
+
+// stopQueues closes all queues, and waits for their workers to run the
+// commands still waiting
+func (t *TargetManager) stopQueues() {
+    t.qmu.Lock()
+    var stopped []*queue
+    for k, q := range t.queues {
+        close(q.commands)
+        delete(t.queues, k)
+        stopped = append(stopped, q)
+    }
+    t.qmu.Unlock()
+    waitQueues(stopped)
+}
+
+// waitQueues waits up to stopWait for the workers of closed queues, and
+// has them drop the commands left after it. The command they are running
+// is not interrupted.
+func waitQueues(stopped []*queue) {
+    deadline := time.After(stopWait)
+    for _, q := range stopped {
+        select {
+        case <- q.done:
+        case <- deadline:
+            clog.Warn("Queues: commands still waiting after %s, dropping them", stopWait.String())
+            for _, q := range stopped {
+                close(q.stop)
+            }
+            return
+        }
+    }
+}
+
+// turns holds the turns a run of actions took on its targets: it waits
+// for the runs before it on a target before queuing commands for it, and
+// lets the runs after it go once it is done with it
+type turns struct {
+    mu sync.Mutex
+    prev map[string]chan bool
+    done map[string]chan bool
+    uses map[string]int
+}
+
+// takeTurns takes a turn on the targets of actions after the runs before
+func (t *TargetManager) takeTurns(actions modes.Actions) *turns {
+    tr := &turns{prev: make(map[string]chan bool), done: make(map[string]chan bool), uses: t.targetUses(actions)}
+    t.qmu.Lock()
+    defer t.qmu.Unlock()
+    if t.lastTurns == nil {
+        t.lastTurns = make(map[string]chan bool)
+    }
+    for tgtname := range tr.uses {
+        tr.prev[tgtname] = t.lastTurns[tgtname]
+        tr.done[tgtname] = make(chan bool)
+        t.lastTurns[tgtname] = tr.done[tgtname]
+    }
+    return tr
+}
+
+// targetUses counts the commands of actions by target, claw commands run
+// right away and do not count. A claw::mode counts for every target, as
+// the entry and exit actions it runs may use any of them.
+func (t *TargetManager) targetUses(actions modes.Actions) map[string]int {
+    uses := make(map[string]int)
+    for _, cmd := range actions.Commands() {
+        tgtname := strings.ToLower(strings.SplitN(cmd, "::", 2)[0])
+        if tgtname != "claw" {
+            uses[tgtname]++
+        } else if strings.HasPrefix(strings.ToLower(cmd), "claw::mode ") {
+            t.mu.RLock()
+            for name := range t.targets {
+                if name != "claw" {
+                    uses[name]++
+                }
+            }
+            t.mu.RUnlock()
+        }
+    }
+    return uses
+}
+
+// wait waits until the runs before are done with a target
+func (tr *turns) wait(tgtname string) {
+    tr.mu.Lock()
+    prev, ok := tr.prev[tgtname]
+    delete(tr.prev, tgtname)
+    tr.mu.Unlock()
+    if ok && (prev != nil) {
+        <- prev
+    }
+}
+
+// release lets the runs after go on the targets no action left uses, uses
+// counts the commands of the actions that ran
+func (tr *turns) release(uses map[string]int) {
+    tr.mu.Lock()
+    defer tr.mu.Unlock()
+    for tgtname, n := range uses {
+        if tr.uses[tgtname] -= n; tr.uses[tgtname] <= 0 {
+            tr.end(tgtname)
+        }
+    }
+}
+
+// releaseAll lets the runs after go on all targets
+func (tr *turns) releaseAll() {
+    tr.mu.Lock()
+    defer tr.mu.Unlock()
+    for tgtname := range tr.done {
+        tr.end(tgtname)
+    }
+}
+
+// end ends the turn on a target, once the runs before ended theirs
+func (tr *turns) end(tgtname string) {
+    done, ok := tr.done[tgtname]
+    if !ok {
+        return
+    }
+    delete(tr.done, tgtname)
+    prev := tr.prev[tgtname]
+    delete(tr.prev, tgtname)
+    if prev == nil {
+        close(done)
+        return
+    }
+    go func() {
+        <- prev
+        close(done)
+    }()
+}
@@ -0,0 +1,216 @@
+package targets
+
+import "sync"
+import "time"
+import "testing"
+
+import "github.com/cnf/go-claw/modes"
+
+// queueTarget records its commands, and blocks on "wait" until released
+type queueTarget struct {
+    mu sync.Mutex
+    sent []string
+    started chan bool
+    release chan bool
+}
+
+func (q *queueTarget) SendCommand(cmd string, args ...string) error {
+    if cmd == "wait" {
+        q.started <- true
+        <- q.release
+    }
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.sent = append(q.sent, cmd)
+    return nil
+}
+
+func (q *queueTarget) Stop() error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.sent = append(q.sent, "stopped")
+    return nil
+}
+
+func (q *queueTarget) Commands() map[string]*Command {
+    return nil
+}
+
+func (q *queueTarget) commands() []string {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return append([]string{}, q.sent...)
+}
+
+var queueTargets = make(map[string]*queueTarget)
+
+func init() {
+    RegisterTarget("queuetest", func(name string, params map[string]string) (Target, error) {
+        queueTargets[name] = &queueTarget{started: make(chan bool, 1), release: make(chan bool)}
+        return queueTargets[name], nil
+    })
+}
+
+func Test_Queue(t *testing.T) {
+    tm := NewTargetManager(&modes.Modes{})
+    defer tm.Stop()
+    tm.Add("queuetest", "slow", nil)
+    tm.Add("queuetest", "fast", nil)
+    slow, fast := queueTargets["slow"], queueTargets["fast"]
+
+    waited := tm.Queue("slow::wait")
+    after := tm.Queue("slow::after")
+    // A blocked target does not hold up the others
+    for _, cmd := range []string{"fast::one", "fast::two", "fast::three"} {
+        select {
+        case err := <- tm.Queue(cmd):
+            if err != nil {
+                t.Errorf("%s: %s", cmd, err)
+            }
+        case <- time.After(time.Second):
+            t.Fatalf("%s blocked by another target", cmd)
+        }
+    }
+    if sent := fast.commands(); (len(sent) != 3) || (sent[0] != "one") || (sent[2] != "three") {
+        t.Errorf("expected the commands in order, got %v", sent)
+    }
+
+    // The queue of a target is limited, "after" is waiting already
+    <- slow.started
+    var dropped int
+    for i := 0; i < QueueLength - 1 + 5; i++ {
+        select {
+        case <- tm.Queue("slow::more"):
+            dropped++
+        default:
+        }
+    }
+    if dropped != 5 {
+        t.Errorf("expected 5 dropped commands, got %d", dropped)
+    }
+
+    close(slow.release)
+    <- waited
+    <- after
+    if sent := slow.commands(); (len(sent) < 2) || (sent[0] != "wait") || (sent[1] != "after") {
+        t.Errorf("expected the commands in order, got %v", sent)
+    }
+
+    if err := <- tm.Queue("nope::cmd"); err == nil {
+        t.Errorf("expected an error for an unknown target")
+    }
+}
+
+func Test_QueueStop(t *testing.T) {
+    tm := NewTargetManager(&modes.Modes{})
+    tm.Add("queuetest", "busy", nil)
+    busy := queueTargets["busy"]
+
+    tm.Queue("busy::wait")
+    tm.Queue("busy::after")
+    <- busy.started
+    stopped := make(chan bool)
+    go func() {
+        tm.Stop()
+        close(stopped)
+    }()
+    // The target is stopped once its queued commands ran
+    select {
+    case <- stopped:
+        t.Fatalf("stopped while a command was running")
+    case <- time.After(50 * time.Millisecond):
+    }
+    close(busy.release)
+    <- stopped
+    if sent := busy.commands(); (len(sent) != 3) || (sent[1] != "after") || (sent[2] != "stopped") {
+        t.Errorf("expected the queued commands before stopping, got %v", sent)
+    }
+}
+
+// waitCommands waits for a queuetest target to have sent n commands
+func waitCommands(t *testing.T, q *queueTarget, n int) []string {
+    for i := 0; i < 100; i++ {
+        if sent := q.commands(); len(sent) >= n {
+            return sent
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("expected %d commands, got %v", n, q.commands())
+    return nil
+}
+
+func Test_QueueTurns(t *testing.T) {
+    tm := NewTargetManager(&modes.Modes{})
+    defer tm.Stop()
+    tm.Add("queuetest", "avr", nil)
+    tm.Add("queuetest", "tv", nil)
+    avr, tv := queueTargets["avr"], queueTargets["tv"]
+
+    // A blocked avr does not hold up the tv, in the same list or the next,
+    // and the tv commands run in the order of the key presses
+    tm.RunActions("press 1", modes.NewActions("avr::wait", "tv::x"), nil)
+    tm.RunActions("press 2", modes.NewActions("tv::y"), nil)
+    <- avr.started
+    if sent := waitCommands(t, tv, 2); (sent[0] != "x") || (sent[1] != "y") {
+        t.Errorf("expected the tv commands in order, got %v", sent)
+    }
+
+    // An action with an error policy waits for the actions before it, and
+    // the next press waits for it on its targets
+    actions := modes.NewActions("avr::wait", "tv::z")
+    actions[0].OnError = &modes.OnError{Abort: true}
+    tm.RunActions("press 3", actions, nil)
+    tm.RunActions("press 4", modes.NewActions("tv::w"), nil)
+    time.Sleep(50 * time.Millisecond)
+    if sent := tv.commands(); len(sent) != 2 {
+        t.Errorf("expected the tv to wait for avr, got %v", sent)
+    }
+    close(avr.release)
+    if sent := waitCommands(t, tv, 4); (sent[2] != "z") || (sent[3] != "w") {
+        t.Errorf("expected the tv commands in order, got %v", sent)
+    }
+}
+
+func Test_QueueTurnsModes(t *testing.T) {
+    m := &modes.Modes{ModeMap: map[string]*modes.Mode{
+        "tv": &modes.Mode{Entry: modes.NewActions("claw::sleep 50ms", "screen::on")},
+    }}
+    tm := NewTargetManager(m)
+    defer tm.Stop()
+    tm.Add("queuetest", "screen", nil)
+    screen := queueTargets["screen"]
+
+    // The entry actions of a mode take the turn of the press setting it
+    tm.RunActions("press 1", modes.NewActions("claw::mode tv"), nil)
+    tm.RunActions("press 2", modes.NewActions("screen::input"), nil)
+    if sent := waitCommands(t, screen, 2); (sent[0] != "on") || (sent[1] != "input") {
+        t.Errorf("expected the entry actions first, got %v", sent)
+    }
+}
+
+func Test_QueueStopTimeout(t *testing.T) {
+    defer func(wait time.Duration) { stopWait = wait }(stopWait)
+    stopWait = 50 * time.Millisecond
+    tm := NewTargetManager(&modes.Modes{})
+    tm.Add("queuetest", "stuck", nil)
+    stuck := queueTargets["stuck"]
+
+    tm.Queue("stuck::wait")
+    dropped := tm.Queue("stuck::after")
+    <- stuck.started
+    stopped := make(chan bool)
+    go func() {
+        tm.Stop()
+        close(stopped)
+    }()
+    // Stopping does not wait for the commands after stopWait
+    select {
+    case <- stopped:
+    case <- time.After(time.Second):
+        t.Fatalf("stopping waited for the queued commands")
+    }
+    close(stuck.release)
+    if err := <- dropped; err == nil {
+        t.Errorf("expected the waiting command to be dropped")
+    }
+}
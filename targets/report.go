@@ -0,0 +1,107 @@
+package targets
+
+import "fmt"
+import "sync"
+import "time"
+import "strings"
+
+import "github.com/cnf/go-claw/clog"
+
+// Report sums up the results of the actions run for a key event, or for
+// another reason, logged as a single line when they are done
+type Report struct {
+    // What the actions ran for, like "press of key `KEY_OK`"
+    What string
+    Start time.Time
+    Duration time.Duration
+    Results []Result
+    // Set when a failed action stopped the rest
+    Aborted bool
+
+    mu sync.Mutex
+}
+
+// Result is the result of a single action
+type Result struct {
+    Command string
+    // The error of the last try
+    Err error
+    // Times the action ran, more than once when it was retried
+    Tries int
+    // Set when the fallback actions ran, Recovered when none of them failed
+    Fallback bool
+    Recovered bool
+}
+
+// Failed returns the number of actions that failed, and were not recovered
+func (r *Report) Failed() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var failed int
+    for _, res := range r.Results {
+        if (res.Err != nil) && !res.Recovered {
+            failed++
+        }
+    }
+    return failed
+}
+
+func (r *Report) add(res Result) int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.Results = append(r.Results, res)
+    return len(r.Results) - 1
+}
+
+func (r *Report) recovered(i int, recovered bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.Results[i].Recovered = recovered
+}
+
+// finish sets the duration of the report, and marks it aborted
+func (r *Report) finish(aborted bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if aborted {
+        r.Aborted = true
+    }
+    r.Duration = time.Since(r.Start)
+}
+
+func (r *Report) String() string {
+    failed := r.Failed()
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    parts := []string{fmt.Sprintf("%s: %d actions, %d failed, in %s", r.What, len(r.Results), failed, r.Duration.String())}
+    if r.Aborted {
+        parts[0] += ", aborted"
+    }
+    for _, res := range r.Results {
+        var what []string
+        if res.Tries > 1 {
+            what = append(what, fmt.Sprintf("%d tries", res.Tries))
+        }
+        if res.Err != nil {
+            what = append(what, "failed: " + res.Err.Error())
+        }
+        if res.Recovered {
+            what = append(what, "recovered by its fallback")
+        } else if res.Fallback {
+            what = append(what, "its fallback failed too")
+        }
+        if what != nil {
+            parts = append(parts, fmt.Sprintf("`%s` %s", res.Command, strings.Join(what, ", ")))
+        }
+    }
+    return strings.Join(parts, "; ")
+}
+
+// log logs the report, as a warning if any action failed
+func (r *Report) log() {
+    if r.Failed() > 0 {
+        clog.Warn("Actions: %s", r)
+    } else {
+        clog.Debug("Actions: %s", r)
+    }
+}
@@ -5,15 +5,36 @@ import "errors"
 import "strings"
 import "unicode"
 import "time"
+import "sync"
 
 import "github.com/cnf/go-claw/clog"
 import "github.com/cnf/go-claw/modes"
 
 // TargetManager is the structure which manages all targets
 type TargetManager struct {
+    // mu guards targets, targetCmds, macros and conditions, commands run
+    // on the workers of the targets
+    mu sync.RWMutex
     targets map[string]Target
     targetCmds map[string]map[string]*Command
+    targetStates map[string][]string
+    macros map[string]modes.Actions
+    conditions Conditions
     modes *modes.Modes
+    learner Learner
+    // qmu guards queues, the command queues by target name
+    qmu sync.Mutex
+    queues map[string]*queue
+    // lastTurns holds by target name the channel closed when the last run
+    // of actions taking a turn on it is done with it
+    lastTurns map[string]chan bool
+}
+
+// Learner is implemented by whatever records keys for the claw::learn
+// command
+type Learner interface {
+    Learning() bool
+    SetLearning(on bool)
 }
 
 // NewTargetManager creates and initialize a new TargetManager object
@@ -37,10 +58,14 @@ func (t *TargetManager) Add(module, name string, params map[string]string) error
     if err := validateTargetName(name); err != nil {
         return err
     }
+    // Commands queued for a target of the same name do not run on this one
+    t.stopQueue(name)
+    t.mu.Lock()
+    defer t.mu.Unlock()
     // check if target already exists
     if _, ok := t.targets[name]; ok {
         clog.Warn("TargetManager::Add(): Target name already existed - removing first")
-        t.Remove(name)
+        t.remove(name)
     }
     // Check if the requested module exists
     if _, ok := targetlist[module]; !ok {
@@ -54,6 +79,7 @@ func (t *TargetManager) Add(module, name string, params map[string]string) error
         return err
     }
     t.targets[name] = tgt
+    t.targetStates[name] = statelist[module]
 
     // Special case - test if this is the modes target
     if mt, ok := tgt.(*clawTarget); ok {
@@ -64,19 +90,37 @@ func (t *TargetManager) Add(module, name string, params map[string]string) error
     tcmdlist := tgt.Commands()
     if tcmdlist == nil {
         clog.Warn("warning: %s::%s returned an empty command list!", module, name)
-    } else {
-        t.targetCmds[name] = make(map[string]*Command, len(tcmdlist))
-        for r := range(tcmdlist) {
-            t.targetCmds[name][strings.ToLower(r)] = tcmdlist[r]
-            t.targetCmds[name][r].Name = strings.ToLower(r)
-        }
     }
+    t.setCommands(name, tcmdlist)
 
     return nil
 }
 
-// Remove removes a target instance from the list
+// UpdateModes updates the modes claw::mode accepts, after they were
+// reloaded
+func (t *TargetManager) UpdateModes() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if tgt, ok := t.targets["claw"]; ok {
+        t.setCommands("claw", tgt.Commands())
+    }
+}
+
+// SetLearner sets the Learner switched on and off by claw::learn
+func (t *TargetManager) SetLearner(l Learner) {
+    t.learner = l
+}
+
+// Remove removes a target instance from the list, after the commands
+// queued for it ran
 func (t *TargetManager) Remove(name string) error {
+    t.stopQueue(name)
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return t.remove(name)
+}
+
+func (t *TargetManager) remove(name string) error {
     if _, ok := t.targets[name]; !ok {
         return errors.New("cannot remove " + name + ": does not exist")
     }
@@ -87,18 +131,25 @@ func (t *TargetManager) Remove(name string) error {
     if _, ok := t.targetCmds[name]; ok {
         delete(t.targetCmds, name)
     }
+    delete(t.targetStates, name)
     return nil
 }
 
-// Stop stops all target instances and removes them
+// Stop stops all target instances and removes them, after the commands
+// queued for them ran or stopWait passed
 func (t *TargetManager) Stop() error {
+    t.stopQueues()
+    t.mu.Lock()
     for k := range t.targets {
-        if err := t.Remove(k); err != nil {
+        if err := t.remove(k); err != nil {
+            t.mu.Unlock()
             return err
         }
     }
     t.targets    = make(map[string]Target)
     t.targetCmds = make(map[string]map[string]*Command)
+    t.targetStates = make(map[string][]string)
+    t.mu.Unlock()
     clog.Debug("TargetManager::Stop(): Adding internal claw target...")
     t.Add("claw", "claw", nil)
 
@@ -108,27 +159,137 @@ func (t *TargetManager) Stop() error {
 // RunCommand parses a given command, determines which target should run it,
 // checks the provided parameters, and if all is good - run the command.
 func (t *TargetManager) RunCommand(cmdstring string) error {
-    splitstr := strings.SplitN(cmdstring, "::", 2)
     tstart := time.Now()
+    tgtname, tcommand, tparams, err := t.parseCommand(cmdstring)
+    if err != nil {
+        if _, ok := err.(*CommandError); !ok {
+            clog.Error("%s", err.Error())
+        }
+        return err
+    }
+    t.mu.RLock()
+    tgt, ok := t.targets[tgtname]
+    t.mu.RUnlock()
+    if !ok {
+        return NewCommandError(tgtname, false, tcommand, false, tparams)
+    }
+    // Run the command
+    //clog.Debug("--> Process cmd '%s' took: %s", cmdstring, time.Since(tstart).String())
+    //tstart = time.Now()
+    err = tgt.SendCommand(tcommand, tparams...)
+    clog.Debug("--> Execute cmd '%s' took: %s", cmdstring, time.Since(tstart).String())
+    return err
+}
+
+// Check parses a command and checks its parameters like RunCommand, without
+// running it
+func (t *TargetManager) Check(cmdstring string) error {
+    if _, _, ok := macroCall(cmdstring); ok {
+        return t.checkMacroCall(cmdstring)
+    }
+    return t.check(cmdstring, false)
+}
+
+// check checks a command, with args set the parameters taking arguments of
+// a macro are not checked
+func (t *TargetManager) check(cmdstring string, args bool) error {
+    _, _, _, err := t.parseCommand(cmdstring)
+    if ce, ok := err.(*CommandError); ok {
+        if !ce.TargetFound() {
+            return fmt.Errorf("unknown target '%s'", ce.Target())
+        } else if ce.Command() == "" {
+            return fmt.Errorf("empty command for target '%s'", ce.Target())
+        } else if !ce.CommandFound() {
+            return fmt.Errorf("target '%s' has no command '%s'", ce.Target(), ce.Command())
+        }
+    }
+    if (err != nil) && args && macroArg.MatchString(cmdstring) {
+        return nil
+    }
+    return err
+}
+
+// AddMetadata adds the commands of a target without creating it, so
+// commands for it can be checked
+func (t *TargetManager) AddMetadata(module, name string, params map[string]string) error {
+    module = strings.ToLower(module)
+    name = strings.ToLower(name)
+    if err := validateTargetName(name); err != nil {
+        return err
+    }
+    if _, ok := targetlist[module]; !ok {
+        return fmt.Errorf("module '%s' is not registered", module)
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.targetCmds[name] = nil
+    if lister, ok := commandlist[module]; ok {
+        t.setCommands(name, lister(params))
+    }
+    t.targetStates[name] = statelist[module]
+    return nil
+}
+
+// CheckState checks that a target reports a state, by the states its
+// module registered
+func (t *TargetManager) CheckState(tgtname, name string) error {
+    tgtname = strings.ToLower(tgtname)
+    t.mu.RLock()
+    defer t.mu.RUnlock()
+    if _, ok := t.targetCmds[tgtname]; !ok {
+        return fmt.Errorf("unknown target '%s'", tgtname)
+    }
+    states := t.targetStates[tgtname]
+    if len(states) == 0 {
+        return fmt.Errorf("target '%s' can not report its state", tgtname)
+    }
+    for _, s := range states {
+        if strings.EqualFold(s, name) {
+            return nil
+        }
+    }
+    return fmt.Errorf("target '%s' has no state '%s', only %s", tgtname, name, strings.Join(states, ", "))
+}
+
+// setCommands stores the commands of a target by lower case name, with mu
+// held
+func (t *TargetManager) setCommands(name string, tcmdlist map[string]*Command) {
+    t.targetCmds[name] = nil
+    if tcmdlist == nil {
+        return
+    }
+    t.targetCmds[name] = make(map[string]*Command, len(tcmdlist))
+    for r := range(tcmdlist) {
+        t.targetCmds[name][strings.ToLower(r)] = tcmdlist[r]
+        tcmdlist[r].Name = strings.ToLower(r)
+    }
+}
+
+// parseCommand splits a command in a target name, command and parameters,
+// and validates the parameters if the target provided a command list
+func (t *TargetManager) parseCommand(cmdstring string) (string, string, []string, error) {
+    splitstr := strings.SplitN(cmdstring, "::", 2)
     if len(splitstr) != 2 {
-        return fmt.Errorf("invalid command string '%s', expected it to contain '::'", cmdstring)
+        return "", "", nil, fmt.Errorf("invalid command string '%s', expected it to contain '::'", cmdstring)
     }
     // Validate the name of the target we just parsed out
     if err := validateTargetName(splitstr[0]); err != nil {
-        return err
+        return "", "", nil, err
     }
     tgtname := strings.ToLower(splitstr[0])
 
-    if _, ok := t.targets[tgtname]; !ok {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
+    if _, ok := t.targetCmds[tgtname]; !ok {
         //return fmt.Errorf("command '%s' uses a target '%s' that does not exist", cmdstring, tgtname)
-        return NewCommandError(tgtname, false, splitstr[1], false, nil)
+        return "", "", nil, NewCommandError(tgtname, false, splitstr[1], false, nil)
     }
 
     // Split the command
     splitcmd := splitQuoted(splitstr[1])
     if len(splitcmd)  == 0 {
         //return fmt.Errorf("empty target command in '%s'", cmdstring)
-        return NewCommandError(tgtname, true, splitstr[1], false, nil)
+        return "", "", nil, NewCommandError(tgtname, true, "", false, nil)
     }
     tcommand := strings.ToLower(splitcmd[0])
     tparams := splitcmd[1:]
@@ -138,7 +299,7 @@ func (t *TargetManager) RunCommand(cmdstring string) error {
         // Check if the command exists for this target
         if _, ok := t.targetCmds[tgtname][tcommand]; !ok {
             //return fmt.Errorf("command '%s' not recognized by target '%s'", tcommand, tgtname)
-            return NewCommandError(tgtname, true, tcommand, false, tparams)
+            return "", "", nil, NewCommandError(tgtname, true, tcommand, false, tparams)
         }
         // Validate all parameters
         pc := 0
@@ -147,12 +308,7 @@ func (t *TargetManager) RunCommand(cmdstring string) error {
             if pc >= len(tparams) {
                 // Parameter not present, check if required
                 if !t.targetCmds[tgtname][tcommand].Parameters[prm].Optional {
-                    clog.Error("Non-optional parameter %s missing for command %s, target %s",
-                            t.targetCmds[tgtname][tcommand].Parameters[prm].Name,
-                            tcommand,
-                            tgtname,
-                        )
-                    return fmt.Errorf("non-optional parameter '%s' missing for command '%s', target '%s'",
+                    return "", "", nil, fmt.Errorf("non-optional parameter '%s' missing for command '%s', target '%s'",
                             t.targetCmds[tgtname][tcommand].Parameters[prm].Name,
                             tcommand,
                             tgtname,
@@ -162,12 +318,12 @@ func (t *TargetManager) RunCommand(cmdstring string) error {
                 pval, err := t.targetCmds[tgtname][tcommand].Parameters[prm].Validate(tparams[pc])
                 if (err != nil) {
                     // validation returned an error
-                    clog.Error("Parameter validation %s failed for command %s, target %s",
+                    return "", "", nil, fmt.Errorf("parameter '%s' of command '%s', target '%s': %s",
                             t.targetCmds[tgtname][tcommand].Parameters[prm].Name,
                             tcommand,
                             tgtname,
+                            err.Error(),
                         )
-                    return err
                 }
                 tparamsArr = append(tparamsArr, pval)
             }
@@ -176,12 +332,7 @@ func (t *TargetManager) RunCommand(cmdstring string) error {
         // replace the original parameters with the validated parameters
         tparams = tparamsArr
     }
-    // Run the command
-    //clog.Debug("--> Process cmd '%s' took: %s", cmdstring, time.Since(tstart).String())
-    //tstart = time.Now()
-    err := t.targets[tgtname].SendCommand(tcommand, tparams...)
-    clog.Debug("--> Execute cmd '%s' took: %s", cmdstring, time.Since(tstart).String())
-    return err
+    return tgtname, tcommand, tparams, nil
 }
 
 // Split a string containing quoted strings on newlines, quotes, ... 
@@ -280,7 +431,7 @@ func validateTargetName(name string) error {
         return errors.New("a target name cannot be empty")
     }
     if strings.ContainsAny(name, "\t\n\r :@!+=*") {
-        return fmt.Errorf("target name '%s' cannot contain whitespace, ':', '@', '!', '+', '=' or '*' characters")
+        return fmt.Errorf("target name '%s' cannot contain whitespace, ':', '@', '!', '+', '=' or '*' characters", name)
     }
     return nil
 }
@@ -14,6 +14,7 @@ type Target interface {
 
 func init() {
     RegisterTarget("claw", createClawTarget);
+    RegisterStates("claw", "mode")
 }
 
 // CreateTarget is a function definition each target must provide during registration
@@ -23,6 +24,34 @@ type CreateTarget func(name string, params map[string]string) (t Target, err err
 // targetlist is the global internal list of all registered targets
 var targetlist = make(map[string]CreateTarget)
 
+// ListCommands returns the commands a target module would accept with the
+// given parameters, without creating a target
+type ListCommands func(params map[string]string) map[string]*Command
+
+// commandlist holds the command listers of the modules that registered one
+var commandlist = make(map[string]ListCommands)
+
+// RegisterCommands registers a function listing the commands of a module,
+// so action strings can be checked without connecting to any device
+func RegisterCommands(name string, lister ListCommands) {
+    commandlist[strings.ToLower(name)] = lister
+}
+
+// statelist holds the states reported by the modules that registered them
+var statelist = make(map[string][]string)
+
+// RegisterStates registers the states the targets of a module report, so
+// conditions on them can be checked without connecting to any device
+func RegisterStates(name string, states ...string) {
+    statelist[strings.ToLower(name)] = states
+}
+
+// HasModule returns true if a target module is registered
+func HasModule(name string) bool {
+    _, ok := targetlist[strings.ToLower(name)]
+    return ok
+}
+
 // RegisterTarget is used by targets to register itself
 func RegisterTarget(name string, creator CreateTarget) {
     name = strings.ToLower(name)
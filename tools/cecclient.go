@@ -0,0 +1,134 @@
+package tools
+
+import "io"
+import "fmt"
+import "sync"
+import "bufio"
+import "errors"
+import "os/exec"
+
+import "github.com/cnf/go-claw/clog"
+
+// CecClient runs a cec-client process. Only one process can use the CEC
+// adapter at a time, so the process is shared by the cec listener and
+// target.
+type CecClient struct {
+    Command string
+    Args []string
+
+    cmd *exec.Cmd
+    stdin io.WriteCloser
+    watchers []chan string
+    mu sync.Mutex
+}
+
+var cecclients = make(map[string]*CecClient)
+var cecmu sync.Mutex
+
+// NewCecClient returns a cec-client for a command and adapter port, that is
+// not shared
+func NewCecClient(command, port string) *CecClient {
+    // Register as playback device, and log key presses
+    c := &CecClient{Command: command, Args: []string{"-t", "p", "-d", "16"}}
+    if port != "" {
+        c.Args = append(c.Args, port)
+    }
+    return c
+}
+
+// GetCecClient returns the shared cec-client for a command and adapter
+// port. A client whose process exited stays shared, and is restarted in
+// place by the next Start, Lines or Send.
+func GetCecClient(command, port string) *CecClient {
+    cecmu.Lock()
+    defer cecmu.Unlock()
+    key := command + " " + port
+    if c, ok := cecclients[key]; ok {
+        return c
+    }
+    c := NewCecClient(command, port)
+    cecclients[key] = c
+    return c
+}
+
+// Start starts the cec-client process, if it is not running yet
+func (c *CecClient) Start() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.start()
+}
+
+// start starts the process with mu held, so Lines can add its watcher
+// before the first line is read
+func (c *CecClient) start() error {
+    if c.cmd != nil {
+        return nil
+    }
+    cmd := exec.Command(c.Command, c.Args...)
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return err
+    }
+    if err := cmd.Start(); err != nil {
+        return err
+    }
+    clog.Debug("cec: started %s", c.Command)
+    c.cmd = cmd
+    c.stdin = stdin
+    go c.readLines(cmd, stdout)
+    return nil
+}
+
+func (c *CecClient) readLines(cmd *exec.Cmd, stdout io.Reader) {
+    sc := bufio.NewScanner(stdout)
+    for sc.Scan() {
+        c.mu.Lock()
+        watchers := c.watchers
+        c.mu.Unlock()
+        for _, w := range watchers {
+            w <- sc.Text()
+        }
+    }
+    err := cmd.Wait()
+    clog.Warn("cec: %s exited: %v", c.Command, err)
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    for _, w := range c.watchers {
+        close(w)
+    }
+    c.watchers = nil
+    c.cmd = nil
+    c.stdin = nil
+}
+
+// Lines starts cec-client if needed, and returns a channel receiving every
+// line it outputs. The channel is closed when the process exits.
+func (c *CecClient) Lines() (chan string, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err := c.start(); err != nil {
+        return nil, err
+    }
+    ch := make(chan string, 64)
+    c.watchers = append(c.watchers, ch)
+    return ch, nil
+}
+
+// Send sends a command to cec-client, like 'on 0' or 'standby 0'
+func (c *CecClient) Send(command string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err := c.start(); err != nil {
+        return err
+    }
+    if c.stdin == nil {
+        return errors.New("cec-client is not running")
+    }
+    clog.Debug("cec: sending '%s'", command)
+    _, err := fmt.Fprintf(c.stdin, "%s\n", command)
+    return err
+}
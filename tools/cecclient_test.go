@@ -0,0 +1,71 @@
+package tools
+
+import "os"
+import "time"
+import "strings"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+
+// waitLine waits for a line on a cec-client channel
+func waitLine(t *testing.T, lines chan string, expect string) {
+    for {
+        select {
+        case l, ok := <- lines:
+            if !ok {
+                t.Fatalf("cec-client exited waiting for '%s'", expect)
+            }
+            if l == expect {
+                return
+            }
+        case <- time.After(2 * time.Second):
+            t.Fatalf("timeout waiting for '%s'", expect)
+        }
+    }
+}
+
+func Test_CecClientRestart(t *testing.T) {
+    dir, err := ioutil.TempDir("", "claw")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    starts := filepath.Join(dir, "starts")
+    os.Setenv("CEC_STARTS", starts)
+    defer os.Unsetenv("CEC_STARTS")
+
+    // the target gets the shared client once, and keeps it
+    target := GetCecClient("testdata/cec-client", "")
+    lines, err := GetCecClient("testdata/cec-client", "").Lines()
+    if err != nil {
+        t.Fatalf("could not start fake cec-client: %s", err.Error())
+    }
+    if err := target.Send("q"); err != nil {
+        t.Fatalf("could not stop fake cec-client: %s", err.Error())
+    }
+    for range lines {
+    }
+
+    // the listener restarts through GetCecClient, the target through its
+    // own client
+    client := GetCecClient("testdata/cec-client", "")
+    if client != target {
+        t.Errorf("expected the exited client to stay shared")
+    }
+    if lines, err = client.Lines(); err != nil {
+        t.Fatalf("could not restart fake cec-client: %s", err.Error())
+    }
+    if err := target.Send("on 0"); err != nil {
+        t.Fatalf("could not send to the restarted cec-client: %s", err.Error())
+    }
+    waitLine(t, lines, "command: on 0")
+
+    data, err := ioutil.ReadFile(starts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := strings.Count(string(data), "started"); n != 2 {
+        t.Errorf("expected cec-client to be started 2 times, got %d", n)
+    }
+    target.Send("q")
+}